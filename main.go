@@ -8,7 +8,16 @@ import (
 	"syscall"
 
 	internalLog "github.com/hendrywilliam/siren/src"
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/audiosender"
+	"github.com/hendrywilliam/siren/src/ffmpeg"
 	"github.com/hendrywilliam/siren/src/gateway"
+	"github.com/hendrywilliam/siren/src/library"
+	"github.com/hendrywilliam/siren/src/loudness"
+	"github.com/hendrywilliam/siren/src/metadata"
+	"github.com/hendrywilliam/siren/src/storage"
+	"github.com/hendrywilliam/siren/src/transcache"
+	"github.com/hendrywilliam/siren/src/tts"
 	"github.com/hendrywilliam/siren/src/utils"
 	"github.com/joho/godotenv"
 )
@@ -36,6 +45,64 @@ func main() {
 	logger := slog.New(logHandler)
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
+	ffmpeg.Default = ffmpeg.NewSupervisor(ffmpeg.Options{
+		MaxProcesses: int(env.FFmpegMaxProcesses),
+		Log:          logger,
+	})
+	// Processes started with ctx are killed on shutdown, wait for them.
+	defer ffmpeg.Default.Close()
+	analyzer := loudness.NewAnalyzer(loudness.Options{
+		CachePath: env.LoudnessCache,
+		Log:       logger,
+	})
+	audio.ALLOWED_HOSTS = env.AllowedHosts
+	prober := metadata.NewProber()
+	lib := library.NewLibrary(library.Options{
+		Dirs:      env.MediaDirs,
+		IndexPath: env.LibraryIndex,
+		Prober:    prober,
+		Log:       logger,
+	})
+	go lib.Watch(ctx, env.LibraryScanInterval)
+	go func() {
+		for _, dir := range lib.Roots() {
+			if err := analyzer.AnalyzeDir(ctx, dir); err != nil && ctx.Err() == nil {
+				logger.Warn("loudness analysis stopped.", "error", err.Error())
+			}
+		}
+	}()
+	store, err := storage.NewFileStore(env.StorageDir)
+	if err != nil {
+		logger.Error("failed to open storage.", "error", err.Error())
+		os.Exit(1)
+	}
+	cache, err := transcache.NewCache(transcache.Options{
+		Dir:      env.TranscodeCacheDir,
+		MaxBytes: env.TranscodeCacheSize << 20,
+		Log:      logger,
+	})
+	if err != nil {
+		// Playback works without it, only slower to start.
+		logger.Warn("transcode cache disabled.", "error", err.Error())
+	}
+	opus := audio.OpusOverrides{
+		Bitrate:       int(env.OpusBitrate),
+		FrameDuration: env.OpusFrameDuration,
+		PacketLoss:    int(env.OpusPacketLoss),
+	}
+	if err := opus.Validate(); err != nil {
+		logger.Error("invalid opus settings.", "error", err.Error())
+		os.Exit(1)
+	}
+	speaker, err := tts.NewSpeaker(tts.Options{
+		Engine: env.TTSEngine,
+		Voice:  env.TTSVoice,
+		Path:   env.TTSPath,
+		Log:    logger,
+	})
+	if err != nil {
+		logger.Warn("text to speech disabled.", "error", err.Error())
+	}
 	g := gateway.NewGateway(gateway.DiscordArguments{
 		BotToken:   env.DiscordBotToken,
 		BotVersion: 10,
@@ -45,8 +112,26 @@ func main() {
 			gateway.GuildMessagesIntent,
 			gateway.MessageContentIntent,
 		},
-		ClientID: env.DiscordClientID,
-		Logger:   logger,
+		ClientID:             env.DiscordClientID,
+		RecordingDir:         env.RecordingDir,
+		RecordingMaxDuration: env.RecordingMaxDuration,
+		VoiceIdleTimeout:     env.VoiceIdleTimeout,
+		Loudness:             analyzer,
+		LoudnessTarget:       env.LoudnessTarget,
+		SFXDir:               env.SFXDir,
+		Speaker:              speaker,
+		Library:              lib,
+		Prober:               prober,
+		PlaylistDir:          env.PlaylistDir,
+		Store:                store,
+		TranscodeCache:       cache,
+		Opus:                 opus,
+		DAVE:                 env.DAVE,
+		AudioBuffer: audiosender.Buffer{
+			Size:    env.AudioBuffer,
+			PreRoll: env.AudioPreRoll,
+		},
+		Logger: logger,
 	})
 	g.Open(ctx)
 	<-ctx.Done()
@@ -77,6 +77,8 @@ func (i *InteractionAPI) originalInteractionRoute(applicationID, interactionToke
 type CreateInteractionResponseOptions struct {
 	InteractionResponse *structs.InteractionResponse
 	WithResponse        bool
+	// Sent as multipart form data when present.
+	Files []File
 }
 
 // Methods
@@ -87,6 +89,15 @@ func (i *InteractionAPI) Reply(ctx context.Context, interactionID string, intera
 		return nil, err
 	}
 
+	if len(options.Files) > 0 {
+		body, contentType, err := multipartBody(options.InteractionResponse, options.Files)
+		if err != nil {
+			return nil, err
+		}
+		return i.rest.Post(ctx, cbURL, body, &RESTOptions{
+			Headers: map[string]string{"Content-Type": contentType},
+		})
+	}
 	buf := &bytes.Buffer{}
 	err = json.NewEncoder(buf).Encode(options.InteractionResponse)
 	if err != nil {
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+)
+
+// File uploaded along a message, embeds reference it as attachment://<Name>.
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Source: https://discord.com/developers/docs/reference#uploading-files
+func multipartBody(payload any, files []File) (*bytes.Buffer, string, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="payload_json"`)
+	header.Set("Content-Type", "application/json")
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.NewEncoder(part).Encode(payload); err != nil {
+		return nil, "", err
+	}
+	for i, f := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="files[%d]"; filename="%s"`, i, f.Name))
+		if f.ContentType != "" {
+			header.Set("Content-Type", f.ContentType)
+		}
+		part, err := w.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(f.Data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf, w.FormDataContentType(), nil
+}
@@ -2,11 +2,57 @@ package api
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 )
 
+var (
+	ErrForbidden = errors.New("missing permissions")
+	ErrNotFound  = errors.New("not found")
+)
+
+// Error is a non 2xx response, matching ErrForbidden and ErrNotFound by
+// status.
+type Error struct {
+	StatusCode int
+	// Discord JSON error code, 0 if the body was not one.
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("discord api: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("discord api: status %d: %s (%d)", e.StatusCode, e.Message, e.Code)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// checkResponse returns the error of a non 2xx response, closing its body.
+func checkResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return nil
+	}
+	defer res.Body.Close()
+	e := &Error{}
+	data, _ := io.ReadAll(res.Body)
+	json.Unmarshal(data, e)
+	e.StatusCode = res.StatusCode
+	return e
+}
+
 type REST struct {
 	httpBaseURL string
 	httpClient  *http.Client
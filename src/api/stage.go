@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+// Topics are 1 to 120 characters.
+var MAX_STAGE_TOPIC_LENGTH = 120
+
+// StageAPI manages stage instances, which require the bot to be a stage
+// moderator.
+type StageAPI struct {
+	rest RESTClient
+}
+
+func NewStageAPI(rest RESTClient) *StageAPI {
+	return &StageAPI{
+		rest: rest,
+	}
+}
+
+// Routes
+func (s *StageAPI) stageInstancesRoute() (string, error) {
+	return url.JoinPath(s.rest.URL(), "/stage-instances")
+}
+
+func (s *StageAPI) stageInstanceRoute(channelID string) (string, error) {
+	return url.JoinPath(s.rest.URL(), "/stage-instances", channelID)
+}
+
+type CreateStageInstanceData struct {
+	ChannelID    string                    `json:"channel_id"`
+	Topic        string                    `json:"topic"`
+	PrivacyLevel structs.StagePrivacyLevel `json:"privacy_level,omitempty"`
+	// Notifies @everyone, requires Mention Everyone.
+	SendStartNotification bool `json:"send_start_notification,omitempty"`
+}
+
+type ModifyStageInstanceData struct {
+	Topic        string                    `json:"topic,omitempty"`
+	PrivacyLevel structs.StagePrivacyLevel `json:"privacy_level,omitempty"`
+}
+
+func (s *StageAPI) CreateStageInstance(ctx context.Context, data CreateStageInstanceData) (*structs.StageInstance, error) {
+	stageURL, err := s.stageInstancesRoute()
+	if err != nil {
+		return nil, err
+	}
+	return s.do(ctx, s.rest.Post, stageURL, data)
+}
+
+// GetStageInstance returns ErrNotFound if the stage is not live.
+func (s *StageAPI) GetStageInstance(ctx context.Context, channelID string) (*structs.StageInstance, error) {
+	stageURL, err := s.stageInstanceRoute(channelID)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(ctx, s.rest.Get, stageURL, nil)
+}
+
+func (s *StageAPI) ModifyStageInstance(ctx context.Context, channelID string, data ModifyStageInstanceData) (*structs.StageInstance, error) {
+	stageURL, err := s.stageInstanceRoute(channelID)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(ctx, s.rest.Patch, stageURL, data)
+}
+
+// DeleteStageInstance ends the stage.
+func (s *StageAPI) DeleteStageInstance(ctx context.Context, channelID string) error {
+	stageURL, err := s.stageInstanceRoute(channelID)
+	if err != nil {
+		return err
+	}
+	res, err := s.rest.Delete(ctx, stageURL, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+type requestFunc = func(ctx context.Context, url string, body io.Reader, options *RESTOptions) (*http.Response, error)
+
+func (s *StageAPI) do(ctx context.Context, request requestFunc, stageURL string, data any) (*structs.StageInstance, error) {
+	var body io.Reader
+	if data != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(data); err != nil {
+			return nil, err
+		}
+		body = buf
+	}
+	res, err := request(ctx, stageURL, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(res); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	instance := &structs.StageInstance{}
+	if err := json.NewDecoder(res.Body).Decode(instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
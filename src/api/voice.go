@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -86,3 +87,23 @@ func (v *VoiceAPI) GetUserVoiceState(ctx context.Context, guildID string, userID
 	}
 	return userVoiceState, err
 }
+
+// ModifyCurrentUserVoiceState changes the bot's stage speaker state.
+func (v *VoiceAPI) ModifyCurrentUserVoiceState(ctx context.Context, guildID string, state structs.ModifyCurrentUserVoiceState) error {
+	voiceStateURL, err := v.getCurrentUserVoiceStateRoute(guildID)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(state); err != nil {
+		return err
+	}
+	res, err := v.rest.Patch(ctx, voiceStateURL, buf, nil)
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
@@ -1,58 +1,374 @@
 package audio
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os/exec"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/ffmpeg"
+	"github.com/hendrywilliam/siren/src/ogg"
+	"github.com/hendrywilliam/siren/src/webm"
 )
 
 type Audio struct{}
 
 var MAX_BUFFER = 1024
 
-func (a *Audio) Encode(ctx context.Context, name string, data chan<- []byte, done chan bool) error {
+type EncodeOptions struct {
+	// Start position within the source.
+	Offset time.Duration
+	// Gain applied live through a PCM stage, nil encodes directly.
+	Volume *Volume
+	// Filters applied by ffmpeg, any filter disables passthrough.
+	Filters Chain
+	// Fixed gain in dB on top of the volume, e.g. loudness normalization.
+	GainDB float64
+
+	// Next returns the track following this one, called at the end of the
+	// source with the position reached in the output. The tracks play as
+	// one stream, overlapping for Crossfade, 0 being gapless. Each track's
+	// own Next is called at its end.
+	Next      NextFunc
+	Crossfade time.Duration
+
+	// Sounds mixed over the source.
+	Overlay *Overlay
+
+	// Encoder settings, passthrough sends the source as encoded.
+	Opus OpusOptions
+}
+
+// Linear gain of the PCM stage.
+func (opts EncodeOptions) gain() float64 {
+	gain := math.Pow(10, opts.GainDB/20)
+	if opts.Volume != nil {
+		gain *= opts.Volume.Gain()
+	}
+	return gain
+}
+
+// Opus packets demuxer.
+type PacketReader interface {
+	ReadPacket() ([]byte, error)
+}
+
+type bufferedSource struct {
+	*bufio.Reader
+	io.Closer
+}
+
+var errInterrupted = errors.New("stream interrupted")
+
+var (
+	// Only errors are logged, they are what errors are classified from.
+	globalArgs = []string{"-hide_banner", "-nostats", "-loglevel", "error"}
+	pcmArgs    = []string{
+		"-f", "s16le", // Raw signed 16 bit little endian.
+		"-ar", "48000", // 48K audio sampling rate.
+		"-ac", "2", // Stereo.
+	}
+)
+
+// Encode streams opus packets of the source, one frame per packet.
+// Stereo ogg opus and webm opus are demuxed as is, anything else is
+// re-encoded by ffmpeg.
+func (a *Audio) Encode(ctx context.Context, source AudioSource, opts EncodeOptions, data chan<- []byte, done chan bool) error {
+	format := source.Format()
+	var src io.ReadCloser
+	if format == FormatAuto {
+		rc, err := source.Open(ctx)
+		if err != nil {
+			finish(ctx, done)
+			return err
+		}
+		br := bufio.NewReaderSize(rc, PROBE_SIZE)
+		format = DetectFormat(br)
+		src = bufferedSource{Reader: br, Closer: rc}
+	}
+	passthrough := (opts.Volume == nil || opts.Volume.IsUnity()) && len(opts.Filters) == 0 && opts.GainDB == 0 &&
+		opts.Next == nil && (opts.Overlay == nil || !opts.Overlay.Active())
+	switch {
+	case passthrough && (format == FormatOggOpus || format == FormatWebMOpus):
+		return a.passthrough(ctx, source, src, format, opts, data, done)
+	default:
+		return a.transcode(ctx, source, src, format, opts, data, done)
+	}
+}
+
+// EncodeOverlay streams the overlay alone, until its sounds end.
+func (a *Audio) EncodeOverlay(ctx context.Context, overlay *Overlay, opus OpusOptions, data chan<- []byte, done chan bool) error {
+	return a.encodePCM(ctx, newOverlayReader(nil, overlay), opus, data, done)
+}
+
+// src is the already opened source, if any. With a volume, gain, next track
+// or overlay the source is decoded to PCM, processed in Go and a second
+// ffmpeg encodes it to opus. At unity volume one ffmpeg does it all, until
+// the volume changes or an overlay plays. That first change restarts ffmpeg
+// seeked to the position reached, the audio it had buffered is dropped and
+// playback may skip or glitch for a moment.
+func (a *Audio) transcode(ctx context.Context, source AudioSource, src io.ReadCloser, format SourceFormat, opts EncodeOptions, data chan<- []byte, done chan bool) error {
+	_, once := source.(*ReaderSource)
+	direct := opts.GainDB == 0 && opts.Next == nil &&
+		(opts.Volume == nil || (!once && opts.Volume.IsUnity())) &&
+		(opts.Overlay == nil || (!once && !opts.Overlay.Active()))
+	if direct {
+		args, in, err := inputArgs(ctx, source, src, format, opts)
+		if err != nil {
+			finish(ctx, done)
+			return err
+		}
+		if in != nil {
+			// Closing the source unblocks the copy if ffmpeg exits first.
+			defer in.Close()
+		}
+		stdout, stop, err := runFFmpeg(ctx, append(args, opts.Opus.outputArgs()...), in)
+		if err != nil {
+			finish(ctx, done)
+			return err
+		}
+		var interrupt func() bool
+		if opts.Volume != nil || opts.Overlay != nil {
+			interrupt = func() bool {
+				return (opts.Volume != nil && !opts.Volume.IsUnity()) || (opts.Overlay != nil && opts.Overlay.Active())
+			}
+		}
+		reader, err := ogg.NewOpusReader(stdout)
+		if err != nil {
+			// Nothing to play, let the caller move on.
+			finish(ctx, done)
+			stop()
+			return err
+		}
+		position, err := stream(ctx, reader, 0, data, done, interrupt)
+		// ffmpeg failing explains a demux error.
+		serr := stop()
+		if errors.Is(err, errInterrupted) {
+			if in != nil {
+				in.Close()
+			}
+			opts.Offset = resumeOffset(opts, position)
+			return a.transcode(ctx, source, nil, format, opts, data, done)
+		}
+		if serr != nil {
+			return serr
+		}
+		return err
+	}
+	pcm, err := decode(ctx, source, src, format, opts)
+	if err != nil {
+		finish(ctx, done)
+		return err
+	}
+	if opts.Next != nil {
+		pcm = newCrossfader(ctx, pcm, opts)
+	}
+	var r io.Reader = pcm
+	if opts.Overlay != nil {
+		r = newOverlayReader(pcm, opts.Overlay)
+	}
+	err = a.encodePCM(ctx, r, opts.Opus, data, done)
+	// The decoder failing explains an encoder error.
+	if cerr := pcm.Close(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// resumeOffset is the source position a restarted transcode seeks to, after
+// position of output was streamed. Filters may play the source faster or
+// slower than the output.
+func resumeOffset(opts EncodeOptions, position time.Duration) time.Duration {
+	return opts.Offset + time.Duration(float64(position)*opts.Filters.Speed())
+}
+
+// encodePCM encodes PCM read from pcm to opus packets.
+func (a *Audio) encodePCM(ctx context.Context, pcm io.Reader, opus OpusOptions, data chan<- []byte, done chan bool) error {
+	encoderArgs := append(append(slices.Clone(pcmArgs), "-i", "pipe:0"), opus.outputArgs()...)
+	stdout, stop, err := runFFmpeg(ctx, encoderArgs, pcm)
+	if err != nil {
+		finish(ctx, done)
+		return err
+	}
+	err = demux(ctx, stdout, data, done)
+	if serr := stop(); serr != nil {
+		return serr
+	}
+	return err
+}
+
+func demux(ctx context.Context, out io.Reader, data chan<- []byte, done chan bool) error {
+	reader, err := ogg.NewOpusReader(out)
+	if err != nil {
+		// Nothing to play, let the caller move on.
+		finish(ctx, done)
+		return err
+	}
+	_, err = stream(ctx, reader, 0, data, done, nil)
+	return err
+}
+
+// inputArgs returns ffmpeg input arguments and the reader to feed to stdin,
+// nil if ffmpeg reads the path itself.
+func inputArgs(ctx context.Context, source AudioSource, src io.ReadCloser, format SourceFormat, opts EncodeOptions) ([]string, io.ReadCloser, error) {
+	args := []string{}
+	if opts.Offset > 0 {
+		// Input seeking, fast and accurate with re-encoding.
+		args = append(args, "-ss", fmt.Sprintf("%.3f", opts.Offset.Seconds()))
+	}
+	if format == FormatPCM {
+		args = append(args, pcmArgs...)
+	}
+	input := "pipe:0"
+	if ps, ok := source.(PathSource); ok {
+		input = ps.Path()
+		if src != nil {
+			// Only opened for probing.
+			src.Close()
+			src = nil
+		}
+	} else if src == nil {
+		var err error
+		src, err = source.Open(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	args = append(args, "-i", input)
+	if expr := opts.Filters.Expression(); expr != "" {
+		args = append(args, "-af", expr)
+	}
+	return args, src, nil
+}
+
+type pcmStream struct {
+	io.Reader
+	src  io.Closer
+	stop func() error
+}
+
+func (s *pcmStream) Close() error {
+	err := s.stop()
+	if s.src != nil {
+		s.src.Close()
+	}
+	return err
+}
+
+// decode starts ffmpeg decoding the source to PCM, gain is applied on read.
+func decode(ctx context.Context, source AudioSource, src io.ReadCloser, format SourceFormat, opts EncodeOptions) (io.ReadCloser, error) {
+	args, in, err := inputArgs(ctx, source, src, format, opts)
+	if err != nil {
+		return nil, err
+	}
+	decoderArgs := append(append(args, pcmArgs...), "-")
+	stdout, stop, err := runFFmpeg(ctx, decoderArgs, in)
+	if err != nil {
+		if in != nil {
+			in.Close()
+		}
+		return nil, err
+	}
+	return &pcmStream{
+		Reader: newGainReader(stdout, opts.gain),
+		src:    in,
+		stop:   stop,
+	}, nil
+}
+
+// runFFmpeg starts ffmpeg with stdin fed from in, if any, and returns its
+// stdout. stop closes stdout, so ffmpeg exits even if not fully read, and
+// waits for it. stop returns an *ffmpeg.Error if ffmpeg failed on its own.
+func runFFmpeg(ctx context.Context, args []string, in io.Reader) (io.Reader, func() error, error) {
+	p, err := ffmpeg.Start(ctx, append(slices.Clone(globalArgs), args...), in)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, p.Stop, nil
+}
+
+// Pre-encoded opus is demuxed without ffmpeg, seeking skips packets.
+// Changing the volume or playing an overlay switches to transcoding at the
+// current position.
+func (a *Audio) passthrough(ctx context.Context, source AudioSource, src io.ReadCloser, format SourceFormat, opts EncodeOptions, data chan<- []byte, done chan bool) error {
 	var err error
-	cmd := exec.CommandContext(ctx,
-		"ffmpeg",
-		"-i",
-		fmt.Sprintf("./media/%s", name),
-		"-ac",     // Set channel
-		"2",       // Stereo
-		"-ar",     // Set audio sampling rate.
-		"48000",   // 48K audio sampling rate.
-		"-c:a",    // Set audio codec
-		"libopus", // Audio codec opus
-		"-f",      // Force format option.
-		"opus",    // Force format to opus.
-		"-",       // Stream to stdout.
-	)
-	stdout, err := cmd.StdoutPipe()
+	if src == nil {
+		src, err = source.Open(ctx)
+		if err != nil {
+			finish(ctx, done)
+			return err
+		}
+	}
+	defer src.Close()
+	var reader PacketReader
+	if format == FormatWebMOpus {
+		reader, err = webm.NewOpusReader(src)
+	} else {
+		reader, err = ogg.NewOpusReader(src)
+	}
 	if err != nil {
-		log.Fatal(err.Error())
+		finish(ctx, done)
+		return err
 	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err.Error())
+	var interrupt func() bool
+	// Reader sources can not be reopened.
+	if _, once := source.(*ReaderSource); !once && (opts.Volume != nil || opts.Overlay != nil) {
+		interrupt = func() bool {
+			return (opts.Volume != nil && !opts.Volume.IsUnity()) || (opts.Overlay != nil && opts.Overlay.Active())
+		}
+	}
+	position, err := stream(ctx, reader, opts.Offset, data, done, interrupt)
+	if !errors.Is(err, errInterrupted) {
+		return err
+	}
+	src.Close()
+	opts.Offset = position
+	return a.transcode(ctx, source, nil, format, opts, data, done)
+}
+
+// Stream packets, the ones before offset are skipped. Returns the position
+// reached, interrupt is checked before each packet.
+func stream(ctx context.Context, reader PacketReader, offset time.Duration, data chan<- []byte, done chan bool, interrupt func() bool) (time.Duration, error) {
+	skip := int64(offset.Seconds() * ogg.OpusSampleRate)
+	var read int64
+	position := func() time.Duration {
+		return time.Duration(read) * time.Second / ogg.OpusSampleRate
 	}
-	defer cmd.Wait()
-	buff := make([]byte, 1024) // 1KB
 	for {
-		n, err := stdout.Read(buff)
+		if interrupt != nil && interrupt() {
+			return position(), errInterrupted
+		}
+		packet, err := reader.ReadPacket()
 		if err != nil {
+			finish(ctx, done)
 			if errors.Is(err, io.EOF) {
-				done <- true
-				break
+				return position(), nil
 			}
-			return err
+			return position(), err
+		}
+		samples, err := ogg.OpusPacketSamples(packet)
+		if err == nil {
+			read += int64(samples)
+		}
+		if skip > 0 && err == nil {
+			skip -= int64(samples)
+			continue
 		}
-		if n > 0 {
-			d := make([]byte, n)
-			copy(d, buff[:n])
-			fmt.Println(d)
-			data <- d
+		select {
+		case data <- packet:
+		case <-ctx.Done():
+			return position(), nil
 		}
 	}
-	return nil
+}
+
+func finish(ctx context.Context, done chan bool) {
+	select {
+	case done <- true:
+	case <-ctx.Done():
+	}
 }
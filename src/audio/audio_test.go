@@ -0,0 +1,26 @@
+package audio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResumeOffset(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     EncodeOptions
+		position time.Duration
+		want     time.Duration
+	}{
+		{"start", EncodeOptions{}, 10 * time.Second, 10 * time.Second},
+		{"seeked", EncodeOptions{Offset: time.Minute}, 10 * time.Second, 70 * time.Second},
+		{"tempo", EncodeOptions{Filters: Chain{Tempo{Factor: 1.5}}}, 10 * time.Second, 15 * time.Second},
+		{"nightcore", EncodeOptions{Offset: 5 * time.Second, Filters: Chain{Resample{Rate: 1.25}, Tempo{Factor: 0.8}}}, 10 * time.Second, 15 * time.Second},
+		{"pitch", EncodeOptions{Filters: Chain{Pitch{Factor: 2}}}, 10 * time.Second, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := resumeOffset(tt.opts, tt.position); got != tt.want {
+			t.Errorf("%s: resumed at %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
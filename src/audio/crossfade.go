@@ -0,0 +1,256 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Bytes of one stereo sample.
+const pcmSampleSize = PCMChannels * 2
+
+const MaxCrossfade = 12 * time.Second
+
+// Time the next track is opened ahead of its fade, covers starting ffmpeg
+// and slow http sources.
+var CROSSFADE_LEAD = 2 * time.Second
+
+// NextFunc returns the source following the current one, false to end the
+// stream. position is the output duration at which the next source starts.
+type NextFunc func(position time.Duration) (AudioSource, EncodeOptions, bool)
+
+// Crossfade setting of a guild, disabled by default.
+type Crossfade struct {
+	// Negative when disabled.
+	duration atomic.Int64
+}
+
+func NewCrossfade() *Crossfade {
+	c := &Crossfade{}
+	c.duration.Store(-1)
+	return c
+}
+
+// Set overlap between tracks, 0 plays them gapless.
+func (c *Crossfade) Set(d time.Duration) {
+	c.duration.Store(int64(min(max(d, 0), MaxCrossfade)))
+}
+
+func (c *Crossfade) Disable() {
+	c.duration.Store(-1)
+}
+
+// Get returns the overlap, false if transitions are disabled.
+func (c *Crossfade) Get() (time.Duration, bool) {
+	d := c.duration.Load()
+	return time.Duration(d), d >= 0
+}
+
+// crossfader plays PCM tracks back to back as one stream. The lead and last
+// fade of the current track are held back. Once it ends the next track is
+// opened while the lead plays, then the fade is mixed with its start using
+// equal power curves.
+type crossfader struct {
+	ctx       context.Context
+	cur       io.ReadCloser
+	next      NextFunc
+	decode    func(ctx context.Context, source AudioSource, src io.ReadCloser, format SourceFormat, opts EncodeOptions) (io.ReadCloser, error)
+	fadeBytes int
+	leadBytes int
+
+	frame [PCMFrameSize]byte
+	// Held back part of the current track is ahead[aheadStart:].
+	ahead      []byte
+	aheadStart int
+	// Rest of the ended track while the next one opens.
+	tail    []byte
+	pending chan prefetched
+	out     []byte
+	written int64
+}
+
+// Next track opened and the part of it fading in.
+type prefetched struct {
+	pcm  io.ReadCloser
+	opts EncodeOptions
+	head []byte
+	ok   bool
+	err  error
+}
+
+func newCrossfader(ctx context.Context, cur io.ReadCloser, opts EncodeOptions) *crossfader {
+	return &crossfader{
+		ctx:       ctx,
+		cur:       cur,
+		next:      opts.Next,
+		decode:    decode,
+		fadeBytes: fadeBytes(opts.Crossfade),
+		leadBytes: fadeBytes(CROSSFADE_LEAD),
+	}
+}
+
+func fadeBytes(d time.Duration) int {
+	return int(d.Seconds()*PCMSampleRate) * pcmSampleSize
+}
+
+func (c *crossfader) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if err := c.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+func (c *crossfader) Close() error {
+	if c.pending != nil {
+		// Close the next track once it opened.
+		go func(pending <-chan prefetched) {
+			if p := <-pending; p.pcm != nil {
+				p.pcm.Close()
+			}
+		}(c.pending)
+		c.pending = nil
+	}
+	if c.cur != nil {
+		return c.cur.Close()
+	}
+	return nil
+}
+
+// Fill out with the next chunk of the stream.
+func (c *crossfader) fill() error {
+	if c.pending != nil {
+		return c.fillTail()
+	}
+	if c.cur == nil {
+		return io.EOF
+	}
+	n, err := io.ReadFull(c.cur, c.frame[:])
+	n -= n % pcmSampleSize
+	if n > 0 {
+		c.ahead = append(c.ahead, c.frame[:n]...)
+		if excess := len(c.ahead) - c.aheadStart - c.fadeBytes - c.leadBytes; excess > 0 {
+			c.emit(c.ahead[c.aheadStart : c.aheadStart+excess])
+			c.aheadStart += excess
+		}
+		// Compact once the consumed part dominates, keeps copies amortized.
+		if c.aheadStart > len(c.ahead)/2 {
+			c.ahead = append(c.ahead[:0], c.ahead[c.aheadStart:]...)
+			c.aheadStart = 0
+		}
+		return nil
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	c.cur.Close()
+	c.cur = nil
+	c.tail = c.ahead[c.aheadStart:]
+	c.ahead, c.aheadStart = nil, 0
+	fade := min(len(c.tail), c.fadeBytes)
+	position := c.written + int64((len(c.tail)-fade)/pcmSampleSize)
+	c.pending = make(chan prefetched, 1)
+	go c.prefetch(c.pending, position, fade)
+	return c.fillTail()
+}
+
+// fillTail plays the lead of the ended track while the next one opens and
+// mixes the fade once it did.
+func (c *crossfader) fillTail() error {
+	fade := min(len(c.tail), c.fadeBytes)
+	if lead := len(c.tail) - fade; lead > 0 {
+		n := min(lead, PCMFrameSize)
+		c.emit(c.tail[:n])
+		c.tail = c.tail[n:]
+		return nil
+	}
+	var p prefetched
+	select {
+	case p = <-c.pending:
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+	c.pending = nil
+	tail := c.tail
+	c.tail = nil
+	if !p.ok {
+		if len(tail) == 0 {
+			return io.EOF
+		}
+		c.emit(tail)
+		return nil
+	}
+	c.cur = p.pcm
+	// The stream ends after a source without next.
+	c.next = p.opts.Next
+	c.fadeBytes = fadeBytes(p.opts.Crossfade)
+	if p.err != nil {
+		return p.err
+	}
+	if len(tail) == 0 {
+		return nil
+	}
+	mix(tail, p.head)
+	c.emit(tail)
+	return nil
+}
+
+// prefetch opens the next track, starting at position, and reads the fade
+// bytes mixed with the end of the current one.
+func (c *crossfader) prefetch(pending chan<- prefetched, position int64, fade int) {
+	pcm, opts, ok := c.nextStream(position)
+	p := prefetched{pcm: pcm, opts: opts, ok: ok}
+	if ok && fade > 0 {
+		p.head = make([]byte, fade)
+		m, err := io.ReadFull(pcm, p.head)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			p.err = err
+		}
+		// Next track shorter than the fade is padded with silence.
+		clear(p.head[m:])
+	}
+	pending <- p
+}
+
+// Opens the next track, sources failing to open are skipped.
+func (c *crossfader) nextStream(written int64) (io.ReadCloser, EncodeOptions, bool) {
+	for c.next != nil && c.ctx.Err() == nil {
+		position := time.Duration(written) * time.Second / PCMSampleRate
+		source, opts, ok := c.next(position)
+		if !ok {
+			return nil, EncodeOptions{}, false
+		}
+		pcm, err := c.decode(c.ctx, source, nil, source.Format(), opts)
+		if err == nil {
+			return pcm, opts, true
+		}
+	}
+	return nil, EncodeOptions{}, false
+}
+
+func (c *crossfader) emit(b []byte) {
+	c.out = append(c.out[:0], b...)
+	c.written += int64(len(b) / pcmSampleSize)
+}
+
+// mix fades out into fades in, writing the result to out.
+func mix(out, in []byte) {
+	samples := len(out) / pcmSampleSize
+	for i := 0; i < samples; i++ {
+		t := (float64(i) + 0.5) / float64(samples) * math.Pi / 2
+		fadeOut, fadeIn := math.Cos(t), math.Sin(t)
+		for ch := 0; ch < PCMChannels; ch++ {
+			j := i*pcmSampleSize + ch*2
+			a := float64(int16(binary.LittleEndian.Uint16(out[j:]))) / 32768
+			b := float64(int16(binary.LittleEndian.Uint16(in[j:]))) / 32768
+			binary.LittleEndian.PutUint16(out[j:], uint16(toInt16(softClip(a*fadeOut+b*fadeIn))))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+var errBrokenTrack = errors.New("broken track")
+
+// constPCM is stereo PCM holding v for d.
+func constPCM(d time.Duration, v int16) []byte {
+	samples := int(d.Seconds() * PCMSampleRate)
+	b := make([]byte, 0, samples*pcmSampleSize)
+	for range samples * PCMChannels {
+		b = binary.LittleEndian.AppendUint16(b, uint16(v))
+	}
+	return b
+}
+
+// Left channel of sample i.
+func sampleAt(b []byte, i int) int16 {
+	return int16(binary.LittleEndian.Uint16(b[i*pcmSampleSize:]))
+}
+
+func samplesOf(d time.Duration) int {
+	return int(d.Seconds() * PCMSampleRate)
+}
+
+func TestMix(t *testing.T) {
+	const samples = 100
+	tests := []struct {
+		name    string
+		out, in int16
+	}{
+		{"fade out", 16000, 0},
+		{"fade in", 0, 16000},
+		{"equal power", 8000, 8000},
+		{"opposite", 16000, -16000},
+	}
+	for _, tt := range tests {
+		out := bytes.Repeat(binary.LittleEndian.AppendUint16(nil, uint16(tt.out)), samples*PCMChannels)
+		in := bytes.Repeat(binary.LittleEndian.AppendUint16(nil, uint16(tt.in)), samples*PCMChannels)
+		mix(out, in)
+		for i := 0; i < samples; i++ {
+			tm := (float64(i) + 0.5) / samples * math.Pi / 2
+			want := math.Round(float64(tt.out)*math.Cos(tm) + float64(tt.in)*math.Sin(tm))
+			if got := float64(sampleAt(out, i)); math.Abs(got-want) > 1 {
+				t.Fatalf("%s: sample %d is %v, want %v", tt.name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestCrossfader(t *testing.T) {
+	prevLead := CROSSFADE_LEAD
+	CROSSFADE_LEAD = 100 * time.Millisecond
+	t.Cleanup(func() { CROSSFADE_LEAD = prevLead })
+
+	const curValue, nextValue = 8000, 16000
+	type track struct {
+		length time.Duration
+		broken bool
+	}
+	tests := []struct {
+		name      string
+		fade      time.Duration
+		cur       time.Duration
+		next      []track
+		length    time.Duration
+		positions []time.Duration
+		last      int16
+	}{
+		{"fade", 250 * time.Millisecond, time.Second, []track{{length: time.Second}}, 1750 * time.Millisecond, []time.Duration{750 * time.Millisecond}, nextValue},
+		{"gapless", 0, time.Second, []track{{length: time.Second}}, 2 * time.Second, []time.Duration{time.Second}, nextValue},
+		// Faded into silence past the end of the next track.
+		{"short next", 250 * time.Millisecond, time.Second, []track{{length: 100 * time.Millisecond}}, time.Second, []time.Duration{750 * time.Millisecond}, 0},
+		// The fade can not start before the current track.
+		{"short current", 250 * time.Millisecond, 100 * time.Millisecond, []track{{length: time.Second}}, time.Second, []time.Duration{0}, nextValue},
+		{"next fails", 250 * time.Millisecond, time.Second, []track{{broken: true}, {length: time.Second}}, 1750 * time.Millisecond, []time.Duration{750 * time.Millisecond, 750 * time.Millisecond}, nextValue},
+		{"no next", 250 * time.Millisecond, time.Second, nil, time.Second, []time.Duration{750 * time.Millisecond}, curValue},
+	}
+	for _, tt := range tests {
+		positions := []time.Duration{}
+		next := slices.Clone(tt.next)
+		opts := EncodeOptions{
+			Crossfade: tt.fade,
+			Next: func(position time.Duration) (AudioSource, EncodeOptions, bool) {
+				positions = append(positions, position)
+				if len(next) == 0 {
+					return nil, EncodeOptions{}, false
+				}
+				tr := next[0]
+				next = next[1:]
+				name := "next"
+				if tr.broken {
+					name = "broken"
+				}
+				return NewPCMSource(bytes.NewReader(constPCM(tr.length, nextValue)), name), EncodeOptions{}, true
+			},
+		}
+		c := newCrossfader(context.Background(), io.NopCloser(bytes.NewReader(constPCM(tt.cur, curValue))), opts)
+		c.decode = func(ctx context.Context, source AudioSource, src io.ReadCloser, format SourceFormat, opts EncodeOptions) (io.ReadCloser, error) {
+			if source.String() == "broken" {
+				return nil, errBrokenTrack
+			}
+			return source.Open(ctx)
+		}
+		out, err := io.ReadAll(c)
+		c.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := len(out) / pcmSampleSize; got != samplesOf(tt.length) {
+			t.Errorf("%s: %d samples, want %d", tt.name, got, samplesOf(tt.length))
+			continue
+		}
+		if !slices.Equal(positions, tt.positions) {
+			t.Errorf("%s: next opened at %v, want %v", tt.name, positions, tt.positions)
+		}
+		if first := sampleAt(out, 0); first != curValue && samplesOf(tt.cur) > samplesOf(tt.fade) {
+			t.Errorf("%s: first sample %d, want %d", tt.name, first, curValue)
+		}
+		if last := sampleAt(out, len(out)/pcmSampleSize-1); math.Abs(float64(last)-float64(tt.last)) > 100 {
+			t.Errorf("%s: last sample %d, want %d", tt.name, last, tt.last)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnknownPreset = errors.New("unknown filter preset")
+	ErrFilterRange   = errors.New("filter value out of range")
+)
+
+const (
+	MinTempo = 0.5
+	MaxTempo = 2.0
+	MinPitch = 0.5
+	MaxPitch = 2.0
+)
+
+// Filter compiles to an ffmpeg audio filter expression.
+type Filter interface {
+	Expression() string
+	// Source seconds played per second of output.
+	Speed() float64
+}
+
+// Chain of filters applied in order, passed to ffmpeg as -af.
+type Chain []Filter
+
+// Expression of the whole chain, empty if there are no filters.
+func (c Chain) Expression() string {
+	if len(c) == 0 {
+		return ""
+	}
+	// Rate based filters assume 48kHz input.
+	exprs := []string{fmt.Sprintf("aresample=%d", PCMSampleRate)}
+	for _, f := range c {
+		exprs = append(exprs, f.Expression())
+	}
+	return strings.Join(exprs, ",")
+}
+
+func (c Chain) Speed() float64 {
+	speed := 1.0
+	for _, f := range c {
+		speed *= f.Speed()
+	}
+	return speed
+}
+
+type EqualizerBand struct {
+	// Center frequency in Hz.
+	Frequency float64
+	// Gain in dB.
+	Gain float64
+	// Width as Q factor.
+	Width float64
+}
+
+type Equalizer struct {
+	Bands []EqualizerBand
+}
+
+func (e Equalizer) Expression() string {
+	exprs := make([]string, 0, len(e.Bands))
+	for _, b := range e.Bands {
+		exprs = append(exprs, fmt.Sprintf("equalizer=f=%g:t=q:w=%g:g=%g", b.Frequency, b.Width, b.Gain))
+	}
+	return strings.Join(exprs, ",")
+}
+
+func (e Equalizer) Speed() float64 {
+	return 1
+}
+
+// BassBoost raises frequencies below 100Hz, gain in dB.
+type BassBoost struct {
+	Gain float64
+}
+
+func (b BassBoost) Expression() string {
+	return fmt.Sprintf("bass=g=%g:f=100", b.Gain)
+}
+
+func (b BassBoost) Speed() float64 {
+	return 1
+}
+
+// Resample changes speed and pitch together, like playing a record faster.
+type Resample struct {
+	Rate float64
+}
+
+func (r Resample) Expression() string {
+	return fmt.Sprintf("asetrate=%g,aresample=%d", PCMSampleRate*r.Rate, PCMSampleRate)
+}
+
+func (r Resample) Speed() float64 {
+	return r.Rate
+}
+
+// Tempo changes speed keeping the pitch.
+type Tempo struct {
+	Factor float64
+}
+
+func (t Tempo) Expression() string {
+	return atempo(t.Factor)
+}
+
+func (t Tempo) Speed() float64 {
+	return t.Factor
+}
+
+// Pitch changes pitch keeping the speed.
+type Pitch struct {
+	Factor float64
+}
+
+func (p Pitch) Expression() string {
+	return fmt.Sprintf("asetrate=%g,aresample=%d,%s", PCMSampleRate*p.Factor, PCMSampleRate, atempo(1/p.Factor))
+}
+
+func (p Pitch) Speed() float64 {
+	return 1
+}
+
+// Rotation pans audio around the listener, the 8D effect.
+type Rotation struct {
+	// Rotations per second.
+	Hz float64
+}
+
+func (r Rotation) Expression() string {
+	return fmt.Sprintf("apulsator=hz=%g", r.Hz)
+}
+
+func (r Rotation) Speed() float64 {
+	return 1
+}
+
+// Karaoke cancels audio panned to the center, usually vocals.
+type Karaoke struct{}
+
+func (k Karaoke) Expression() string {
+	return "pan=stereo|c0=c0-c1|c1=c1-c0"
+}
+
+func (k Karaoke) Speed() float64 {
+	return 1
+}
+
+// atempo is limited to [0.5, 2] per instance on older ffmpeg.
+func atempo(factor float64) string {
+	if factor <= 0 || math.IsInf(factor, 0) || math.IsNaN(factor) {
+		factor = 1
+	}
+	exprs := []string{}
+	for factor > 2 {
+		exprs = append(exprs, "atempo=2")
+		factor /= 2
+	}
+	for factor < 0.5 {
+		exprs = append(exprs, "atempo=0.5")
+		factor /= 0.5
+	}
+	exprs = append(exprs, fmt.Sprintf("atempo=%g", factor))
+	return strings.Join(exprs, ",")
+}
+
+var presets = map[string]Chain{
+	"off":       {},
+	"bassboost": {BassBoost{Gain: 10}},
+	"nightcore": {Resample{Rate: 1.25}},
+	"vaporwave": {Resample{Rate: 0.8}},
+	"8d":        {Rotation{Hz: 0.125}},
+	"karaoke":   {Karaoke{}},
+	"treble": {Equalizer{Bands: []EqualizerBand{
+		{Frequency: 4000, Gain: 4, Width: 1},
+		{Frequency: 8000, Gain: 6, Width: 1},
+		{Frequency: 12000, Gain: 6, Width: 1},
+	}}},
+	"soft": {Equalizer{Bands: []EqualizerBand{
+		{Frequency: 60, Gain: 2, Width: 1},
+		{Frequency: 4000, Gain: -4, Width: 1},
+		{Frequency: 10000, Gain: -6, Width: 1},
+	}}},
+}
+
+// Preset returns a copy of the named chain.
+func Preset(name string) (Chain, error) {
+	chain, ok := presets[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPreset, name)
+	}
+	return slices.Clone(chain), nil
+}
+
+func Presets() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func NewTempo(factor float64) (Tempo, error) {
+	if factor < MinTempo || factor > MaxTempo {
+		return Tempo{}, ErrFilterRange
+	}
+	return Tempo{Factor: factor}, nil
+}
+
+func NewPitch(factor float64) (Pitch, error) {
+	if factor < MinPitch || factor > MaxPitch {
+		return Pitch{}, ErrFilterRange
+	}
+	return Pitch{Factor: factor}, nil
+}
@@ -0,0 +1,122 @@
+package audio
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var ErrFrameDuration = errors.New("opus frame duration must be 10, 20, 40 or 60ms")
+
+// Bitrate assumed when the channel's is unknown, Discord's default.
+var DEFAULT_OPUS_BITRATE = 64000
+
+var (
+	MIN_OPUS_BITRATE = 8000
+	MAX_OPUS_BITRATE = 510000
+)
+
+var frameDurations = []time.Duration{
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	40 * time.Millisecond,
+	60 * time.Millisecond,
+}
+
+// Opus encoder settings, zero values are libopus defaults.
+type OpusOptions struct {
+	// Bits per second.
+	Bitrate       int
+	FrameDuration time.Duration
+	// In-band forward error correction, sized for PacketLoss.
+	FEC bool
+	// Expected packet loss in percent.
+	PacketLoss int
+}
+
+// OpusOverrides fix encoder settings whatever the channel, zero values are
+// tuned.
+type OpusOverrides struct {
+	Bitrate       int
+	FrameDuration time.Duration
+	// Percent, negative disables forward error correction.
+	PacketLoss int
+}
+
+func (o OpusOverrides) Validate() error {
+	if o.FrameDuration != 0 && !slices.Contains(frameDurations, o.FrameDuration) {
+		return ErrFrameDuration
+	}
+	return nil
+}
+
+// TuneOpus picks encoder settings for a channel bitrate, 0 if unknown. Low
+// bitrates get longer frames, spending less of the budget on overhead, and
+// forward error correction, which the SILK and hybrid modes used at these
+// bitrates support.
+func TuneOpus(bitrate int, overrides OpusOverrides) OpusOptions {
+	if bitrate <= 0 {
+		bitrate = DEFAULT_OPUS_BITRATE
+	}
+	if overrides.Bitrate > 0 {
+		bitrate = overrides.Bitrate
+	}
+	opts := OpusOptions{
+		Bitrate:       min(max(bitrate, MIN_OPUS_BITRATE), MAX_OPUS_BITRATE),
+		FrameDuration: 20 * time.Millisecond,
+	}
+	switch {
+	case opts.Bitrate < 16000:
+		opts.FrameDuration = 60 * time.Millisecond
+	case opts.Bitrate < 32000:
+		opts.FrameDuration = 40 * time.Millisecond
+	}
+	switch {
+	case opts.Bitrate <= 64000:
+		opts.FEC, opts.PacketLoss = true, 10
+	case opts.Bitrate <= 128000:
+		opts.FEC, opts.PacketLoss = true, 5
+	}
+	if overrides.FrameDuration != 0 && overrides.Validate() == nil {
+		opts.FrameDuration = overrides.FrameDuration
+	}
+	switch {
+	case overrides.PacketLoss > 0:
+		opts.FEC, opts.PacketLoss = true, min(overrides.PacketLoss, 100)
+	case overrides.PacketLoss < 0:
+		opts.FEC, opts.PacketLoss = false, 0
+	}
+	return opts
+}
+
+// ffmpeg libopus output arguments, streamed to stdout.
+func (o OpusOptions) outputArgs() []string {
+	return append(o.CodecArgs(),
+		"-f", "opus", // Force format to opus.
+		"-", // Stream to stdout.
+	)
+}
+
+// CodecArgs are the ffmpeg libopus arguments, without the output.
+func (o OpusOptions) CodecArgs() []string {
+	args := []string{
+		"-ac", "2",
+		"-ar", "48000",
+		"-c:a", "libopus", // Audio codec opus
+	}
+	if o.Bitrate > 0 {
+		args = append(args, "-b:a", strconv.Itoa(o.Bitrate))
+	}
+	if o.FrameDuration > 0 {
+		ms := float64(o.FrameDuration) / float64(time.Millisecond)
+		args = append(args, "-frame_duration", strconv.FormatFloat(ms, 'f', -1, 64))
+	}
+	if o.FEC {
+		args = append(args, "-fec", "1")
+	}
+	if o.PacketLoss > 0 {
+		args = append(args, "-packet_loss", strconv.Itoa(o.PacketLoss))
+	}
+	return args
+}
@@ -0,0 +1,229 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	ErrTooManyOverlays = errors.New("too many overlays playing")
+)
+
+const (
+	MaxOverlays = 4
+	// Music gain while overlays play.
+	DuckGain = 0.35
+
+	// Per frame smoothing of the music gain, ducking is faster than release.
+	duckAttack  = 0.5
+	duckRelease = 0.1
+
+	// Frames decoded ahead per overlay.
+	overlayFrames = 10
+)
+
+// overlaySource decodes ahead in its own goroutine, mixing takes what is
+// there and never waits on ffmpeg.
+type overlaySource struct {
+	pcm    io.ReadCloser
+	gain   func() float64
+	frames chan []byte
+	stop   chan struct{}
+	// Part of the last frame not mixed yet.
+	rest []byte
+}
+
+func newOverlaySource(pcm io.ReadCloser, gain func() float64) *overlaySource {
+	s := &overlaySource{
+		pcm:    pcm,
+		gain:   gain,
+		frames: make(chan []byte, overlayFrames),
+		stop:   make(chan struct{}),
+	}
+	go s.read()
+	return s
+}
+
+func (s *overlaySource) read() {
+	defer close(s.frames)
+	for {
+		frame := make([]byte, PCMFrameSize)
+		n, err := io.ReadFull(s.pcm, frame)
+		if n > 0 {
+			select {
+			case s.frames <- frame[:n]:
+			case <-s.stop:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// take fills buf with decoded PCM as far as available, false once the
+// source ended.
+func (s *overlaySource) take(buf []byte) (int, bool) {
+	n := 0
+	for n < len(buf) {
+		if len(s.rest) == 0 {
+			select {
+			case frame, ok := <-s.frames:
+				if !ok {
+					return n, n > 0
+				}
+				s.rest = frame
+			default:
+				return n, true
+			}
+		}
+		m := copy(buf[n:], s.rest)
+		s.rest = s.rest[m:]
+		n += m
+	}
+	return n, true
+}
+
+func (s *overlaySource) close() {
+	close(s.stop)
+	s.pcm.Close()
+}
+
+// Overlay sums short sounds over the music of a voice session.
+type Overlay struct {
+	mu      sync.Mutex
+	sources []*overlaySource
+	// Smoothed music gain.
+	bedGain float64
+	frame   [PCMFrameSize]byte
+	mixed   [PCMFrameSamples]float64
+}
+
+func NewOverlay() *Overlay {
+	return &Overlay{bedGain: 1}
+}
+
+// Add starts decoding source, gain is read for every frame.
+func (o *Overlay) Add(ctx context.Context, source AudioSource, gain func() float64) error {
+	o.mu.Lock()
+	full := len(o.sources) >= MaxOverlays
+	o.mu.Unlock()
+	if full {
+		return ErrTooManyOverlays
+	}
+	pcm, err := decode(ctx, source, nil, source.Format(), EncodeOptions{})
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sources = append(o.sources, newOverlaySource(pcm, gain))
+	return nil
+}
+
+// Active reports whether overlays are playing.
+func (o *Overlay) Active() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.sources) > 0
+}
+
+// Close stops all overlays.
+func (o *Overlay) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, s := range o.sources {
+		s.close()
+	}
+	o.sources = nil
+}
+
+// mix adds one frame of every overlay to the music frame, ducking the music.
+func (o *Overlay) mix(frame []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.sources) == 0 && o.bedGain == 1 {
+		return
+	}
+	target, smoothing := 1.0, duckRelease
+	if len(o.sources) > 0 {
+		target, smoothing = DuckGain, duckAttack
+	}
+	from := o.bedGain
+	o.bedGain += (target - o.bedGain) * smoothing
+	if len(o.sources) == 0 && o.bedGain > 0.999 {
+		o.bedGain = 1
+	}
+
+	samples := len(frame) / pcmSampleSize
+	mixed := o.mixed[:len(frame)/2]
+	for i := range mixed {
+		// Ramp the music gain across the frame to avoid zipper noise.
+		t := float64(i/PCMChannels) / float64(samples)
+		gain := from + (o.bedGain-from)*t
+		mixed[i] = float64(int16(binary.LittleEndian.Uint16(frame[i*2:]))) / 32768 * gain
+	}
+	active := o.sources[:0]
+	for _, s := range o.sources {
+		buf := o.frame[:len(frame)]
+		n, ok := s.take(buf)
+		gain := s.gain()
+		for i := 0; i < n/2; i++ {
+			mixed[i] += float64(int16(binary.LittleEndian.Uint16(buf[i*2:]))) / 32768 * gain
+		}
+		if !ok {
+			s.close()
+			continue
+		}
+		active = append(active, s)
+	}
+	clear(o.sources[len(active):])
+	o.sources = active
+	for i, s := range mixed {
+		binary.LittleEndian.PutUint16(frame[i*2:], uint16(toInt16(softClip(s))))
+	}
+}
+
+// overlayReader mixes the overlay into the music read from bed. Without a
+// bed it plays silence until the overlays end.
+type overlayReader struct {
+	bed     io.Reader
+	overlay *Overlay
+	frame   [PCMFrameSize]byte
+	buf     []byte
+}
+
+func newOverlayReader(bed io.Reader, overlay *Overlay) *overlayReader {
+	return &overlayReader{bed: bed, overlay: overlay}
+}
+
+func (r *overlayReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		n := len(r.frame)
+		if r.bed != nil {
+			var err error
+			n, err = io.ReadFull(r.bed, r.frame[:])
+			n -= n % pcmSampleSize
+			if n == 0 {
+				if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+					err = io.EOF
+				}
+				return 0, err
+			}
+		} else {
+			if !r.overlay.Active() {
+				return 0, io.EOF
+			}
+			clear(r.frame[:])
+		}
+		r.overlay.mix(r.frame[:n])
+		r.buf = r.frame[:n]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,120 @@
+package audio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+var frameDuration = 20 * time.Millisecond
+
+// decodedOverlay is an overlay source whose frames are all decoded already.
+func decodedOverlay(pcm []byte, gain float64) *overlaySource {
+	s := &overlaySource{
+		pcm:    io.NopCloser(bytes.NewReader(nil)),
+		gain:   func() float64 { return gain },
+		frames: make(chan []byte, len(pcm)/PCMFrameSize+1),
+		stop:   make(chan struct{}),
+	}
+	for len(pcm) > 0 {
+		n := min(len(pcm), PCMFrameSize)
+		s.frames <- pcm[:n]
+		pcm = pcm[n:]
+	}
+	close(s.frames)
+	return s
+}
+
+func TestOverlayDucking(t *testing.T) {
+	const bed = 16000
+	o := NewOverlay()
+	o.sources = append(o.sources, decodedOverlay(constPCM(4*frameDuration, 0), 1))
+
+	// Music gain at the end of each frame.
+	gains := []float64{}
+	for range 100 {
+		frame := constPCM(frameDuration, bed)
+		o.mix(frame)
+		samples := len(frame) / pcmSampleSize
+		// Ramped across the frame, never stepped.
+		maxStep := int(bed*duckAttack)/samples + 2
+		for i := 1; i < samples; i++ {
+			if d := int(sampleAt(frame, i)) - int(sampleAt(frame, i-1)); d > maxStep || d < -maxStep {
+				t.Fatalf("frame %d: step of %d at sample %d", len(gains), d, i)
+			}
+		}
+		gains = append(gains, float64(sampleAt(frame, samples-1))/bed)
+	}
+	// Ducks for the 4 overlay frames and the one finding it ended.
+	for i := 1; i < 5; i++ {
+		if gains[i] >= gains[i-1] {
+			t.Fatalf("attack: gain %v after %v", gains[i], gains[i-1])
+		}
+	}
+	if gains[4] > DuckGain+0.05 {
+		t.Fatalf("ducked to %v, want about %v", gains[4], DuckGain)
+	}
+	// Release is slower than attack.
+	if up, down := gains[5]-gains[4], gains[0]-gains[1]; up <= 0 || up >= down {
+		t.Fatalf("release step %v, attack step %v", up, down)
+	}
+	for i := 6; i < len(gains) && gains[i-1] < 1; i++ {
+		if gains[i] < gains[i-1] {
+			t.Fatalf("release: gain %v after %v", gains[i], gains[i-1])
+		}
+	}
+	if o.Active() || o.bedGain != 1 {
+		t.Fatalf("not released, active %v, gain %v", o.Active(), o.bedGain)
+	}
+	// Back at unity the music is untouched.
+	frame := constPCM(frameDuration, bed)
+	o.mix(frame)
+	if !bytes.Equal(frame, constPCM(frameDuration, bed)) {
+		t.Fatal("music changed after release")
+	}
+}
+
+func TestOverlayReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		bed     []byte
+		overlay time.Duration
+		// Output length, and how much of it carries the overlay.
+		length time.Duration
+		mixed  time.Duration
+	}{
+		// Silence until the frame after the one the overlay ended in.
+		{"no bed", nil, 50 * time.Millisecond, 80 * time.Millisecond, 50 * time.Millisecond},
+		{"bed", constPCM(100*time.Millisecond, 0), 50 * time.Millisecond, 100 * time.Millisecond, 50 * time.Millisecond},
+		// Bed ends first, a partial sample is dropped.
+		{"short bed", append(constPCM(30*time.Millisecond, 0), 1, 2), 50 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		o := NewOverlay()
+		o.sources = append(o.sources, decodedOverlay(constPCM(tt.overlay, 8000), 0.5))
+		var bed io.Reader
+		if tt.bed != nil {
+			bed = bytes.NewReader(tt.bed)
+		}
+		out, err := io.ReadAll(newOverlayReader(bed, o))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := len(out) / pcmSampleSize; got != samplesOf(tt.length) || len(out)%pcmSampleSize != 0 {
+			t.Fatalf("%s: %d bytes, want %d samples", tt.name, len(out), samplesOf(tt.length))
+		}
+		mixed := samplesOf(tt.mixed)
+		if got := sampleAt(out, 0); got != 4000 {
+			t.Errorf("%s: first sample %d, want 4000", tt.name, got)
+		}
+		if got := sampleAt(out, mixed-1); got != 4000 {
+			t.Errorf("%s: last overlay sample %d, want 4000", tt.name, got)
+		}
+		if mixed < len(out)/pcmSampleSize {
+			if got := sampleAt(out, mixed); got != 0 {
+				t.Errorf("%s: sample after the overlay %d, want 0", tt.name, got)
+			}
+		}
+	}
+}
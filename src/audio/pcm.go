@@ -0,0 +1,113 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync/atomic"
+)
+
+// PCM used between decoding and encoding: signed 16 bit little endian, 48kHz stereo.
+const (
+	PCMSampleRate = 48000
+	PCMChannels   = 2
+	// 20ms of interleaved samples.
+	PCMFrameSamples = PCMSampleRate / 50 * PCMChannels
+	PCMFrameSize    = PCMFrameSamples * 2
+)
+
+const (
+	MaxVolume     uint32 = 200
+	DefaultVolume uint32 = 100
+
+	// Samples above this level are compressed instead of hard clipped.
+	softClipThreshold = 0.9
+)
+
+// Volume is shared with the encoder, changes apply to the next PCM frame.
+type Volume struct {
+	percent atomic.Uint32
+}
+
+func NewVolume() *Volume {
+	v := &Volume{}
+	v.percent.Store(DefaultVolume)
+	return v
+}
+
+// Set volume in percent, capped at MaxVolume.
+func (v *Volume) Set(percent uint32) {
+	v.percent.Store(min(percent, MaxVolume))
+}
+
+func (v *Volume) Percent() uint32 {
+	return v.percent.Load()
+}
+
+func (v *Volume) Gain() float64 {
+	return float64(v.percent.Load()) / 100
+}
+
+func (v *Volume) IsUnity() bool {
+	return v.percent.Load() == DefaultVolume
+}
+
+// gainReader applies gain to PCM frame by frame.
+type gainReader struct {
+	r      io.Reader
+	gain   func() float64
+	frame  [PCMFrameSize]byte
+	buf    []byte
+	offset int
+}
+
+func newGainReader(r io.Reader, gain func() float64) *gainReader {
+	return &gainReader{r: r, gain: gain}
+}
+
+func (gr *gainReader) Read(p []byte) (int, error) {
+	if gr.offset >= len(gr.buf) {
+		n, err := io.ReadFull(gr.r, gr.frame[:])
+		// Drop a trailing half sample.
+		n -= n % 2
+		if n == 0 {
+			if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		applyGain(gr.frame[:n], gr.gain())
+		gr.buf = gr.frame[:n]
+		gr.offset = 0
+	}
+	n := copy(p, gr.buf[gr.offset:])
+	gr.offset += n
+	return n, nil
+}
+
+func applyGain(pcm []byte, gain float64) {
+	if gain == 1 {
+		return
+	}
+	for i := 0; i+1 < len(pcm); i += 2 {
+		s := float64(int16(binary.LittleEndian.Uint16(pcm[i:]))) / 32768
+		binary.LittleEndian.PutUint16(pcm[i:], uint16(toInt16(softClip(s*gain))))
+	}
+}
+
+// softClip is linear up to the threshold then saturates smoothly towards 1.
+func softClip(s float64) float64 {
+	a := math.Abs(s)
+	if a <= softClipThreshold {
+		return s
+	}
+	knee := 1 - softClipThreshold
+	clipped := softClipThreshold + knee*math.Tanh((a-softClipThreshold)/knee)
+	return math.Copysign(clipped, s)
+}
+
+func toInt16(s float64) int16 {
+	v := math.Round(s * 32768)
+	return int16(max(min(v, math.MaxInt16), math.MinInt16))
+}
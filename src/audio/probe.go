@@ -0,0 +1,47 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+
+	"github.com/hendrywilliam/siren/src/ogg"
+	"github.com/hendrywilliam/siren/src/webm"
+)
+
+// Bytes peeked to detect the format, webm tracks are usually in the first few KB.
+var PROBE_SIZE = 64 << 10
+
+// Stereo opus is sent as is, anything else is re-encoded.
+const passthroughChannels = 2
+
+var (
+	oggCapturePattern = []byte("OggS")
+	ebmlMagic         = []byte{0x1A, 0x45, 0xDF, 0xA3}
+)
+
+// DetectFormat sniffs streams that can be passed through without ffmpeg,
+// FormatAuto is returned for anything else. Nothing is consumed.
+func DetectFormat(br *bufio.Reader) SourceFormat {
+	b, _ := br.Peek(PROBE_SIZE)
+	switch {
+	case bytes.HasPrefix(b, oggCapturePattern):
+		// First page holds only the identification header.
+		if len(b) < 28 {
+			return FormatAuto
+		}
+		offset := 27 + int(b[26])
+		head := ogg.OpusHead{}
+		if len(b) < offset || head.Unmarshal(b[offset:]) != nil {
+			return FormatAuto
+		}
+		if head.Channels == passthroughChannels {
+			return FormatOggOpus
+		}
+	case bytes.HasPrefix(b, ebmlMagic):
+		r, err := webm.NewOpusReader(bytes.NewReader(b))
+		if err == nil && r.Track.OpusChannels() == passthroughChannels {
+			return FormatWebMOpus
+		}
+	}
+	return FormatAuto
+}
@@ -0,0 +1,213 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrSourceConsumed = errors.New("audio source can only be opened once")
+	ErrHTTPStatus     = errors.New("unexpected http status")
+	ErrHostNotAllowed = errors.New("url host is not allowed")
+)
+
+// Local media directory.
+var MEDIA_DIR = "./media"
+
+// Hosts http sources may be fetched from, subdomains included. Urls are
+// refused while it is empty.
+var ALLOWED_HOSTS []string
+
+type SourceFormat = int
+
+const (
+	// Any container or codec ffmpeg can probe.
+	FormatAuto SourceFormat = iota
+	// Raw signed 16 bit little endian PCM, 48kHz stereo.
+	FormatPCM
+	// Ogg opus, streamed without re-encoding.
+	FormatOggOpus
+	// WebM opus, streamed without re-encoding.
+	FormatWebMOpus
+)
+
+// AudioSource provides the bytes of a track.
+type AudioSource interface {
+	// Open may be called again to restart the source, e.g. on seek.
+	Open(ctx context.Context) (io.ReadCloser, error)
+	Format() SourceFormat
+	String() string
+}
+
+// Sources on the local filesystem are given to ffmpeg as a path,
+// so it can seek without decoding.
+type PathSource interface {
+	Path() string
+}
+
+type FileSource struct {
+	path   string
+	format SourceFormat
+}
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path, format: FormatAuto}
+}
+
+// NewMediaSource returns a file source under the media directory.
+func NewMediaSource(name string) *FileSource {
+	// Rooting the name first keeps ".." from escaping the media directory.
+	return NewFileSource(filepath.Join(MEDIA_DIR, filepath.Clean("/"+name)))
+}
+
+func (s *FileSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return os.Open(s.path)
+}
+
+func (s *FileSource) Format() SourceFormat {
+	return s.format
+}
+
+func (s *FileSource) Path() string {
+	return s.path
+}
+
+func (s *FileSource) String() string {
+	return s.path
+}
+
+// ReaderSource wraps bytes produced in-process. It can be opened once.
+type ReaderSource struct {
+	mu     sync.Mutex
+	r      io.Reader
+	opened bool
+	format SourceFormat
+	name   string
+}
+
+func NewReaderSource(r io.Reader, name string) *ReaderSource {
+	return &ReaderSource{r: r, name: name, format: FormatAuto}
+}
+
+// NewPCMSource expects signed 16 bit little endian, 48kHz stereo PCM.
+func NewPCMSource(r io.Reader, name string) *ReaderSource {
+	return &ReaderSource{r: r, name: name, format: FormatPCM}
+}
+
+func NewOggOpusSource(r io.Reader, name string) *ReaderSource {
+	return &ReaderSource{r: r, name: name, format: FormatOggOpus}
+}
+
+func (s *ReaderSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened {
+		return nil, ErrSourceConsumed
+	}
+	s.opened = true
+	if rc, ok := s.r.(io.ReadCloser); ok {
+		return rc, nil
+	}
+	return io.NopCloser(s.r), nil
+}
+
+func (s *ReaderSource) Format() SourceFormat {
+	return s.format
+}
+
+func (s *ReaderSource) String() string {
+	return s.name
+}
+
+// CheckURL fails unless rawURL is http(s) on one of ALLOWED_HOSTS.
+func CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, rawURL)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	for _, allowed := range ALLOWED_HOSTS {
+		allowed = strings.ToLower(strings.TrimPrefix(allowed, "."))
+		if allowed != "" && (host == allowed || strings.HasSuffix(host, "."+allowed)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Host)
+}
+
+// Follows redirects only to allowed hosts.
+var allowedClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return CheckURL(req.URL.String())
+	},
+}
+
+// HTTPSource streams an http(s) url, it is requested again on every Open.
+// The url must pass CheckURL.
+type HTTPSource struct {
+	URL    string
+	Header http.Header
+	// Defaults to a client that only follows redirects to allowed hosts.
+	Client       *http.Client
+	SourceFormat SourceFormat
+}
+
+func NewHTTPSource(url string) *HTTPSource {
+	return &HTTPSource{URL: url}
+}
+
+func (s *HTTPSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	res, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *HTTPSource) get(ctx context.Context) (*http.Response, error) {
+	if err := CheckURL(s.URL); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	client := s.Client
+	if client == nil {
+		client = allowedClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrHTTPStatus, res.Status)
+	}
+	return res, nil
+}
+
+func (s *HTTPSource) Format() SourceFormat {
+	return s.SourceFormat
+}
+
+func (s *HTTPSource) String() string {
+	return s.URL
+}
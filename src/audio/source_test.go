@@ -0,0 +1,91 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func withAllowedHosts(t *testing.T, hosts ...string) {
+	t.Helper()
+	prev := ALLOWED_HOSTS
+	ALLOWED_HOSTS = hosts
+	t.Cleanup(func() { ALLOWED_HOSTS = prev })
+}
+
+func TestCheckURL(t *testing.T) {
+	withAllowedHosts(t, "example.com", "Music.example.org")
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://example.com/a.mp3", true},
+		{"http://cdn.example.com:8080/a.mp3", true},
+		{"https://EXAMPLE.com./a.mp3", true},
+		{"https://badexample.com/a.mp3", false},
+		{"https://example.com.evil.net/a.mp3", false},
+		{"https://music.example.org/a.mp3", true},
+		{"ftp://example.com/a.mp3", false},
+		{"file:///etc/passwd", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+	}
+	for _, tt := range tests {
+		err := CheckURL(tt.url)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: %v", tt.url, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrHostNotAllowed) {
+			t.Errorf("%s: got %v, want %v", tt.url, err, ErrHostNotAllowed)
+		}
+	}
+	withAllowedHosts(t)
+	if err := CheckURL("https://example.com/a.mp3"); !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("without allowed hosts: got %v, want %v", err, ErrHostNotAllowed)
+	}
+}
+
+func TestHTTPSourceOpen(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/track":
+			io.WriteString(w, "audio")
+		case "/moved":
+			http.Redirect(w, r, "/track", http.StatusFound)
+		case "/away":
+			// Same server under a name that is not allowed.
+			http.Redirect(w, r, "http://"+strings.Replace(r.Host, "127.0.0.1", "localhost", 1)+"/track", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	withAllowedHosts(t, "127.0.0.1")
+	ctx := context.Background()
+
+	for _, path := range []string{"/track", "/moved"} {
+		rc, err := NewHTTPSource(srv.URL + path).Open(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(b) != "audio" {
+			t.Fatalf("%s: read %q, %v", path, b, err)
+		}
+	}
+	if _, err := NewHTTPSource(srv.URL + "/away").Open(ctx); !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("redirect off the allowed hosts: got %v, want %v", err, ErrHostNotAllowed)
+	}
+	if _, err := NewHTTPSource(srv.URL + "/missing").Open(ctx); !errors.Is(err, ErrHTTPStatus) {
+		t.Fatalf("missing: got %v, want %v", err, ErrHTTPStatus)
+	}
+
+	withAllowedHosts(t, "example.com")
+	if _, err := NewHTTPSource(srv.URL + "/track").Open(ctx); !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("disallowed host: got %v, want %v", err, ErrHostNotAllowed)
+	}
+}
@@ -0,0 +1,366 @@
+package audioreceiver
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Supported encryption modes.
+// Source: https://discord.com/developers/docs/topics/voice-connections#transport-encryption-modes
+const (
+	ModeAEADAES256GCMRTPSize         = "aead_aes256_gcm_rtpsize"
+	ModeAEADXChaCha20Poly1305RTPSize = "aead_xchacha20_poly1305_rtpsize"
+)
+
+const (
+	rtpHeaderSize      = 12
+	rtpNonceSize       = 4
+	rtpVersion         = 2
+	rtpPayloadTypeOpus = 0x78
+
+	// Max UDP datagram we expect from voice server.
+	maxPacketSize = 1500
+	// Deadline to periodically check whether context is cancelled.
+	readTimeout = 1 * time.Second
+
+	packetsBufferSize = 64
+)
+
+var (
+	ErrPacketTooShort        = errors.New("rtp packet too short")
+	ErrNotRTP                = errors.New("not an rtp packet")
+	ErrUnsupportedEncryption = errors.New("unsupported encryption mode")
+)
+
+// Decrypted opus packet of a single user.
+type Packet struct {
+	SSRC      uint32
+	Sequence  uint16
+	Timestamp uint32
+	Opus      []byte
+	// When the packet arrived.
+	ReceivedAt time.Time
+}
+
+// Opus packets stream of a single user for one subscription.
+// Packets is closed once the user disconnects, the receiver stops or the
+// subscription is closed.
+type Stream struct {
+	UserID  string
+	SSRC    uint32
+	Packets <-chan *Packet
+
+	packets chan *Packet
+}
+
+// End-to-end decryption applied to opus frames after transport decryption.
+type FrameDecryptor interface {
+	DecryptFrame(userID string, frame []byte) ([]byte, error)
+}
+
+type AudioReceiver struct {
+	mu        sync.Mutex
+	decryptor FrameDecryptor
+	users     map[uint32]string // ssrc -> user id
+	// SSRCs audio is received from, whether or not anyone subscribed.
+	live map[uint32]struct{}
+	subs map[*Subscription]struct{}
+}
+
+// decryptor is optional.
+func NewAudioReceiver(decryptor FrameDecryptor) *AudioReceiver {
+	return &AudioReceiver{
+		decryptor: decryptor,
+		users:     make(map[uint32]string),
+		live:      make(map[uint32]struct{}),
+		subs:      make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives its own stream of every user audio is received
+// from, including users already speaking when it was created.
+type Subscription struct {
+	ar *AudioReceiver
+	// Guarded by ar.mu.
+	streams map[uint32]*Stream
+	pending []*Stream
+	closed  bool
+
+	notify chan struct{}
+	out    chan *Stream
+	done   chan struct{}
+}
+
+// Subscribe starts delivering streams, Close must be called once done.
+func (ar *AudioReceiver) Subscribe() *Subscription {
+	s := &Subscription{
+		ar:      ar,
+		streams: make(map[uint32]*Stream),
+		notify:  make(chan struct{}, 1),
+		out:     make(chan *Stream),
+		done:    make(chan struct{}),
+	}
+	ar.mu.Lock()
+	ar.subs[s] = struct{}{}
+	for ssrc := range ar.live {
+		s.open(ssrc, ar.users[ssrc])
+	}
+	ar.mu.Unlock()
+	go s.deliver()
+	return s
+}
+
+// Streams emits a new stream for every user audio is received from. It is
+// closed with the subscription.
+func (s *Subscription) Streams() <-chan *Stream {
+	return s.out
+}
+
+// Close ends every stream of the subscription.
+func (s *Subscription) Close() {
+	s.ar.mu.Lock()
+	defer s.ar.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(s.ar.subs, s)
+	for ssrc := range s.streams {
+		s.closeStream(ssrc)
+	}
+	s.pending = nil
+	close(s.done)
+}
+
+// open queues a new stream of ssrc for delivery.
+// Must be called with ar.mu held.
+func (s *Subscription) open(ssrc uint32, userID string) *Stream {
+	packets := make(chan *Packet, packetsBufferSize)
+	st := &Stream{
+		UserID:  userID,
+		SSRC:    ssrc,
+		Packets: packets,
+		packets: packets,
+	}
+	s.streams[ssrc] = st
+	s.pending = append(s.pending, st)
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return st
+}
+
+// Must be called with ar.mu held.
+func (s *Subscription) closeStream(ssrc uint32) {
+	if st, ok := s.streams[ssrc]; ok {
+		close(st.packets)
+		delete(s.streams, ssrc)
+	}
+}
+
+// deliver hands queued streams to the consumer, so the receiver never
+// blocks or drops a stream on a slow one.
+func (s *Subscription) deliver() {
+	defer close(s.out)
+	for {
+		s.ar.mu.Lock()
+		var st *Stream
+		if len(s.pending) > 0 {
+			st = s.pending[0]
+			s.pending = s.pending[1:]
+		}
+		s.ar.mu.Unlock()
+		if st == nil {
+			select {
+			case <-s.notify:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		select {
+		case s.out <- st:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Map ssrc to user id, taken from Speaking event.
+func (ar *AudioReceiver) SetUser(ssrc uint32, userID string) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if prev, ok := ar.users[ssrc]; ok && prev != userID {
+		ar.closeStream(ssrc)
+	}
+	ar.users[ssrc] = userID
+}
+
+// Forget user and close their stream.
+func (ar *AudioReceiver) RemoveUser(userID string) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	for ssrc, id := range ar.users {
+		if id == userID {
+			ar.closeStream(ssrc)
+			delete(ar.users, ssrc)
+		}
+	}
+}
+
+func (ar *AudioReceiver) UserID(ssrc uint32) (string, bool) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	id, ok := ar.users[ssrc]
+	return id, ok
+}
+
+// Must be called with mu held.
+func (ar *AudioReceiver) closeStream(ssrc uint32) {
+	delete(ar.live, ssrc)
+	for s := range ar.subs {
+		s.closeStream(ssrc)
+	}
+}
+
+func (ar *AudioReceiver) Listen(ctx context.Context, udpConn *net.UDPConn, secretKeys [32]byte, mode string) error {
+	aead, err := newAEAD(mode, secretKeys)
+	if err != nil {
+		return err
+	}
+	defer ar.stop()
+	defer udpConn.SetReadDeadline(time.Time{})
+
+	buff := make([]byte, maxPacketSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		if err := udpConn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+			return err
+		}
+		n, err := udpConn.Read(buff)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		receivedAt := time.Now()
+		packet, err := decrypt(aead, buff[:n])
+		if err != nil {
+			// Not an audio packet (RTCP, keepalive) or corrupted packet.
+			continue
+		}
+		packet.ReceivedAt = receivedAt
+		ar.dispatch(packet)
+	}
+}
+
+func (ar *AudioReceiver) dispatch(p *Packet) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	userID, ok := ar.users[p.SSRC]
+	if !ok {
+		// Speaking event has not arrived yet.
+		return
+	}
+	if ar.decryptor != nil {
+		opus, err := ar.decryptor.DecryptFrame(userID, p.Opus)
+		if err != nil {
+			return
+		}
+		p.Opus = opus
+	}
+	ar.live[p.SSRC] = struct{}{}
+	for s := range ar.subs {
+		st, ok := s.streams[p.SSRC]
+		if !ok {
+			st = s.open(p.SSRC, userID)
+		}
+		select {
+		case st.packets <- p:
+		default:
+			// Consumer is too slow, drop the packet instead of stalling the socket.
+		}
+	}
+}
+
+// Ends every stream, subscriptions carry on with the next Listen.
+func (ar *AudioReceiver) stop() {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	for ssrc := range ar.live {
+		ar.closeStream(ssrc)
+	}
+}
+
+func newAEAD(mode string, secretKeys [32]byte) (cipher.AEAD, error) {
+	switch mode {
+	case ModeAEADXChaCha20Poly1305RTPSize:
+		return chacha20poly1305.NewX(secretKeys[:])
+	case ModeAEADAES256GCMRTPSize:
+		block, err := aes.NewCipher(secretKeys[:])
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	default:
+		return nil, ErrUnsupportedEncryption
+	}
+}
+
+// In rtpsize modes the fixed header, csrcs and extension header are
+// authenticated but unencrypted, the extension body is encrypted along
+// with the payload, and a 32 bit nonce is appended to the packet.
+func decrypt(aead cipher.AEAD, b []byte) (*Packet, error) {
+	if len(b) < rtpHeaderSize+rtpNonceSize+aead.Overhead() {
+		return nil, ErrPacketTooShort
+	}
+	if b[0]>>6 != rtpVersion || b[1]&0x7f != rtpPayloadTypeOpus {
+		return nil, ErrNotRTP
+	}
+	hasExtension := b[0]&0x10 != 0
+	csrcCount := int(b[0] & 0x0f)
+
+	headerSize := rtpHeaderSize + csrcCount*4
+	extensionLen := 0
+	if hasExtension {
+		if len(b) < headerSize+4 {
+			return nil, ErrPacketTooShort
+		}
+		extensionLen = int(binary.BigEndian.Uint16(b[headerSize+2:headerSize+4])) * 4
+		headerSize += 4
+	}
+	if len(b) < headerSize+rtpNonceSize+aead.Overhead() {
+		return nil, ErrPacketTooShort
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, b[len(b)-rtpNonceSize:])
+	plain, err := aead.Open(nil, nonce, b[headerSize:len(b)-rtpNonceSize], b[:headerSize])
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) < extensionLen {
+		return nil, ErrPacketTooShort
+	}
+	return &Packet{
+		Sequence:  binary.BigEndian.Uint16(b[2:4]),
+		Timestamp: binary.BigEndian.Uint32(b[4:8]),
+		SSRC:      binary.BigEndian.Uint32(b[8:12]),
+		Opus:      plain[extensionLen:],
+	}, nil
+}
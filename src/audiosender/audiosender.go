@@ -4,46 +4,285 @@ import (
 	"context"
 	"encoding/binary"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/hendrywilliam/siren/src/ogg"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
 // Data interpolation
 var SILENCE_FRAMES = []byte{0xF8, 0xFF, 0xFE}
 
+// Silence frames sent when audio stops.
+var SILENCE_FRAMES_COUNT = 5
+
+// End-to-end encryption applied to opus frames before transport encryption.
+type FrameEncryptor interface {
+	EncryptFrame(frame []byte) ([]byte, error)
+}
+
 type AudioSender struct {
 	sequence  uint16
 	timestamp uint32
 	ssrc      uint32
+
+	// Samples sent by the current Send call.
+	played atomic.Int64
+
+	// Buffer of the current Send call and its stats.
+	buf       atomic.Pointer[ring]
+	underruns atomic.Int64
+	silence   atomic.Int64
+	lowWater  atomic.Int64
+
+	mu sync.Mutex
+	// Non nil while paused, closed on resume.
+	resume chan struct{}
+
+	FrameEncryptor FrameEncryptor
+	// DEFAULT_BUFFER if the size is 0.
+	Buffer Buffer
 }
 
-func (as *AudioSender) Send(ctx context.Context, udpConn *net.UDPConn, secretKeys [32]byte, data <-chan []byte, done chan bool) error {
-	ticker := time.NewTicker(20 * time.Millisecond)
-	defer ticker.Stop()
+// Samples of a 20ms frame, assumed for frames of unknown duration.
+const frameSamples = 960
+
+// Buffer is the audio held between the encoder and the send loop, PreRoll
+// the audio buffered before the first frame is sent and again after the
+// buffer ran dry.
+type Buffer struct {
+	Size    time.Duration
+	PreRoll time.Duration
+}
+
+var DEFAULT_BUFFER = Buffer{
+	Size:    time.Second,
+	PreRoll: 200 * time.Millisecond,
+}
+
+// Stats of the buffer of the current Send call.
+type Stats struct {
+	// Audio buffered and the buffer capacity.
+	Buffered time.Duration
+	Capacity time.Duration
+	// Least audio buffered when a frame was due, since pre-roll.
+	LowWater time.Duration
+	// Times the buffer ran dry, and silence frames sent meanwhile.
+	Underruns     int64
+	SilenceFrames int64
+}
+
+// Send streams frames from data, paced by their duration. encoded is
+// signaled once the encoder is done, finished once every frame was sent.
+// Silence is sent while the buffer refills after running dry.
+func (as *AudioSender) Send(ctx context.Context, udpConn *net.UDPConn, secretKeys [32]byte, data <-chan []byte, encoded <-chan bool, finished chan<- bool) error {
+	buffer := as.Buffer
+	if buffer.Size <= 0 {
+		buffer = DEFAULT_BUFFER
+	}
+	preRoll := samples(buffer.PreRoll)
+	buf := newRing(samples(buffer.Size))
+	as.played.Store(0)
+	as.underruns.Store(0)
+	as.silence.Store(0)
+	as.lowWater.Store(-1)
+	as.buf.Store(buf)
+	go as.fill(ctx, buf, data, encoded)
+	if err := buf.wait(ctx, preRoll); err != nil {
+		return nil
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	// When the next frame is due, frames are paced by their duration.
+	next := time.Now()
+	rebuffering := false
 
 	for {
+		if resume := as.resumeChan(); resume != nil {
+			// Prevent opus interpolation while paused.
+			if err := as.sendSilence(udpConn, secretKeys); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-resume:
+			}
+			next = time.Now()
+		}
+		timer.Reset(time.Until(next))
 		select {
 		case <-ctx.Done():
 			return nil
-		case frame := <-data:
-			packet, err := as.encrypt(secretKeys, frame)
-			if err != nil {
+		case <-timer.C:
+		}
+		if rebuffering && !buf.ready(preRoll) {
+			if err := as.sendFrame(udpConn, secretKeys, SILENCE_FRAMES, frameSamples); err != nil {
 				return err
 			}
-
-			_, err = udpConn.Write(packet)
-			if err != nil {
+			as.silence.Add(1)
+			next = pace(next, frameSamples)
+			continue
+		}
+		rebuffering = false
+		as.recordFill(buf)
+		frame, ok, closed := buf.pop()
+		if !ok {
+			if closed {
+				select {
+				case finished <- true:
+				case <-ctx.Done():
+				}
+				return nil
+			}
+			// Encoder fell behind, keep the stream going.
+			as.underruns.Add(1)
+			rebuffering = true
+			if err := as.sendFrame(udpConn, secretKeys, SILENCE_FRAMES, frameSamples); err != nil {
 				return err
 			}
+			as.silence.Add(1)
+			next = pace(next, frameSamples)
+			continue
+		}
+		n := packetSamples(frame)
+		if err := as.sendFrame(udpConn, secretKeys, frame, n); err != nil {
+			return err
+		}
+		as.played.Add(int64(n))
+		next = pace(next, n)
+	}
+}
 
-		case <-ticker.C:
+// fill moves frames from the encoder into the buffer until it is done.
+func (as *AudioSender) fill(ctx context.Context, buf *ring, data <-chan []byte, encoded <-chan bool) {
+	for {
+		select {
+		case frame := <-data:
+			if err := buf.push(ctx, frame); err != nil {
+				return
+			}
+		case <-encoded:
+			// Sent after the last frame was received.
+			buf.close()
+			return
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-func (as *AudioSender) encrypt(secretKeys [32]byte, rawData []byte) ([]byte, error) {
+// Tracks the lowest fill once past pre-roll.
+func (as *AudioSender) recordFill(buf *ring) {
+	n, _ := buf.fill()
+	if low := as.lowWater.Load(); low < 0 || int64(n) < low {
+		as.lowWater.Store(int64(n))
+	}
+}
+
+// Stats returns the buffer state of the current Send call.
+func (as *AudioSender) Stats() Stats {
+	stats := Stats{
+		LowWater:      duration(int(max(as.lowWater.Load(), 0))),
+		Underruns:     as.underruns.Load(),
+		SilenceFrames: as.silence.Load(),
+	}
+	if buf := as.buf.Load(); buf != nil {
+		buffered, capacity := buf.fill()
+		stats.Buffered, stats.Capacity = duration(buffered), duration(capacity)
+	}
+	return stats
+}
+
+// When the frame after one of samples is due. Frames more than a frame
+// late are not caught up with a burst.
+func pace(next time.Time, samples int) time.Time {
+	next = next.Add(time.Duration(samples) * time.Second / ogg.OpusSampleRate)
+	if now := time.Now(); next.Before(now) {
+		return now
+	}
+	return next
+}
+
+// Samples in d, at least one.
+func samples(d time.Duration) int {
+	return max(int(d*ogg.OpusSampleRate/time.Second), 1)
+}
+
+func duration(samples int) time.Duration {
+	return time.Duration(samples) * time.Second / ogg.OpusSampleRate
+}
+
+func (as *AudioSender) sendFrame(udpConn *net.UDPConn, secretKeys [32]byte, frame []byte, samples int) error {
+	var err error
+	if as.FrameEncryptor != nil {
+		frame, err = as.FrameEncryptor.EncryptFrame(frame)
+		if err != nil {
+			return err
+		}
+	}
+	packet, err := as.encrypt(secretKeys, frame, samples)
+	if err != nil {
+		return err
+	}
+	_, err = udpConn.Write(packet)
+	return err
+}
+
+func (as *AudioSender) sendSilence(udpConn *net.UDPConn, secretKeys [32]byte) error {
+	for i := 0; i < SILENCE_FRAMES_COUNT; i++ {
+		if err := as.sendFrame(udpConn, secretKeys, SILENCE_FRAMES, frameSamples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Played returns the audio duration sent since Send was called.
+func (as *AudioSender) Played() time.Duration {
+	return time.Duration(as.played.Load()) * time.Second / ogg.OpusSampleRate
+}
+
+func (as *AudioSender) SetSSRC(ssrc uint32) {
+	as.ssrc = ssrc
+}
+
+// Pause stops consuming frames, the encoder is blocked until Resume.
+func (as *AudioSender) Pause() {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.resume == nil {
+		as.resume = make(chan struct{})
+	}
+}
+
+func (as *AudioSender) Resume() {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.resume != nil {
+		close(as.resume)
+		as.resume = nil
+	}
+}
+
+func (as *AudioSender) IsPaused() bool {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return as.resume != nil
+}
+
+func (as *AudioSender) resumeChan() chan struct{} {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return as.resume
+}
+
+// encrypt builds the RTP packet of a frame, the timestamp advances by its
+// samples.
+func (as *AudioSender) encrypt(secretKeys [32]byte, rawData []byte, samples int) ([]byte, error) {
 
 	rtpHeader := make([]byte, 12)
 	rtpHeader[0] = 0x80
@@ -64,7 +303,7 @@ func (as *AudioSender) encrypt(secretKeys [32]byte, rawData []byte) ([]byte, err
 
 	packet := append(rtpHeader, encrypted...)
 
-	atomic.AddUint32(&as.timestamp, 960)
+	atomic.AddUint32(&as.timestamp, uint32(samples))
 	as.sequence++
 
 	return packet, nil
@@ -0,0 +1,119 @@
+package audiosender
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hendrywilliam/siren/src/ogg"
+)
+
+// ring is a bounded FIFO of opus frames between the encoder and the send
+// loop, so encoder hiccups are absorbed instead of delaying packets. It is
+// bounded by the duration of the frames, whatever their size.
+type ring struct {
+	mu     sync.Mutex
+	frames [][]byte
+	// Samples buffered and the most held before push blocks.
+	samples    int
+	maxSamples int
+	// No more frames will be pushed.
+	closed bool
+	// Signaled on every change, capacity 1.
+	changed chan struct{}
+}
+
+func newRing(maxSamples int) *ring {
+	return &ring{
+		maxSamples: max(maxSamples, 1),
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+// push appends a frame, blocking while the ring is full.
+func (r *ring) push(ctx context.Context, frame []byte) error {
+	for {
+		r.mu.Lock()
+		if r.samples < r.maxSamples {
+			r.frames = append(r.frames, frame)
+			r.samples += packetSamples(frame)
+			r.mu.Unlock()
+			r.signal()
+			return nil
+		}
+		r.mu.Unlock()
+		select {
+		case <-r.changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pop removes the oldest frame, false if empty. closed reports whether more
+// frames may come.
+func (r *ring) pop() (frame []byte, ok bool, closed bool) {
+	r.mu.Lock()
+	if len(r.frames) == 0 {
+		closed = r.closed
+		r.mu.Unlock()
+		return nil, false, closed
+	}
+	frame = r.frames[0]
+	r.frames[0] = nil
+	r.frames = r.frames[1:]
+	r.samples -= packetSamples(frame)
+	r.mu.Unlock()
+	r.signal()
+	return frame, true, false
+}
+
+// wait blocks until n samples are buffered or the ring is closed.
+func (r *ring) wait(ctx context.Context, n int) error {
+	for {
+		if r.ready(n) {
+			return nil
+		}
+		select {
+		case <-r.changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ready reports whether n samples are buffered or the ring is closed.
+func (r *ring) ready(n int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.samples >= min(n, r.maxSamples) || r.closed
+}
+
+func (r *ring) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.signal()
+}
+
+// fill returns the samples buffered and the capacity.
+func (r *ring) fill() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.samples, r.maxSamples
+}
+
+func (r *ring) signal() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Samples of an opus packet, frameSamples if it can not be parsed.
+func packetSamples(frame []byte) int {
+	samples, err := ogg.OpusPacketSamples(frame)
+	if err != nil {
+		return frameSamples
+	}
+	return samples
+}
@@ -0,0 +1,300 @@
+package dave
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// DAVE, Discord audio & video end-to-end encryption.
+// Source: https://daveprotocol.com
+const (
+	// Protocol version 0 means no end-to-end encryption.
+	DisabledProtocolVersion uint16 = 0
+	ProtocolVersion         uint16 = 1
+
+	// Transition id 0 is executed immediately, there is no execute transition event.
+	InitTransitionID uint16 = 0
+
+	exporterLabel = "Discord Secure Frames v0"
+)
+
+// Opus silence frames are never encrypted.
+var opusSilenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+var (
+	ErrMalformedBinaryMessage = errors.New("malformed dave binary message")
+	ErrNoMLS                  = errors.New("no mls implementation configured")
+	ErrUnknownTransition      = errors.New("unknown dave transition")
+	ErrNoKeyRatchet           = errors.New("no dave key ratchet for user")
+)
+
+// MLS performs group operations on serialized MLS messages (RFC 9420),
+// with the ciphersuite the protocol version mandates.
+type MLS interface {
+	// Reset discards group state and returns a new serialized key package.
+	Reset(protocolVersion uint16, groupID uint64, selfUserID uint64) (keyPackage []byte, err error)
+	SetExternalSender(externalSender []byte) error
+	// ProcessProposals returns the serialized commit and optional welcome
+	// to send back, or nil if there is nothing to commit.
+	ProcessProposals(proposals []byte) (commitWelcome []byte, err error)
+	ProcessCommit(commit []byte) error
+	ProcessWelcome(welcome []byte) error
+	// Members returns the user ids of the current group members.
+	Members() ([]uint64, error)
+	ExportSecret(label string, context []byte, length int) ([]byte, error)
+}
+
+// Server to client binary message, | seq (2) | opcode (1) | payload |.
+type BinaryMessage struct {
+	Sequence uint16
+	Opcode   uint8
+	Payload  []byte
+}
+
+func DecodeBinaryMessage(b []byte) (*BinaryMessage, error) {
+	if len(b) < 3 {
+		return nil, ErrMalformedBinaryMessage
+	}
+	return &BinaryMessage{
+		Sequence: binary.BigEndian.Uint16(b[0:2]),
+		Opcode:   b[2],
+		Payload:  b[3:],
+	}, nil
+}
+
+// Client to server binary message, | opcode (1) | payload |.
+func EncodeBinaryMessage(opcode uint8, payload []byte) []byte {
+	b := make([]byte, 0, len(payload)+1)
+	b = append(b, opcode)
+	return append(b, payload...)
+}
+
+// Payload prefixed with a transition id, used by announce commit and welcome.
+func DecodeTransitionPayload(b []byte) (uint16, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, ErrMalformedBinaryMessage
+	}
+	return binary.BigEndian.Uint16(b[0:2]), b[2:], nil
+}
+
+type Options struct {
+	UserID string
+	// Optional, end-to-end encryption is disabled without it.
+	MLS MLS
+}
+
+// Session keeps the DAVE state of a single voice connection.
+type Session struct {
+	mu     sync.Mutex
+	mls    MLS
+	userID uint64
+
+	protocolVersion    uint16
+	pendingTransitions map[uint16]uint16 // transition id -> protocol version
+
+	// Key ratchets from the latest commit or welcome, applied on transition.
+	pendingRatchets map[uint16]map[uint64]*keyRatchet
+	encryptor       *frameEncryptor
+	decryptors      map[uint64]*frameDecryptor
+}
+
+func NewSession(opts Options) *Session {
+	userID, _ := strconv.ParseUint(opts.UserID, 10, 64)
+	return &Session{
+		mls:                opts.MLS,
+		userID:             userID,
+		pendingTransitions: make(map[uint16]uint16),
+		pendingRatchets:    make(map[uint16]map[uint64]*keyRatchet),
+		decryptors:         make(map[uint64]*frameDecryptor),
+	}
+}
+
+// MaxProtocolVersion is sent in the voice identify payload.
+func (s *Session) MaxProtocolVersion() uint16 {
+	if s.mls == nil {
+		return DisabledProtocolVersion
+	}
+	return ProtocolVersion
+}
+
+func (s *Session) ProtocolVersion() uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.protocolVersion
+}
+
+// SetProtocolVersion applies the version negotiated in session description.
+func (s *Session) SetProtocolVersion(version uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protocolVersion = version
+}
+
+// PrepareTransition returns true when the transition has to be executed later
+// and transition ready must be sent.
+func (s *Session) PrepareTransition(transitionID uint16, version uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version == DisabledProtocolVersion {
+		// Downgrade, drop the keys once executed.
+		s.pendingRatchets[transitionID] = nil
+	}
+	s.pendingTransitions[transitionID] = version
+	if transitionID == InitTransitionID {
+		s.execute(transitionID)
+		return false
+	}
+	return true
+}
+
+func (s *Session) ExecuteTransition(transitionID uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pendingTransitions[transitionID]; !ok {
+		if _, ok := s.pendingRatchets[transitionID]; !ok {
+			return ErrUnknownTransition
+		}
+	}
+	s.execute(transitionID)
+	return nil
+}
+
+// Must be called with mu held.
+func (s *Session) execute(transitionID uint16) {
+	if version, ok := s.pendingTransitions[transitionID]; ok {
+		s.protocolVersion = version
+		delete(s.pendingTransitions, transitionID)
+	}
+	ratchets, ok := s.pendingRatchets[transitionID]
+	if !ok {
+		return
+	}
+	delete(s.pendingRatchets, transitionID)
+	if ratchets == nil {
+		s.encryptor = nil
+		s.decryptors = make(map[uint64]*frameDecryptor)
+		return
+	}
+	// Commit or welcome always implies an active protocol version.
+	if s.protocolVersion == DisabledProtocolVersion {
+		s.protocolVersion = ProtocolVersion
+	}
+	s.decryptors = make(map[uint64]*frameDecryptor, len(ratchets))
+	for userID, ratchet := range ratchets {
+		if userID == s.userID {
+			s.encryptor = &frameEncryptor{ratchet: ratchet}
+			continue
+		}
+		s.decryptors[userID] = &frameDecryptor{ratchet: ratchet}
+	}
+}
+
+// PrepareEpoch returns a key package when a new group must be created.
+func (s *Session) PrepareEpoch(version uint16, epoch uint64, groupID uint64) ([]byte, error) {
+	if epoch != 1 {
+		return nil, nil
+	}
+	return s.Reset(version, groupID)
+}
+
+// Reset discards the group and returns a new key package to send.
+func (s *Session) Reset(version uint16, groupID uint64) ([]byte, error) {
+	if s.mls == nil {
+		return nil, ErrNoMLS
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pendingRatchets = make(map[uint16]map[uint64]*keyRatchet)
+	return s.mls.Reset(version, groupID, s.userID)
+}
+
+func (s *Session) SetExternalSender(externalSender []byte) error {
+	if s.mls == nil {
+		return ErrNoMLS
+	}
+	return s.mls.SetExternalSender(externalSender)
+}
+
+func (s *Session) ProcessProposals(proposals []byte) ([]byte, error) {
+	if s.mls == nil {
+		return nil, ErrNoMLS
+	}
+	return s.mls.ProcessProposals(proposals)
+}
+
+func (s *Session) ProcessCommit(transitionID uint16, commit []byte) error {
+	if s.mls == nil {
+		return ErrNoMLS
+	}
+	if err := s.mls.ProcessCommit(commit); err != nil {
+		return err
+	}
+	return s.stageRatchets(transitionID)
+}
+
+func (s *Session) ProcessWelcome(transitionID uint16, welcome []byte) error {
+	if s.mls == nil {
+		return ErrNoMLS
+	}
+	if err := s.mls.ProcessWelcome(welcome); err != nil {
+		return err
+	}
+	return s.stageRatchets(transitionID)
+}
+
+// Derive every member's key ratchet from the new epoch.
+func (s *Session) stageRatchets(transitionID uint16) error {
+	members, err := s.mls.Members()
+	if err != nil {
+		return err
+	}
+	ratchets := make(map[uint64]*keyRatchet, len(members))
+	for _, userID := range members {
+		context := binary.LittleEndian.AppendUint64(nil, userID)
+		secret, err := s.mls.ExportSecret(exporterLabel, context, keySize)
+		if err != nil {
+			return err
+		}
+		ratchets[userID] = newKeyRatchet(secret)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pendingRatchets[transitionID] = ratchets
+	if transitionID == InitTransitionID {
+		s.execute(transitionID)
+	}
+	return nil
+}
+
+// EncryptFrame encrypts an outgoing opus frame, frames pass through
+// untouched while end-to-end encryption is disabled.
+func (s *Session) EncryptFrame(frame []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.protocolVersion == DisabledProtocolVersion || s.encryptor == nil || bytes.Equal(frame, opusSilenceFrame) {
+		return frame, nil
+	}
+	return s.encryptor.encrypt(frame)
+}
+
+// DecryptFrame decrypts an incoming opus frame of the given user.
+func (s *Session) DecryptFrame(userID string, frame []byte) ([]byte, error) {
+	if !isProtocolFrame(frame) {
+		// Unencrypted frames are expected during a downgrade and silence.
+		return frame, nil
+	}
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.decryptors[id]
+	if !ok {
+		return nil, ErrNoKeyRatchet
+	}
+	return d.decrypt(frame)
+}
@@ -0,0 +1,175 @@
+package dave
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// Protocol frame, supplemental data appended to the encrypted frame:
+// | tag (8) | nonce (uleb128) | unencrypted ranges (uleb128 pairs) | size (1) | magic (2) |
+// Opus frames are fully encrypted, there are no unencrypted ranges.
+const (
+	truncatedTagSize = 8
+	nonceSize        = 12
+	// Truncated nonce is placed at the end of the 12 bytes nonce.
+	truncatedNonceOffset = 8
+	generationShift      = 24
+
+	magicMarker uint16 = 0xFAFA
+	markerSize         = 2
+)
+
+var (
+	ErrMissingMagicMarker = errors.New("missing dave magic marker")
+	ErrMalformedFrame     = errors.New("malformed dave frame")
+	ErrAuthentication     = errors.New("dave frame authentication failed")
+	ErrExpiredGeneration  = errors.New("dave key generation expired")
+)
+
+type frameEncryptor struct {
+	ratchet        *keyRatchet
+	truncatedNonce uint32
+}
+
+func (fe *frameEncryptor) encrypt(frame []byte) ([]byte, error) {
+	fe.truncatedNonce++
+	generation := fe.truncatedNonce >> generationShift
+	key, err := fe.ratchet.get(generation)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nil, expandNonce(fe.truncatedNonce), frame, nil)
+	out := make([]byte, 0, len(sealed)+16)
+	out = append(out, sealed[:len(frame)+truncatedTagSize]...)
+	out = appendULEB128(out, uint64(fe.truncatedNonce))
+	supplementalSize := truncatedTagSize + ulebSize(uint64(fe.truncatedNonce)) + 1 + markerSize
+	out = append(out, byte(supplementalSize))
+	out = binary.BigEndian.AppendUint16(out, magicMarker)
+	return out, nil
+}
+
+type frameDecryptor struct {
+	ratchet *keyRatchet
+}
+
+func (fd *frameDecryptor) decrypt(frame []byte) ([]byte, error) {
+	ciphertext, tag, truncatedNonce, err := parseFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+	key, err := fd.ratchet.get(truncatedNonce >> generationShift)
+	if err != nil {
+		return nil, err
+	}
+	return openTruncated(key, expandNonce(truncatedNonce), ciphertext, tag)
+}
+
+func isProtocolFrame(frame []byte) bool {
+	return len(frame) >= markerSize && binary.BigEndian.Uint16(frame[len(frame)-markerSize:]) == magicMarker
+}
+
+func parseFrame(frame []byte) (ciphertext []byte, tag []byte, truncatedNonce uint32, err error) {
+	if !isProtocolFrame(frame) {
+		return nil, nil, 0, ErrMissingMagicMarker
+	}
+	if len(frame) < markerSize+1 {
+		return nil, nil, 0, ErrMalformedFrame
+	}
+	supplementalSize := int(frame[len(frame)-markerSize-1])
+	if supplementalSize < truncatedTagSize+1+1+markerSize || supplementalSize > len(frame) {
+		return nil, nil, 0, ErrMalformedFrame
+	}
+	supplemental := frame[len(frame)-supplementalSize : len(frame)-markerSize-1]
+	tag = supplemental[:truncatedTagSize]
+	nonce, n := readULEB128(supplemental[truncatedTagSize:])
+	if n <= 0 || nonce > 0xFFFFFFFF {
+		return nil, nil, 0, ErrMalformedFrame
+	}
+	if truncatedTagSize+n != len(supplemental) {
+		// Unencrypted ranges are only used by video codecs.
+		return nil, nil, 0, ErrMalformedFrame
+	}
+	return frame[:len(frame)-supplementalSize], tag, uint32(nonce), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Go GCM does not accept tags shorter than 12 bytes. Decrypt with CTR
+// then recompute the full tag and compare its truncated prefix.
+func openTruncated(key []byte, nonce []byte, ciphertext []byte, tag []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, nonce)
+	// J0 = nonce || 1, payload starts at inc32(J0).
+	binary.BigEndian.PutUint32(counter[nonceSize:], 2)
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, counter).XORKeyStream(plaintext, ciphertext)
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nil, nonce, plaintext, nil)
+	if subtle.ConstantTimeCompare(sealed[len(plaintext):len(plaintext)+truncatedTagSize], tag) != 1 {
+		return nil, ErrAuthentication
+	}
+	return plaintext, nil
+}
+
+func expandNonce(truncatedNonce uint32) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.LittleEndian.PutUint32(nonce[truncatedNonceOffset:], truncatedNonce)
+	return nonce
+}
+
+func appendULEB128(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b = append(b, c|0x80)
+			continue
+		}
+		return append(b, c)
+	}
+}
+
+func ulebSize(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// Returns the value and bytes read, 0 if the input is truncated.
+func readULEB128(b []byte) (uint64, int) {
+	var v uint64
+	for i, c := range b {
+		if i >= 10 {
+			return 0, 0
+		}
+		v |= uint64(c&0x7f) << (7 * i)
+		if c&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,149 @@
+package dave
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestULEB128(t *testing.T) {
+	tests := []struct {
+		v   uint64
+		enc string
+	}{
+		{0, "00"},
+		{1, "01"},
+		{127, "7f"},
+		{128, "8001"},
+		{300, "ac02"},
+		{16384, "808001"},
+		{0xFFFFFFFF, "ffffffff0f"},
+	}
+	for _, tt := range tests {
+		enc := appendULEB128(nil, tt.v)
+		if hex.EncodeToString(enc) != tt.enc {
+			t.Errorf("%d: encoded %x, want %s", tt.v, enc, tt.enc)
+		}
+		if ulebSize(tt.v) != len(enc) {
+			t.Errorf("%d: size %d, want %d", tt.v, ulebSize(tt.v), len(enc))
+		}
+		v, n := readULEB128(append(enc, 0xff))
+		if v != tt.v || n != len(enc) {
+			t.Errorf("%s: read %d (%d bytes)", tt.enc, v, n)
+		}
+		if v, n := readULEB128(enc[:len(enc)-1]); n != 0 || v != 0 {
+			t.Errorf("%s truncated: read %d (%d bytes)", tt.enc, v, n)
+		}
+	}
+	if _, n := readULEB128(bytes.Repeat([]byte{0x80}, 11)); n != 0 {
+		t.Errorf("overlong: read %d bytes", n)
+	}
+}
+
+// AES-128-GCM test case 2 of the GCM specification, tag truncated to 8 bytes.
+func TestOpenTruncatedKnownAnswer(t *testing.T) {
+	key := make([]byte, 16)
+	nonce := make([]byte, nonceSize)
+	ciphertext := mustHex(t, "0388dace60b6a392f328c2b971b2fe78")
+	tag := mustHex(t, "ab6e47d42cec13bd")
+	plaintext, err := openTruncated(key, nonce, ciphertext, tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, make([]byte, 16)) {
+		t.Fatalf("plaintext %x", plaintext)
+	}
+	tag[7] ^= 1
+	if _, err := openTruncated(key, nonce, ciphertext, tag); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("tampered tag: got %v, want %v", err, ErrAuthentication)
+	}
+}
+
+func TestFrameLayout(t *testing.T) {
+	secret := bytes.Repeat([]byte{7}, secretSize)
+	enc := &frameEncryptor{ratchet: newKeyRatchet(secret), truncatedNonce: 299}
+	payload := []byte("opus frame")
+	frame, err := enc.encrypt(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nonce 300 takes two uleb128 bytes.
+	supplementalSize := truncatedTagSize + 2 + 1 + markerSize
+	if len(frame) != len(payload)+supplementalSize {
+		t.Fatalf("frame length %d", len(frame))
+	}
+	if frame[len(frame)-3] != byte(supplementalSize) || !bytes.Equal(frame[len(frame)-2:], []byte{0xfa, 0xfa}) {
+		t.Fatalf("trailer %x", frame[len(frame)-3:])
+	}
+	if !bytes.Equal(frame[len(payload)+truncatedTagSize:len(frame)-3], []byte{0xac, 0x02}) {
+		t.Fatalf("nonce %x", frame[len(payload)+truncatedTagSize:len(frame)-3])
+	}
+	ciphertext, tag, nonce, err := parseFrame(frame)
+	if err != nil || nonce != 300 || len(ciphertext) != len(payload) || len(tag) != truncatedTagSize {
+		t.Fatalf("parsed nonce %d, %d bytes, %v", nonce, len(ciphertext), err)
+	}
+
+	dec := &frameDecryptor{ratchet: newKeyRatchet(secret)}
+	got, err := dec.decrypt(frame)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("decrypted %q, %v", got, err)
+	}
+	frame[len(payload)] ^= 1
+	if _, err := dec.decrypt(frame); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("tampered tag: got %v, want %v", err, ErrAuthentication)
+	}
+}
+
+func TestParseFrameErrors(t *testing.T) {
+	tag := make([]byte, truncatedTagSize)
+	tests := []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"empty", nil, ErrMissingMagicMarker},
+		{"no marker", []byte{1, 2, 3, 4}, ErrMissingMagicMarker},
+		{"marker only", []byte{0xfa, 0xfa}, ErrMalformedFrame},
+		{"short size", append([]byte{1, 2, 3}, 4, 0xfa, 0xfa), ErrMalformedFrame},
+		{"size past frame", append(append([]byte{}, tag...), 0, 200, 0xfa, 0xfa), ErrMalformedFrame},
+		{"truncated nonce", append(append([]byte{}, tag...), 0x80, 12, 0xfa, 0xfa), ErrMalformedFrame},
+		{"unencrypted ranges", append(append([]byte{}, tag...), 1, 0, 4, 15, 0xfa, 0xfa), ErrMalformedFrame},
+	}
+	for _, tt := range tests {
+		if _, _, _, err := parseFrame(tt.frame); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	if _, _, nonce, err := parseFrame(append(append([]byte{}, tag...), 5, 12, 0xfa, 0xfa)); err != nil || nonce != 5 {
+		t.Errorf("valid: nonce %d, %v", nonce, err)
+	}
+}
+
+func TestFrameGenerationRollover(t *testing.T) {
+	secret := bytes.Repeat([]byte{3}, secretSize)
+	enc := &frameEncryptor{ratchet: newKeyRatchet(secret), truncatedNonce: 0x00FFFFFE}
+	dec := &frameDecryptor{ratchet: newKeyRatchet(secret)}
+	for i, want := range []uint32{0, 1} {
+		frame, err := enc.encrypt([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, nonce, err := parseFrame(frame)
+		if err != nil || nonce>>generationShift != want {
+			t.Fatalf("frame %d: generation %d, %v", i, nonce>>generationShift, err)
+		}
+		if got, err := dec.decrypt(frame); err != nil || !bytes.Equal(got, []byte{byte(i)}) {
+			t.Fatalf("frame %d: decrypted %x, %v", i, got, err)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package dave
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/hendrywilliam/siren/src/mls"
+)
+
+// Proposals operation types.
+const (
+	proposalsAppend uint8 = 0
+	proposalsRevoke uint8 = 1
+)
+
+var (
+	ErrIgnoredCommit      = errors.New("dave commit ignored before joining the group")
+	ErrNoGroup            = errors.New("no dave mls group")
+	ErrUnsupportedVersion = errors.New("unsupported dave protocol version")
+	ErrMalformedProposals = errors.New("malformed dave proposals")
+	ErrUnexpectedWelcome  = errors.New("dave welcome for another group")
+)
+
+// groupMLS implements MLS with the mls package. Until the voice server adds
+// this member to the call's group it keeps a group of its own, which becomes
+// the call's group when its commit is the one announced.
+type groupMLS struct {
+	mu             sync.Mutex
+	groupID        []byte
+	keyPackage     *mls.KeyPackage
+	externalSender *mls.ExternalSender
+
+	group   *mls.Group
+	pending *mls.Group
+
+	// Our last commit and the group it leads to once announced.
+	commit    []byte
+	committed *mls.Group
+}
+
+// NewMLS returns the MLS implementation used by DAVE protocol version 1.
+func NewMLS() MLS {
+	return &groupMLS{}
+}
+
+func (m *groupMLS) Reset(protocolVersion uint16, groupID uint64, selfUserID uint64) ([]byte, error) {
+	if protocolVersion != ProtocolVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	keyPackage, err := mls.NewKeyPackage(binary.BigEndian.AppendUint64(nil, selfUserID))
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.groupID = binary.BigEndian.AppendUint64(nil, groupID)
+	m.keyPackage = keyPackage
+	m.group, m.pending, m.commit, m.committed = nil, nil, nil, nil
+	if err := m.createPending(); err != nil {
+		return nil, err
+	}
+	return keyPackage.Bytes(), nil
+}
+
+func (m *groupMLS) SetExternalSender(externalSender []byte) error {
+	sender, err := mls.ParseExternalSender(externalSender)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.externalSender = sender
+	return m.createPending()
+}
+
+// Must be called with mu held.
+func (m *groupMLS) createPending() error {
+	if m.group != nil || m.keyPackage == nil || m.externalSender == nil {
+		return nil
+	}
+	pending, err := mls.NewGroup(m.groupID, m.keyPackage, *m.externalSender)
+	if err != nil {
+		return err
+	}
+	m.pending = pending
+	return nil
+}
+
+// Must be called with mu held.
+func (m *groupMLS) current() *mls.Group {
+	if m.group != nil {
+		return m.group
+	}
+	return m.pending
+}
+
+// ProcessProposals applies appended or revoked proposals and commits
+// whatever is left.
+func (m *groupMLS) ProcessProposals(proposals []byte) ([]byte, error) {
+	if len(proposals) == 0 {
+		return nil, ErrMalformedProposals
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := m.current()
+	if g == nil {
+		return nil, ErrNoGroup
+	}
+	v, rest, err := readVarBytes(proposals[1:])
+	if err != nil || len(rest) != 0 {
+		return nil, ErrMalformedProposals
+	}
+	switch proposals[0] {
+	case proposalsAppend:
+		for len(v) > 0 {
+			var message []byte
+			message, v, err = mls.ReadMessage(v)
+			if err != nil {
+				return nil, err
+			}
+			// Proposals of another epoch are for a commit already made.
+			if err := g.AddProposal(message); err != nil && !errors.Is(err, mls.ErrWrongEpoch) {
+				return nil, err
+			}
+		}
+	case proposalsRevoke:
+		for len(v) > 0 {
+			var ref []byte
+			if ref, v, err = readVarBytes(v); err != nil {
+				return nil, ErrMalformedProposals
+			}
+			g.RevokeProposal(ref)
+		}
+	default:
+		return nil, ErrMalformedProposals
+	}
+	if g.Proposals() == 0 {
+		m.commit, m.committed = nil, nil
+		return nil, nil
+	}
+	commit, welcome, next, err := g.Commit()
+	if err != nil {
+		return nil, err
+	}
+	m.commit, m.committed = commit, next
+	return append(bytes.Clone(commit), welcome...), nil
+}
+
+func (m *groupMLS) ProcessCommit(commit []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.commit != nil && bytes.Equal(commit, m.commit) {
+		m.group = m.committed
+	} else {
+		if m.group == nil {
+			// Someone else's commit adds us, a welcome follows.
+			return ErrIgnoredCommit
+		}
+		next, err := m.group.ProcessCommit(commit)
+		if err != nil {
+			return err
+		}
+		m.group = next
+	}
+	m.pending, m.commit, m.committed = nil, nil, nil
+	return nil
+}
+
+func (m *groupMLS) ProcessWelcome(welcome []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keyPackage == nil {
+		return ErrNoGroup
+	}
+	g, err := mls.Join(welcome, m.keyPackage)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(g.GroupID(), m.groupID) {
+		return ErrUnexpectedWelcome
+	}
+	m.group = g
+	m.pending, m.commit, m.committed = nil, nil, nil
+	return nil
+}
+
+func (m *groupMLS) Members() ([]uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.group == nil {
+		return nil, ErrNoGroup
+	}
+	var members []uint64
+	for _, identity := range m.group.Members() {
+		if len(identity) != 8 {
+			continue
+		}
+		members = append(members, binary.BigEndian.Uint64(identity))
+	}
+	return members, nil
+}
+
+func (m *groupMLS) ExportSecret(label string, context []byte, length int) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.group == nil {
+		return nil, ErrNoGroup
+	}
+	return m.group.Export(label, context, length), nil
+}
+
+// readVarBytes reads an MLS variable length vector.
+func readVarBytes(b []byte) ([]byte, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, ErrMalformedBinaryMessage
+	}
+	var n, header int
+	switch b[0] >> 6 {
+	case 0:
+		n, header = int(b[0]), 1
+	case 1:
+		if len(b) < 2 {
+			return nil, nil, ErrMalformedBinaryMessage
+		}
+		n, header = int(binary.BigEndian.Uint16(b)&0x3fff), 2
+	case 2:
+		if len(b) < 4 {
+			return nil, nil, ErrMalformedBinaryMessage
+		}
+		n, header = int(binary.BigEndian.Uint32(b)&0x3fffffff), 4
+	default:
+		return nil, nil, ErrMalformedBinaryMessage
+	}
+	if len(b)-header < n {
+		return nil, nil, ErrMalformedBinaryMessage
+	}
+	return b[header : header+n], b[header+n:], nil
+}
@@ -0,0 +1,143 @@
+package dave
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/hendrywilliam/siren/src/mls"
+)
+
+const testChannelID uint64 = 1234
+
+// appendProposals is the payload of an mls proposals message.
+func appendProposals(messages ...[]byte) []byte {
+	return appendVarBytes([]byte{proposalsAppend}, bytes.Join(messages, nil))
+}
+
+func TestSessionEndToEnd(t *testing.T) {
+	server, err := mls.NewExternalSigner([]byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := server.Sender()
+	alice := NewSession(Options{UserID: "1", MLS: NewMLS()})
+	bob := NewSession(Options{UserID: "2", MLS: NewMLS()})
+	if alice.MaxProtocolVersion() != ProtocolVersion {
+		t.Fatalf("max protocol version %d", alice.MaxProtocolVersion())
+	}
+
+	var keyPackages [][]byte
+	for _, s := range []*Session{alice, bob} {
+		keyPackage, err := s.PrepareEpoch(ProtocolVersion, 1, testChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyPackages = append(keyPackages, keyPackage)
+		if err := s.SetExternalSender(sender.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both are asked to add the other, alice's commit is announced.
+	groupID := []byte{0, 0, 0, 0, 0, 0, 0x04, 0xd2}
+	addBob, err := server.ProposeAdd(groupID, 0, keyPackages[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitWelcome, err := alice.ProcessProposals(appendProposals(addBob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, welcome, err := mls.ReadMessage(commitWelcome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addAlice, err := server.ProposeAdd(groupID, 0, keyPackages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.ProcessProposals(appendProposals(addAlice)); err != nil {
+		t.Fatal(err)
+	}
+
+	const transitionID = 7
+	if err := alice.ProcessCommit(transitionID, commit); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.ProcessCommit(transitionID, commit); !errors.Is(err, ErrIgnoredCommit) {
+		t.Fatalf("commit adding bob: got %v, want %v", err, ErrIgnoredCommit)
+	}
+	if err := bob.ProcessWelcome(transitionID, welcome); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Session{alice, bob} {
+		if err := s.ExecuteTransition(transitionID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frame := []byte("an opus frame that is not silence")
+	encrypted, err := alice.EncryptFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(encrypted, frame) {
+		t.Fatal("frame was not encrypted")
+	}
+	decrypted, err := bob.DecryptFrame("1", encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, frame) {
+		t.Fatalf("decrypted %q", decrypted)
+	}
+	encrypted, err = bob.EncryptFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err = alice.DecryptFrame("2", encrypted); err != nil || !bytes.Equal(decrypted, frame) {
+		t.Fatalf("decrypted %q, %v", decrypted, err)
+	}
+}
+
+func TestProcessProposalsRevoke(t *testing.T) {
+	server, err := mls.NewExternalSigner([]byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMLS()
+	if _, err := m.Reset(ProtocolVersion, testChannelID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ProcessProposals(appendProposals()); !errors.Is(err, ErrNoGroup) {
+		t.Fatalf("before the external sender: got %v, want %v", err, ErrNoGroup)
+	}
+	sender := server.Sender()
+	if err := m.SetExternalSender(sender.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	other := NewMLS()
+	keyPackage, err := other.Reset(ProtocolVersion, testChannelID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add, err := server.ProposeAdd([]byte{0, 0, 0, 0, 0, 0, 0x04, 0xd2}, 0, keyPackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commitWelcome, err := m.ProcessProposals(appendProposals(add)); err != nil || commitWelcome == nil {
+		t.Fatalf("append: %v", err)
+	}
+	ref, err := mls.ProposalRef(add)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitWelcome, err := m.ProcessProposals(appendVarBytes([]byte{proposalsRevoke}, appendVarBytes(nil, ref)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commitWelcome != nil {
+		t.Fatal("committed with every proposal revoked")
+	}
+}
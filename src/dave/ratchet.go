@@ -0,0 +1,88 @@
+package dave
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	keySize    = 16 // AES-128-GCM
+	secretSize = sha256.Size
+
+	// Keep keys of past generations for in-flight frames.
+	maxRetainedGenerations = 4
+)
+
+// ExpandWithLabel as defined in RFC 9420 section 8.
+func expandWithLabel(secret []byte, label string, context []byte, length int) ([]byte, error) {
+	fullLabel := "MLS 1.0 " + label
+	kdfLabel := binary.BigEndian.AppendUint16(nil, uint16(length))
+	kdfLabel = appendVarBytes(kdfLabel, []byte(fullLabel))
+	kdfLabel = appendVarBytes(kdfLabel, context)
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, secret, kdfLabel), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MLS variable length vector, RFC 9420 section 2.1.2.
+func appendVarBytes(b []byte, v []byte) []byte {
+	n := len(v)
+	switch {
+	case n < 1<<6:
+		b = append(b, byte(n))
+	case n < 1<<14:
+		b = binary.BigEndian.AppendUint16(b, uint16(n)|0x4000)
+	default:
+		b = binary.BigEndian.AppendUint32(b, uint32(n)|0x80000000)
+	}
+	return append(b, v...)
+}
+
+// keyRatchet derives per generation frame keys from a sender base secret,
+// it mirrors the MLS secret tree hash ratchet.
+type keyRatchet struct {
+	secret     []byte
+	generation uint32
+	keys       map[uint32][]byte
+}
+
+func newKeyRatchet(baseSecret []byte) *keyRatchet {
+	return &keyRatchet{
+		secret: baseSecret,
+		keys:   make(map[uint32][]byte),
+	}
+}
+
+func (kr *keyRatchet) get(generation uint32) ([]byte, error) {
+	if key, ok := kr.keys[generation]; ok {
+		return key, nil
+	}
+	if generation < kr.generation {
+		return nil, ErrExpiredGeneration
+	}
+	for kr.generation <= generation {
+		context := binary.BigEndian.AppendUint32(nil, kr.generation)
+		key, err := expandWithLabel(kr.secret, "key", context, keySize)
+		if err != nil {
+			return nil, err
+		}
+		next, err := expandWithLabel(kr.secret, "secret", context, secretSize)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[kr.generation] = key
+		kr.secret = next
+		kr.generation++
+	}
+	for g := range kr.keys {
+		if g+maxRetainedGenerations < generation {
+			delete(kr.keys, g)
+		}
+	}
+	return kr.keys[generation], nil
+}
@@ -0,0 +1,44 @@
+package dave
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestKeyRatchet(t *testing.T) {
+	secret := bytes.Repeat([]byte{1}, secretSize)
+	kr := newKeyRatchet(secret)
+
+	// Generation n is keyed from the secret ratcheted n times.
+	want := make([][]byte, 12)
+	s := secret
+	for g := range want {
+		context := binary.BigEndian.AppendUint32(nil, uint32(g))
+		key, err := expandWithLabel(s, "key", context, keySize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[g] = key
+		if s, err = expandWithLabel(s, "secret", context, secretSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, g := range []uint32{0, 1, 3, 2} {
+		key, err := kr.get(g)
+		if err != nil || !bytes.Equal(key, want[g]) {
+			t.Fatalf("generation %d: %x, %v", g, key, err)
+		}
+	}
+	if key, err := kr.get(10); err != nil || !bytes.Equal(key, want[10]) {
+		t.Fatalf("generation 10: %x, %v", key, err)
+	}
+	// Keys within maxRetainedGenerations of the latest are kept.
+	if key, err := kr.get(6); err != nil || !bytes.Equal(key, want[6]) {
+		t.Fatalf("generation 6: %x, %v", key, err)
+	}
+	if _, err := kr.get(5); !errors.Is(err, ErrExpiredGeneration) {
+		t.Fatalf("generation 5: got %v, want %v", err, ErrExpiredGeneration)
+	}
+}
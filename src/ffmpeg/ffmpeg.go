@@ -0,0 +1,442 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrFileNotFound     = errors.New("file not found")
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+	ErrKilled           = errors.New("process killed")
+	ErrFailed           = errors.New("process failed")
+	ErrBusy             = errors.New("too many ffmpeg processes")
+	ErrClosed           = errors.New("supervisor closed")
+)
+
+// How long a streaming process waits for a free slot before ErrBusy.
+var ACQUIRE_TIMEOUT = 10 * time.Second
+
+// How long pipes may stay open after the process exited, and a process
+// whose output was fully read may take to exit before it is killed.
+var WAIT_DELAY = 5 * time.Second
+
+// Stderr lines kept for errors.
+var MAX_STDERR_LINES = 10
+
+// Stderr messages, matched in order, of the errors users can act upon.
+var stderrErrors = []struct {
+	err      error
+	messages []string
+}{
+	{ErrFileNotFound, []string{
+		"No such file or directory",
+		"404 Not Found",
+		"HTTP error 404",
+		"HTTP error 410",
+	}},
+	{ErrUnsupportedCodec, []string{
+		"Invalid data found when processing input",
+		"could not find codec parameters",
+		"no decoder found",
+		"Decoder not found",
+		"Unknown encoder",
+		"Unsupported codec",
+		"matches no streams",
+		"does not contain any stream",
+	}},
+}
+
+// Error is a failed process, errors.Is matches its kind.
+type Error struct {
+	Name     string
+	Kind     error
+	ExitCode int
+	// Last lines of stderr.
+	Stderr string
+}
+
+func (e *Error) Error() string {
+	if line := lastLine(e.Stderr); line != "" {
+		return fmt.Sprintf("%s: %v: %s", e.Name, e.Kind, line)
+	}
+	return fmt.Sprintf("%s: %v (exit status %d)", e.Name, e.Kind, e.ExitCode)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+type Options struct {
+	// Processes running at once, unlimited if 0. Background jobs started
+	// with Run get a quarter of them, streams are never starved.
+	MaxProcesses int
+	// Defaults to slog.Default().
+	Log *slog.Logger
+}
+
+// Supervisor runs ffmpeg and ffprobe processes. It limits how many run at
+// once, captures their stderr into typed errors and always reaps them.
+type Supervisor struct {
+	sem        chan struct{}
+	background chan struct{}
+	log        *slog.Logger
+
+	mu     sync.Mutex
+	procs  map[*Process]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Default is used by the package functions.
+var Default = NewSupervisor(Options{})
+
+func NewSupervisor(opts Options) *Supervisor {
+	s := &Supervisor{
+		log:   opts.Log,
+		procs: make(map[*Process]struct{}),
+	}
+	if s.log == nil {
+		s.log = slog.Default()
+	}
+	if opts.MaxProcesses > 0 {
+		s.sem = make(chan struct{}, opts.MaxProcesses)
+		s.background = make(chan struct{}, max(1, opts.MaxProcesses/4))
+	}
+	return s
+}
+
+// Process is a running ffmpeg, reading it reads its stdout.
+type Process struct {
+	stdout  *os.File
+	cmd     *exec.Cmd
+	eof     atomic.Bool
+	stopped atomic.Bool
+	done    chan struct{}
+	err     error
+}
+
+func (p *Process) Read(b []byte) (int, error) {
+	n, err := p.stdout.Read(b)
+	if errors.Is(err, io.EOF) {
+		p.eof.Store(true)
+	}
+	return n, err
+}
+
+// Wait returns once the process exited, with a *Error if it failed.
+func (p *Process) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Stop closes stdout and waits for the process. A process whose output was
+// fully read gets WAIT_DELAY to exit with its own status, any other is
+// killed and reports no error.
+func (p *Process) Stop() error {
+	if p.eof.Load() {
+		select {
+		case <-p.done:
+			p.stdout.Close()
+			return p.err
+		case <-time.After(WAIT_DELAY):
+		}
+	}
+	p.stdout.Close()
+	select {
+	case <-p.done:
+		return p.err
+	default:
+	}
+	p.stopped.Store(true)
+	p.cmd.Process.Kill()
+	<-p.done
+	return nil
+}
+
+// Start runs ffmpeg with stdin fed from in, if any. The caller reads its
+// output from the process and must call Stop. Canceling ctx kills it.
+func (s *Supervisor) Start(ctx context.Context, args []string, in io.Reader) (*Process, error) {
+	acquireCtx, cancel := context.WithTimeout(ctx, ACQUIRE_TIMEOUT)
+	defer cancel()
+	if err := s.acquire(acquireCtx, s.sem); err != nil {
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return nil, ErrBusy
+		}
+		return nil, err
+	}
+	p, err := s.start(ctx, args, in)
+	if err != nil {
+		s.release(s.sem)
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *Supervisor) start(ctx context.Context, args []string, in io.Reader) (*Process, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.WaitDelay = WAIT_DELAY
+	stderr := &stderrWriter{}
+	cmd.Stderr = stderr
+	// A pipe of our own, unlike StdoutPipe it may be read after Wait.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = w
+	var stdin io.WriteCloser
+	if in != nil {
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			r.Close()
+			w.Close()
+			return nil, err
+		}
+	}
+	if err := s.track(); err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		s.untrack()
+		return nil, startError("ffmpeg", err)
+	}
+	p := &Process{stdout: r, cmd: cmd, done: make(chan struct{})}
+	log := s.log.With("pid", cmd.Process.Pid)
+	stderr.setLine(func(line string) {
+		log.Debug("ffmpeg: " + line)
+	})
+	s.mu.Lock()
+	s.procs[p] = struct{}{}
+	s.mu.Unlock()
+	if stdin != nil {
+		go func() {
+			io.Copy(stdin, in)
+			stdin.Close()
+		}()
+	}
+	go func() {
+		err := cmd.Wait()
+		p.err = classify(ctx, "ffmpeg", err, stderr.String(), p.stopped.Load())
+		s.mu.Lock()
+		delete(s.procs, p)
+		s.mu.Unlock()
+		s.untrack()
+		s.release(s.sem)
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// Output of a process run to completion.
+type Output struct {
+	Stdout []byte
+	Stderr []byte
+}
+
+// Run runs name, ffmpeg or ffprobe, to completion as a background job,
+// waiting for a free slot as long as ctx allows. Stdin is fed from in, if any.
+func (s *Supervisor) Run(ctx context.Context, name string, args []string, in io.Reader) (Output, error) {
+	if err := s.acquire(ctx, s.background); err != nil {
+		return Output{}, err
+	}
+	defer s.release(s.background)
+	if err := s.acquire(ctx, s.sem); err != nil {
+		return Output{}, err
+	}
+	defer s.release(s.sem)
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = WAIT_DELAY
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = in
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := s.track(); err != nil {
+		return Output{}, err
+	}
+	defer s.untrack()
+	err := cmd.Start()
+	if err != nil {
+		return Output{}, startError(name, err)
+	}
+	err = classify(ctx, name, cmd.Wait(), tail(stderr.String()), false)
+	return Output{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, err
+}
+
+// Close kills running streams and waits for every process to exit. Later
+// starts fail with ErrClosed.
+func (s *Supervisor) Close() {
+	s.mu.Lock()
+	s.closed = true
+	procs := make([]*Process, 0, len(s.procs))
+	for p := range s.procs {
+		procs = append(procs, p)
+	}
+	s.mu.Unlock()
+	for _, p := range procs {
+		p.Stop()
+	}
+	s.wg.Wait()
+}
+
+// Counts a process about to start, the caller must call untrack once it
+// has been waited for.
+func (s *Supervisor) track() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.wg.Add(1)
+	return nil
+}
+
+func (s *Supervisor) untrack() {
+	s.wg.Done()
+}
+
+func (s *Supervisor) acquire(ctx context.Context, sem chan struct{}) error {
+	if sem == nil {
+		return nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Supervisor) release(sem chan struct{}) {
+	if sem != nil {
+		<-sem
+	}
+}
+
+// Start runs ffmpeg through the default supervisor.
+func Start(ctx context.Context, args []string, in io.Reader) (*Process, error) {
+	return Default.Start(ctx, args, in)
+}
+
+// Run runs a background job through the default supervisor.
+func Run(ctx context.Context, name string, args []string, in io.Reader) (Output, error) {
+	return Default.Run(ctx, name, args, in)
+}
+
+func startError(name string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%s is not installed: %w", name, err)
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// classify turns the Wait error into a *Error, nil if the process succeeded.
+func classify(ctx context.Context, name string, err error, stderr string, stopped bool) error {
+	if err == nil {
+		return nil
+	}
+	e := &Error{Name: name, Kind: ErrFailed, ExitCode: -1, Stderr: stderr}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		e.ExitCode = exit.ExitCode()
+	}
+	if stopped || ctx.Err() != nil || e.ExitCode == -1 {
+		// Stopped, canceled or killed by a signal.
+		e.Kind = ErrKilled
+		return e
+	}
+	for _, kind := range stderrErrors {
+		for _, message := range kind.messages {
+			if strings.Contains(stderr, message) {
+				e.Kind = kind.err
+				return e
+			}
+		}
+	}
+	return e
+}
+
+// stderrWriter keeps the last lines written and passes complete lines to
+// line, if set.
+type stderrWriter struct {
+	mu      sync.Mutex
+	partial []byte
+	lines   []string
+	line    func(string)
+}
+
+func (w *stderrWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, b...)
+	for {
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.add(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+// Lines written before are not passed.
+func (w *stderrWriter) setLine(line func(string)) {
+	w.mu.Lock()
+	w.line = line
+	w.mu.Unlock()
+}
+
+// Must be called with mu held.
+func (w *stderrWriter) add(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	if w.line != nil {
+		w.line(line)
+	}
+	w.lines = append(w.lines, line)
+	if len(w.lines) > MAX_STDERR_LINES {
+		w.lines = w.lines[len(w.lines)-MAX_STDERR_LINES:]
+	}
+}
+
+func (w *stderrWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.add(string(w.partial))
+		w.partial = nil
+	}
+	return strings.Join(w.lines, "\n")
+}
+
+// Last MAX_STDERR_LINES lines of s.
+func tail(s string) string {
+	w := &stderrWriter{}
+	w.Write([]byte(s))
+	return w.String()
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+// Highest voice channel bitrate by boost tier.
+var MAX_BITRATES = map[structs.PremiumTier]int{
+	structs.PremiumTierNone: 96000,
+	structs.PremiumTier1:    128000,
+	structs.PremiumTier2:    256000,
+	structs.PremiumTier3:    384000,
+}
+
+// Stage channels are capped whatever the boost tier.
+var MAX_STAGE_BITRATE = 64000
+
+// Guild state kept from gateway events.
+type guildInfo struct {
+	premiumTier structs.PremiumTier
+	// Voice and stage channels by ID.
+	channels map[string]structs.Channel
+}
+
+func isVoiceChannel(channel structs.Channel) bool {
+	return channel.Type == structs.ChannelTypeGuildVoice || channel.Type == structs.ChannelTypeGuildStageVoice
+}
+
+func (g *Gateway) onGuildCreate(guild structs.Guild) {
+	if guild.Unavailable {
+		return
+	}
+	info := &guildInfo{
+		premiumTier: guild.PremiumTier,
+		channels:    make(map[string]structs.Channel),
+	}
+	for _, channel := range guild.Channels {
+		if isVoiceChannel(channel) {
+			// Not set in GUILD_CREATE.
+			channel.GuildID = guild.ID
+			info.channels[channel.ID] = channel
+		}
+	}
+	g.guildsMu.Lock()
+	g.guilds[guild.ID] = info
+	g.guildsMu.Unlock()
+	g.retune(guild.ID)
+}
+
+// Boosts change the bitrate limit.
+func (g *Gateway) onGuildUpdate(guild structs.Guild) {
+	g.guildsMu.Lock()
+	info, ok := g.guilds[guild.ID]
+	if ok {
+		info.premiumTier = guild.PremiumTier
+	}
+	g.guildsMu.Unlock()
+	if ok {
+		g.retune(guild.ID)
+	}
+}
+
+func (g *Gateway) onGuildDelete(guild structs.Guild) {
+	// Outages keep the state, the guild comes back with GUILD_CREATE.
+	if guild.Unavailable {
+		return
+	}
+	g.guildsMu.Lock()
+	delete(g.guilds, guild.ID)
+	g.guildsMu.Unlock()
+}
+
+// onChannelUpdate handles channel creates and updates.
+func (g *Gateway) onChannelUpdate(channel structs.Channel) {
+	if !isVoiceChannel(channel) {
+		return
+	}
+	g.guildsMu.Lock()
+	info, ok := g.guilds[channel.GuildID]
+	if ok {
+		info.channels[channel.ID] = channel
+	}
+	g.guildsMu.Unlock()
+	if ok {
+		g.retune(channel.GuildID)
+	}
+}
+
+func (g *Gateway) onChannelDelete(channel structs.Channel) {
+	g.guildsMu.Lock()
+	defer g.guildsMu.Unlock()
+	if info, ok := g.guilds[channel.GuildID]; ok {
+		delete(info.channels, channel.ID)
+	}
+}
+
+func (g *Gateway) isStageChannel(guildID, channelID string) bool {
+	g.guildsMu.Lock()
+	defer g.guildsMu.Unlock()
+	info, ok := g.guilds[guildID]
+	if !ok {
+		return false
+	}
+	channel, ok := info.channels[channelID]
+	return ok && channel.Type == structs.ChannelTypeGuildStageVoice
+}
+
+// channelBitrate returns the bitrate of a voice channel within the boost
+// tier limit, 0 if unknown.
+func (g *Gateway) channelBitrate(guildID, channelID string) int {
+	g.guildsMu.Lock()
+	defer g.guildsMu.Unlock()
+	info, ok := g.guilds[guildID]
+	if !ok {
+		return 0
+	}
+	channel, ok := info.channels[channelID]
+	if !ok || channel.Bitrate == 0 {
+		return 0
+	}
+	limit, ok := MAX_BITRATES[info.premiumTier]
+	if !ok {
+		limit = MAX_BITRATES[structs.PremiumTier3]
+	}
+	if channel.Type == structs.ChannelTypeGuildStageVoice {
+		limit = min(limit, MAX_STAGE_BITRATE)
+	}
+	return min(int(channel.Bitrate), limit)
+}
+
+// Tunes the guild's voice session to its channel bitrate.
+func (g *Gateway) retune(guildID string) {
+	v := g.voiceManager.Get(guildID)
+	if v == nil {
+		return
+	}
+	v.SetBitrate(g.channelBitrate(guildID, v.ChannelID))
+}
@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/gorilla/websocket"
+	"github.com/hendrywilliam/siren/src/api"
+	"github.com/hendrywilliam/siren/src/recorder"
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+type CommandHandler = func(i *structs.Interaction) error
+
+func (g *Gateway) registerCommands() {
+	g.commands = map[structs.Command]CommandHandler{
+		structs.CommandPlay:   g.onPlayCommand,
+		structs.CommandQueue:  g.onQueueCommand,
+		structs.CommandSkip:   g.onSkipCommand,
+		structs.CommandRemove: g.onRemoveCommand,
+		structs.CommandClear:  g.onClearCommand,
+
+		structs.CommandPause:      g.onPauseCommand,
+		structs.CommandResume:     g.onResumeCommand,
+		structs.CommandStop:       g.onStopCommand,
+		structs.CommandSeek:       g.onSeekCommand,
+		structs.CommandNowPlaying: g.onNowPlayingCommand,
+		structs.CommandVolume:     g.onVolumeCommand,
+		structs.CommandFilter:     g.onFilterCommand,
+		structs.CommandNormalize:  g.onNormalizeCommand,
+		structs.CommandCrossfade:  g.onCrossfadeCommand,
+		structs.CommandSFX:        g.onSFXCommand,
+		structs.CommandPlaylist:   g.onPlaylistCommand,
+		structs.CommandPlaylists:  g.onPlaylistsCommand,
+		structs.CommandLoop:       g.onLoopCommand,
+		structs.CommandAutoplay:   g.onAutoplayCommand,
+		structs.CommandSay:        g.onSayCommand,
+		structs.CommandAnnounce:   g.onAnnounceCommand,
+		structs.CommandStage:      g.onStageCommand,
+
+		structs.CommandRecord: g.onRecordCommand,
+	}
+}
+
+func (g *Gateway) registerAutocompletes() {
+	g.autocompletes = map[structs.Command]CommandHandler{
+		structs.CommandPlay:      g.onPlayAutocomplete,
+		structs.CommandPlaylist:  g.onPlaylistAutocomplete,
+		structs.CommandPlaylists: g.onPlaylistsAutocomplete,
+	}
+}
+
+func (g *Gateway) onInteraction(i *structs.Interaction) error {
+	commands := g.commands
+	switch i.Type {
+	case structs.InteractionTypeApplicationCommand:
+	case structs.InteractionTypeApplicationCommandAutocomplete:
+		commands = g.autocompletes
+	default:
+		return nil
+	}
+	handler, ok := commands[i.Data.Name]
+	if !ok {
+		g.log.Warn("unrecognized command", "command", i.Data.Name)
+		return nil
+	}
+	return handler(i)
+}
+
+func (g *Gateway) reply(i *structs.Interaction, content string) error {
+	_, err := g.interaction.Reply(g.ctx, i.ID, i.Token, api.CreateInteractionResponseOptions{
+		InteractionResponse: &structs.InteractionResponse{
+			Type: structs.InteractionResponseTypeChannelMessageWithSource,
+			Data: structs.InteractionResponseDataMessage{
+				Content: content,
+			},
+		},
+		WithResponse: false,
+	})
+	return err
+}
+
+func (g *Gateway) replyEmbed(i *structs.Interaction, embed structs.Embed, files ...api.File) error {
+	_, err := g.interaction.Reply(g.ctx, i.ID, i.Token, api.CreateInteractionResponseOptions{
+		InteractionResponse: &structs.InteractionResponse{
+			Type: structs.InteractionResponseTypeChannelMessageWithSource,
+			Data: structs.InteractionResponseDataMessage{
+				Embeds: []structs.Embed{embed},
+			},
+		},
+		Files: files,
+	})
+	return err
+}
+
+func (g *Gateway) autocomplete(i *structs.Interaction, choices []structs.ApplicationCommandOptionChoice) error {
+	_, err := g.interaction.Reply(g.ctx, i.ID, i.Token, api.CreateInteractionResponseOptions{
+		InteractionResponse: &structs.InteractionResponse{
+			Type: structs.InteractionResponseTypeApplicationCommandAutoCompleteResult,
+			Data: structs.InteractionResponseDataAutocomplete{
+				Choices: choices,
+			},
+		},
+	})
+	return err
+}
+
+// Returns caller voice state, nil if the caller has not joined a voice channel.
+func (g *Gateway) callerVoiceState(i *structs.Interaction) (*structs.VoiceState, error) {
+	userVoiceState, err := g.voice.GetUserVoiceState(g.ctx, i.GuildID, i.Member.User.ID)
+	if err != nil {
+		return nil, err
+	}
+	if userVoiceState.SessionID == "" {
+		return nil, nil
+	}
+	return userVoiceState, nil
+}
+
+func (g *Gateway) joinVoiceChannel(vs *structs.VoiceState) error {
+	voiceStateUpdate := &structs.Event{
+		Op: OpcodeVoiceStateUpdate,
+		D: &structs.VoiceStateUpdate{
+			GuildID:   vs.GuildID,
+			ChannelID: &vs.ChannelID,
+			SelfMute:  vs.SelfMute,
+			SelfDeaf:  vs.SelfDeaf,
+		},
+	}
+	data, err := json.Marshal(voiceStateUpdate)
+	if err != nil {
+		return err
+	}
+	return g.sendEvent(websocket.BinaryMessage, data)
+}
+
+func (g *Gateway) leaveVoiceChannel(guildID string) error {
+	voiceStateUpdate := &structs.Event{
+		Op: OpcodeVoiceStateUpdate,
+		D: &structs.VoiceStateUpdate{
+			GuildID:   guildID,
+			ChannelID: nil,
+		},
+	}
+	data, err := json.Marshal(voiceStateUpdate)
+	if err != nil {
+		return err
+	}
+	return g.sendEvent(websocket.BinaryMessage, data)
+}
+
+func (g *Gateway) onRecordCommand(i *structs.Interaction) error {
+	sub, ok := i.Data.SubCommand()
+	if !ok {
+		return g.reply(i, "Use `/record start` or `/record stop`.")
+	}
+	switch sub.Name {
+	case "start":
+		userVoiceState, err := g.callerVoiceState(i)
+		if err != nil {
+			return err
+		}
+		if userVoiceState == nil {
+			return g.reply(i, fmt.Sprintf("%s, join to a voice channel first.", i.Member.User.Mention()))
+		}
+		if v := g.voiceManager.Get(i.GuildID); v != nil && v.IsReady() {
+			_, err := v.StartRecording(g.recorderOptions(i.GuildID))
+			if errors.Is(err, recorder.ErrAlreadyRecording) {
+				return g.reply(i, "Already recording.")
+			}
+			if err != nil {
+				return err
+			}
+			return g.reply(i, "Recording started.")
+		}
+		// Start once the voice connection is ready.
+		g.pendingMu.Lock()
+		g.pendingRecordings[i.GuildID] = struct{}{}
+		g.pendingMu.Unlock()
+		if err := g.reply(i, "Joining, recording will start shortly."); err != nil {
+			return err
+		}
+		return g.joinVoiceChannel(userVoiceState)
+	case "stop":
+		g.pendingMu.Lock()
+		delete(g.pendingRecordings, i.GuildID)
+		g.pendingMu.Unlock()
+		v := g.voiceManager.Get(i.GuildID)
+		if v == nil {
+			return g.reply(i, "Not recording.")
+		}
+		err := v.StopRecording()
+		if errors.Is(err, recorder.ErrNotRecording) {
+			return g.reply(i, "Not recording.")
+		}
+		if err != nil {
+			return err
+		}
+		return g.reply(i, "Recording stopped.")
+	default:
+		return g.reply(i, "Use `/record start` or `/record stop`.")
+	}
+}
+
+func (g *Gateway) recorderOptions(guildID string) recorder.Options {
+	return recorder.Options{
+		Dir:         filepath.Join(g.recordingDir, guildID),
+		MaxDuration: g.recordingMaxDuration,
+		Log:         g.log.With("guild_id", guildID),
+	}
+}
+
+// Start recording requested before the voice connection was ready.
+func (g *Gateway) startPendingRecording(guildID string) {
+	g.pendingMu.Lock()
+	_, ok := g.pendingRecordings[guildID]
+	delete(g.pendingRecordings, guildID)
+	g.pendingMu.Unlock()
+	if !ok {
+		return
+	}
+	v := g.voiceManager.Get(guildID)
+	if v == nil {
+		return
+	}
+	if _, err := v.StartRecording(g.recorderOptions(guildID)); err != nil {
+		g.log.Error(err.Error(), "guild_id", guildID)
+	}
+}
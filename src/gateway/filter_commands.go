@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+// Preset, tempo and pitch combine into one chain replacing the current one.
+func (g *Gateway) onFilterCommand(i *structs.Interaction) error {
+	v := g.voiceManager.Get(i.GuildID)
+	if v == nil {
+		return g.reply(i, "Not connected to a voice channel.")
+	}
+	chain := audio.Chain{}
+	applied := []string{}
+	if option, ok := i.Data.Option("preset"); ok {
+		preset, err := audio.Preset(option.String())
+		if errors.Is(err, audio.ErrUnknownPreset) {
+			return g.reply(i, fmt.Sprintf("Unknown preset '%s', use one of: %s.", option.String(), strings.Join(audio.Presets(), ", ")))
+		}
+		if err != nil {
+			return err
+		}
+		chain = append(chain, preset...)
+		applied = append(applied, strings.ToLower(option.String()))
+	}
+	if option, ok := i.Data.Option("tempo"); ok {
+		tempo, err := audio.NewTempo(option.Float())
+		if err != nil {
+			return g.reply(i, fmt.Sprintf("Tempo must be between %g and %g.", audio.MinTempo, audio.MaxTempo))
+		}
+		chain = append(chain, tempo)
+		applied = append(applied, fmt.Sprintf("tempo %gx", tempo.Factor))
+	}
+	if option, ok := i.Data.Option("pitch"); ok {
+		pitch, err := audio.NewPitch(option.Float())
+		if err != nil {
+			return g.reply(i, fmt.Sprintf("Pitch must be between %g and %g.", audio.MinPitch, audio.MaxPitch))
+		}
+		chain = append(chain, pitch)
+		applied = append(applied, fmt.Sprintf("pitch %gx", pitch.Factor))
+	}
+	if len(applied) == 0 {
+		return g.reply(i, fmt.Sprintf("Choose a preset (%s), tempo or pitch.", strings.Join(audio.Presets(), ", ")))
+	}
+	v.SetFilters(chain)
+	if len(chain) == 0 {
+		return g.reply(i, "Filters cleared.")
+	}
+	return g.reply(i, fmt.Sprintf("Filters set: %s.", strings.Join(applied, ", ")))
+}
@@ -15,7 +15,17 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/hendrywilliam/siren/src/api"
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/audiosender"
+	"github.com/hendrywilliam/siren/src/dave"
+	"github.com/hendrywilliam/siren/src/library"
+	"github.com/hendrywilliam/siren/src/loudness"
+	"github.com/hendrywilliam/siren/src/metadata"
+	"github.com/hendrywilliam/siren/src/playlist"
+	"github.com/hendrywilliam/siren/src/storage"
 	"github.com/hendrywilliam/siren/src/structs"
+	"github.com/hendrywilliam/siren/src/transcache"
+	"github.com/hendrywilliam/siren/src/tts"
 	"github.com/hendrywilliam/siren/src/voice"
 	"github.com/hendrywilliam/siren/src/voicemanager"
 )
@@ -115,13 +125,37 @@ type Gateway struct {
 	clientID           string
 	discordHTTPBaseURL string
 
-	voiceManager voicemanager.VoiceManager
-	log          *slog.Logger
+	voiceManager  voicemanager.VoiceManager
+	log           *slog.Logger
+	commands      map[structs.Command]CommandHandler
+	autocompletes map[structs.Command]CommandHandler
+	prober        *metadata.Prober
+	library       *library.Library
+	playlistDir   string
+	playlists     *playlist.Manager
+	cache         *transcache.Cache
+	opus          audio.OpusOverrides
+	audioBuffer   audiosender.Buffer
+	daveEnabled   bool
+
+	guildsMu sync.Mutex
+	guilds   map[string]*guildInfo
+
+	recordingDir         string
+	recordingMaxDuration time.Duration
+	pendingMu            sync.Mutex
+	pendingRecordings    map[string]struct{}
+	voiceIdleTimeout     time.Duration
+	loudness             *loudness.Analyzer
+	sfxDir               string
+	speaker              *tts.Speaker
+
 	// APIs
 	rest        *api.REST
 	interaction *api.InteractionAPI
 	message     *api.MessageAPI
 	voice       *api.VoiceAPI
+	stage       *api.StageAPI
 }
 
 type DiscordArguments struct {
@@ -130,6 +164,36 @@ type DiscordArguments struct {
 	BotVersion uint
 	ClientID   string
 
+	RecordingDir         string
+	RecordingMaxDuration time.Duration
+	VoiceIdleTimeout     time.Duration
+
+	// Optional, normalizes tracks to LoudnessTarget.
+	Loudness       *loudness.Analyzer
+	LoudnessTarget float64
+
+	// Sound effects played by /sfx.
+	SFXDir string
+	// Optional, speaks /say and track announcements.
+	Speaker *tts.Speaker
+
+	// Local files playable by name.
+	Library *library.Library
+	// Defaults to a new prober.
+	Prober *metadata.Prober
+	// Playlist files loaded and saved by /playlist.
+	PlaylistDir string
+	// Saved playlists of /playlists.
+	Store storage.Store
+	// Optional, repeated plays stream from cached transcodes.
+	TranscodeCache *transcache.Cache
+	// Encoder settings fixed whatever the channel bitrate.
+	Opus audio.OpusOverrides
+	// Audio buffered ahead of the sender.
+	AudioBuffer audiosender.Buffer
+	// Experimental DAVE end-to-end encryption, off by default.
+	DAVE bool
+
 	Logger *slog.Logger
 }
 
@@ -157,8 +221,9 @@ func NewGateway(args DiscordArguments) *Gateway {
 	interactionAPI := api.NewInteractionAPI(restAPI)
 	messageAPI := api.NewMessageAPI(restAPI)
 	voiceAPI := api.NewVoiceAPI(restAPI)
+	stageAPI := api.NewStageAPI(restAPI)
 
-	return &Gateway{
+	g := &Gateway{
 		clientID:           args.ClientID,
 		wsDialer:           websocket.DefaultDialer,
 		wsurl:              wsBaseURL.String(),
@@ -167,13 +232,41 @@ func NewGateway(args DiscordArguments) *Gateway {
 		botVersion:         args.BotVersion,
 		status:             StatusDisconnected,
 		discordHTTPBaseURL: httpBaseURL.String(),
-		voiceManager:       voicemanager.NewVoiceManager(),
-		log:                args.Logger,
-		rest:               restAPI,
-		interaction:        interactionAPI,
-		message:            messageAPI,
-		voice:              voiceAPI,
+		voiceManager: voicemanager.NewVoiceManager(voicemanager.NewVoiceManagerArguments{
+			LoudnessTarget: args.LoudnessTarget,
+		}),
+		log:         args.Logger,
+		rest:        restAPI,
+		interaction: interactionAPI,
+		message:     messageAPI,
+		voice:       voiceAPI,
+		stage:       stageAPI,
+
+		recordingDir:         args.RecordingDir,
+		recordingMaxDuration: args.RecordingMaxDuration,
+		pendingRecordings:    make(map[string]struct{}),
+		voiceIdleTimeout:     args.VoiceIdleTimeout,
+		loudness:             args.Loudness,
+		sfxDir:               args.SFXDir,
+		speaker:              args.Speaker,
+		prober:               args.Prober,
+		library:              args.Library,
+		playlistDir:          args.PlaylistDir,
+		cache:                args.TranscodeCache,
+		opus:                 args.Opus,
+		audioBuffer:          args.AudioBuffer,
+		daveEnabled:          args.DAVE,
+		guilds:               make(map[string]*guildInfo),
+	}
+	if g.prober == nil {
+		g.prober = metadata.NewProber()
 	}
+	if args.Store != nil {
+		g.playlists = playlist.NewManager(args.Store)
+	}
+	g.registerCommands()
+	g.registerAutocompletes()
+	return g
 }
 
 func (g *Gateway) Open(ctx context.Context) error {
@@ -348,76 +441,101 @@ func (g *Gateway) onEvent(e structs.RawEvent) error {
 		if err != nil {
 			return err
 		}
-	case "INTERACTION_CREATE":
-		interactionEvent := structs.Interaction{}
-		if err := json.Unmarshal(e.D, &interactionEvent); err != nil {
-			return err
-		}
-		// g.log.Info("event", "interaction_create", interactionEvent)
-
-		// Check user voice state
-		userVoiceState, err := g.voice.GetUserVoiceState(g.ctx, interactionEvent.GuildID, interactionEvent.Member.User.ID)
-		if err != nil {
+	case "GUILD_CREATE", "GUILD_UPDATE", "GUILD_DELETE":
+		guild := structs.Guild{}
+		if err := json.Unmarshal(e.D, &guild); err != nil {
 			return err
 		}
-
-		// User hasn't joined to a voice channel.
-		if userVoiceState.SessionID == "" {
-			_, err := g.interaction.Reply(g.ctx, interactionEvent.ID, interactionEvent.Token, api.CreateInteractionResponseOptions{
-				InteractionResponse: &structs.InteractionResponse{
-					Type: structs.InteractionResponseTypeChannelMessageWithSource,
-					Data: structs.InteractionResponseDataMessage{
-						Content: fmt.Sprintf("%s, join to a voice channel first.", interactionEvent.Member.User.Mention()),
-					},
-				},
-			})
-			if err != nil {
-				return err
-			}
-			return nil
+		switch e.T {
+		case "GUILD_CREATE":
+			g.onGuildCreate(guild)
+		case "GUILD_UPDATE":
+			g.onGuildUpdate(guild)
+		default:
+			g.onGuildDelete(guild)
 		}
-		_, err = g.interaction.Reply(g.ctx, interactionEvent.ID, interactionEvent.Token, api.CreateInteractionResponseOptions{
-			InteractionResponse: &structs.InteractionResponse{
-				Type: structs.InteractionResponseTypeChannelMessageWithSource,
-				Data: structs.InteractionResponseDataMessage{
-					Content: fmt.Sprintf("Playing 'Sunflower' for %s", interactionEvent.Member.User.Mention()),
-				},
-			},
-			WithResponse: false,
-		})
-		if err != nil {
+	case "CHANNEL_CREATE", "CHANNEL_UPDATE", "CHANNEL_DELETE":
+		channel := structs.Channel{}
+		if err := json.Unmarshal(e.D, &channel); err != nil {
 			return err
 		}
-
-		// Send voice state update
-		voiceStateUpdate := &structs.Event{
-			Op: OpcodeVoiceStateUpdate,
-			D: &structs.VoiceStateUpdate{
-				GuildID:   userVoiceState.GuildID,
-				ChannelID: userVoiceState.ChannelID,
-				SelfMute:  userVoiceState.SelfMute,
-				SelfDeaf:  userVoiceState.SelfDeaf,
-			},
+		if e.T == "CHANNEL_DELETE" {
+			g.onChannelDelete(channel)
+		} else {
+			g.onChannelUpdate(channel)
 		}
-		// g.log.Info("event", "voice_state_update", voiceStateUpdate)
-		data, err := json.Marshal(voiceStateUpdate)
-		if err != nil {
+	case "INTERACTION_CREATE":
+		interactionEvent := structs.Interaction{}
+		if err := json.Unmarshal(e.D, &interactionEvent); err != nil {
 			return err
 		}
-		g.sendEvent(websocket.BinaryMessage, data)
+		return g.onInteraction(&interactionEvent)
 	case "VOICE_STATE_UPDATE":
 		voiceStateEvent := structs.VoiceState{}
 		if err := json.Unmarshal(e.D, &voiceStateEvent); err != nil {
 			return err
 		}
 		g.log.Info("event", "voice_state_update", voiceStateEvent)
+		if !g.isSelf(voiceStateEvent.UserID) {
+			return nil
+		}
+		// Left the voice channel.
+		if voiceStateEvent.ChannelID == "" {
+			if v := g.voiceManager.Get(voiceStateEvent.GuildID); v != nil {
+				v.Close()
+			}
+			g.voiceManager.Delete(voiceStateEvent.GuildID)
+			return nil
+		}
+		if voiceStateEvent.Suppress && g.isStageChannel(voiceStateEvent.GuildID, voiceStateEvent.ChannelID) {
+			// Only on joining, moderators moving the bot to the audience
+			// are not overridden.
+			if v := g.voiceManager.Get(voiceStateEvent.GuildID); v == nil || v.ChannelID != voiceStateEvent.ChannelID {
+				go g.takeStage(voiceStateEvent.GuildID, voiceStateEvent.ChannelID)
+			}
+		}
+		// Moved to another channel, the voice session carries on.
+		if v := g.voiceManager.Get(voiceStateEvent.GuildID); v != nil {
+			if v.ChannelID != voiceStateEvent.ChannelID {
+				v.ChannelID = voiceStateEvent.ChannelID
+				g.retune(voiceStateEvent.GuildID)
+			}
+			return nil
+		}
 		// Create new voice instance
+		guild := g.voiceManager.Guild(voiceStateEvent.GuildID)
+		// A nil MLS keeps the voice unencrypted.
+		var mls dave.MLS
+		if g.daveEnabled {
+			mls = dave.NewMLS()
+		}
 		newVoice := voice.NewVoice(voice.NewVoiceArguments{
-			SessionID:  voiceStateEvent.SessionID,
-			ServerID:   voiceStateEvent.GuildID,
-			BotVersion: g.botVersion,
-			UserID:     voiceStateEvent.UserID,
-			Log:        g.log,
+			SessionID: voiceStateEvent.SessionID,
+			ServerID:  voiceStateEvent.GuildID,
+			ChannelID: voiceStateEvent.ChannelID,
+			UserID:    voiceStateEvent.UserID,
+			Queue:     guild.Queue,
+			Recommend: g.recommend,
+			Volume:    guild.Volume,
+			Crossfade: guild.Crossfade,
+			Bitrate:   g.channelBitrate(voiceStateEvent.GuildID, voiceStateEvent.ChannelID),
+			Opus:      g.opus,
+			Buffer:    g.audioBuffer,
+			MLS:       mls,
+
+			Loudness:       g.loudness,
+			LoudnessTarget: guild.Loudness,
+			Cache:          g.cache,
+			Log:            g.log,
+
+			Speaker:       g.speaker,
+			Announcements: guild.Announcements,
+			Announcement:  announcement,
+
+			IdleTimeout: g.voiceIdleTimeout,
+			OnIdle: func() {
+				g.onVoiceIdle(voiceStateEvent.GuildID)
+			},
 		})
 		g.voiceManager.Add(voiceStateEvent.GuildID, newVoice)
 	case "VOICE_SERVER_UPDATE":
@@ -430,11 +548,23 @@ func (g *Gateway) onEvent(e structs.RawEvent) error {
 		voice.VoiceGatewayURL = voiceUpdateEvent.Endpoint
 		voice.Token = voiceUpdateEvent.Token
 		voice.Open(g.ctx)
+		g.startPendingRecording(voiceUpdateEvent.GuildID)
 	}
 
 	return nil
 }
 
+// Leave the voice channel nobody has been listening to.
+func (g *Gateway) onVoiceIdle(guildID string) {
+	if err := g.leaveVoiceChannel(guildID); err != nil {
+		g.log.Error(err.Error(), "guild_id", guildID)
+	}
+	if v := g.voiceManager.Get(guildID); v != nil {
+		v.Close()
+	}
+	g.voiceManager.Delete(guildID)
+}
+
 func (g *Gateway) reconnect() error {
 	var err error
 	rurl, err := url.Parse(g.resumeGatewayURL)
@@ -0,0 +1,315 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/api"
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/audiosender"
+	"github.com/hendrywilliam/siren/src/loudness"
+	"github.com/hendrywilliam/siren/src/queue"
+	"github.com/hendrywilliam/siren/src/structs"
+	"github.com/hendrywilliam/siren/src/voice"
+)
+
+var (
+	ErrInvalidPosition = errors.New("invalid position")
+)
+
+func (g *Gateway) onPauseCommand(i *structs.Interaction) error {
+	v := g.voiceManager.Get(i.GuildID)
+	if v == nil {
+		return g.reply(i, "Nothing is playing.")
+	}
+	err := v.Pause()
+	if errors.Is(err, voice.ErrNotPlaying) {
+		return g.reply(i, "Nothing is playing.")
+	}
+	if err != nil {
+		return err
+	}
+	return g.reply(i, fmt.Sprintf("Paused at %s.", formatPosition(v.Position())))
+}
+
+func (g *Gateway) onResumeCommand(i *structs.Interaction) error {
+	v := g.voiceManager.Get(i.GuildID)
+	if v == nil {
+		return g.reply(i, "Nothing is playing.")
+	}
+	err := v.Resume()
+	if errors.Is(err, voice.ErrNotPlaying) {
+		return g.reply(i, "Nothing is playing.")
+	}
+	if err != nil {
+		return err
+	}
+	return g.reply(i, "Resumed.")
+}
+
+func (g *Gateway) onStopCommand(i *structs.Interaction) error {
+	v := g.voiceManager.Get(i.GuildID)
+	if v == nil {
+		return g.reply(i, "Nothing is playing.")
+	}
+	err := v.Stop()
+	if errors.Is(err, voice.ErrNotPlaying) {
+		return g.reply(i, "Nothing is playing.")
+	}
+	if err != nil {
+		return err
+	}
+	return g.reply(i, "Stopped.")
+}
+
+func (g *Gateway) onSeekCommand(i *structs.Interaction) error {
+	v := g.voiceManager.Get(i.GuildID)
+	if v == nil {
+		return g.reply(i, "Nothing is playing.")
+	}
+	option, ok := i.Data.Option("position")
+	if !ok {
+		return g.reply(i, "Tell me where to seek, e.g. `1:30`.")
+	}
+	position, err := parsePosition(option.String())
+	if err != nil {
+		return g.reply(i, fmt.Sprintf("Invalid position '%s', use e.g. `90`, `1:30` or `1m30s`.", option.String()))
+	}
+	err = v.Seek(position)
+	if errors.Is(err, voice.ErrNotPlaying) {
+		return g.reply(i, "Nothing is playing.")
+	}
+	if err != nil {
+		return err
+	}
+	return g.reply(i, fmt.Sprintf("Seeked to %s.", formatPosition(position)))
+}
+
+func (g *Gateway) onNowPlayingCommand(i *structs.Interaction) error {
+	v := g.voiceManager.Get(i.GuildID)
+	if v == nil {
+		return g.reply(i, "Nothing is playing.")
+	}
+	track, ok := v.NowPlaying()
+	if !ok {
+		return g.reply(i, "Nothing is playing.")
+	}
+	state := "Now playing"
+	if v.IsPaused() {
+		state = "Paused"
+	}
+	m := track.Metadata
+	embed := structs.Embed{
+		Author: &structs.EmbedAuthor{Name: state},
+		Title:  track.Title,
+		Fields: []structs.EmbedField{
+			{Name: "Position", Value: formatProgress(v.Position(), m.Duration)},
+			{Name: "Requested by", Value: requester(track), Inline: true},
+		},
+	}
+	q := g.voiceManager.Queue(i.GuildID)
+	if loop := q.Loop(); loop != queue.LoopOff {
+		embed.Fields = append(embed.Fields, structs.EmbedField{Name: "Loop", Value: loop, Inline: true})
+	}
+	if q.Autoplay() {
+		embed.Fields = append(embed.Fields, structs.EmbedField{Name: "Autoplay", Value: "on", Inline: true})
+	}
+	if !v.IsPaused() {
+		embed.Fields = append(embed.Fields, structs.EmbedField{Name: "Buffer", Value: formatBuffer(v.BufferStats()), Inline: true})
+	}
+	if track.Source != nil {
+		if _, ok := track.Source.(*audio.HTTPSource); ok {
+			embed.URL = track.Source.String()
+		}
+	}
+	if m.Album != "" {
+		embed.Fields = append(embed.Fields, structs.EmbedField{Name: "Album", Value: m.Album, Inline: true})
+	}
+	if m.Bitrate > 0 {
+		embed.Footer = &structs.EmbedFooter{Text: fmt.Sprintf("%d kbps", m.Bitrate/1000)}
+	}
+	if !m.HasCover() || track.Source == nil {
+		return g.replyEmbed(i, embed)
+	}
+	ctx, cancel := context.WithTimeout(g.ctx, PROBE_TIMEOUT)
+	defer cancel()
+	cover, contentType, err := g.prober.Cover(ctx, track.Source)
+	if err != nil {
+		g.log.Debug("failed to extract cover art.", "source", track.Source.String(), "error", err.Error())
+		return g.replyEmbed(i, embed)
+	}
+	name := "cover.jpg"
+	if contentType == "image/png" {
+		name = "cover.png"
+	}
+	embed.Thumbnail = &structs.EmbedImage{URL: "attachment://" + name}
+	return g.replyEmbed(i, embed, api.File{Name: name, ContentType: contentType, Data: cover})
+}
+
+// Position with a progress bar when the duration is known.
+func formatProgress(position, duration time.Duration) string {
+	if duration <= 0 {
+		return formatPosition(position)
+	}
+	const width = 20
+	filled := min(int(float64(width)*position.Seconds()/duration.Seconds()), width)
+	bar := strings.Repeat("▬", filled) + "🔘" + strings.Repeat("▬", width-filled)
+	return fmt.Sprintf("%s\n%s / %s", bar, formatPosition(position), formatPosition(duration))
+}
+
+// Volume is kept per guild, without a level the current one is shown.
+func (g *Gateway) onVolumeCommand(i *structs.Interaction) error {
+	volume := g.voiceManager.Guild(i.GuildID).Volume
+	option, ok := i.Data.Option("level")
+	if !ok {
+		return g.reply(i, fmt.Sprintf("Volume is %d%%.", volume.Percent()))
+	}
+	level := option.Int()
+	if level < 0 || level > int64(audio.MaxVolume) {
+		return g.reply(i, fmt.Sprintf("Volume must be between 0 and %d.", audio.MaxVolume))
+	}
+	volume.Set(uint32(level))
+	return g.reply(i, fmt.Sprintf("Volume set to %d%%.", level))
+}
+
+// Target loudness is kept per guild, the current track restarts to apply it.
+func (g *Gateway) onNormalizeCommand(i *structs.Interaction) error {
+	target := g.voiceManager.Guild(i.GuildID).Loudness
+	var reply string
+	if option, ok := i.Data.Option("enabled"); ok && !option.Bool() {
+		target.Disable()
+		reply = "Loudness normalization disabled."
+	} else if option, ok := i.Data.Option("target"); ok {
+		lufs := option.Float()
+		if lufs < loudness.MinTarget || lufs > loudness.MaxTarget {
+			return g.reply(i, fmt.Sprintf("Target must be between %g and %g LUFS.", loudness.MinTarget, loudness.MaxTarget))
+		}
+		target.Set(lufs)
+		reply = fmt.Sprintf("Normalizing to %g LUFS.", lufs)
+	} else if lufs, ok := target.Get(); ok {
+		return g.reply(i, fmt.Sprintf("Normalizing to %g LUFS.", lufs))
+	} else {
+		return g.reply(i, "Loudness normalization is disabled.")
+	}
+	if v := g.voiceManager.Get(i.GuildID); v != nil {
+		if err := v.Seek(v.Position()); err != nil && !errors.Is(err, voice.ErrNotPlaying) {
+			return err
+		}
+	}
+	return g.reply(i, reply)
+}
+
+// Crossfade applies to tracks started from now on, 0 seconds plays gapless.
+func (g *Gateway) onCrossfadeCommand(i *structs.Interaction) error {
+	crossfade := g.voiceManager.Guild(i.GuildID).Crossfade
+	if option, ok := i.Data.Option("enabled"); ok && !option.Bool() {
+		crossfade.Disable()
+		return g.reply(i, "Crossfade disabled.")
+	}
+	option, ok := i.Data.Option("seconds")
+	if !ok {
+		if d, ok := crossfade.Get(); ok {
+			return g.reply(i, fmt.Sprintf("Crossfade is %s.", d))
+		}
+		return g.reply(i, "Crossfade is disabled.")
+	}
+	seconds := option.Float()
+	if seconds < 0 || seconds > audio.MaxCrossfade.Seconds() {
+		return g.reply(i, fmt.Sprintf("Crossfade must be between 0 and %g seconds.", audio.MaxCrossfade.Seconds()))
+	}
+	crossfade.Set(time.Duration(seconds * float64(time.Second)))
+	if seconds == 0 {
+		return g.reply(i, "Gapless playback enabled.")
+	}
+	return g.reply(i, fmt.Sprintf("Crossfade set to %gs.", seconds))
+}
+
+// Accepts seconds, [h:]m:ss or Go duration.
+func parsePosition(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, ErrInvalidPosition
+	}
+	var d time.Duration
+	for _, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return 0, ErrInvalidPosition
+		}
+		d = d*60 + time.Duration(n)*time.Second
+	}
+	return d, nil
+}
+
+// Fill of the send buffer, a low low water or underruns show the encoder
+// falling behind.
+func formatBuffer(stats audiosender.Stats) string {
+	text := fmt.Sprintf("%s of %s, low %s", stats.Buffered.Round(10*time.Millisecond),
+		stats.Capacity.Round(10*time.Millisecond), stats.LowWater.Round(10*time.Millisecond))
+	if stats.Underruns > 0 {
+		text += fmt.Sprintf(", ran dry %d times", stats.Underruns)
+	}
+	return text
+}
+
+func formatPosition(d time.Duration) string {
+	d = d.Truncate(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+func (g *Gateway) onLoopCommand(i *structs.Interaction) error {
+	q := g.voiceManager.Queue(i.GuildID)
+	option, ok := i.Data.Option("mode")
+	if !ok {
+		return g.reply(i, fmt.Sprintf("Loop is %s.", q.Loop()))
+	}
+	err := q.SetLoop(strings.ToLower(option.String()))
+	if errors.Is(err, queue.ErrUnknownLoop) {
+		return g.reply(i, fmt.Sprintf("Unknown loop mode '%s', use off, track or queue.", option.String()))
+	}
+	if err != nil {
+		return err
+	}
+	switch q.Loop() {
+	case queue.LoopTrack:
+		return g.reply(i, "Looping the current track.")
+	case queue.LoopQueue:
+		return g.reply(i, "Looping the queue.")
+	default:
+		return g.reply(i, "Loop disabled.")
+	}
+}
+
+func (g *Gateway) onAutoplayCommand(i *structs.Interaction) error {
+	q := g.voiceManager.Queue(i.GuildID)
+	option, ok := i.Data.Option("enabled")
+	if !ok {
+		if q.Autoplay() {
+			return g.reply(i, "Autoplay is on.")
+		}
+		return g.reply(i, "Autoplay is off.")
+	}
+	q.SetAutoplay(option.Bool())
+	if !option.Bool() {
+		return g.reply(i, "Autoplay disabled.")
+	}
+	if g.library == nil || g.library.Len() == 0 {
+		return g.reply(i, "Autoplay enabled, but the library is empty.")
+	}
+	return g.reply(i, "Autoplay enabled, related tracks play once the queue runs out.")
+}
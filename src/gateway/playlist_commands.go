@@ -0,0 +1,265 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/playlist"
+	"github.com/hendrywilliam/siren/src/queue"
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+var (
+	ErrPlaylistName = errors.New("invalid playlist name")
+)
+
+// Tried in order when loading a playlist named without extension.
+var PLAYLIST_EXTENSIONS = []string{".m3u8", ".m3u", ".pls", ".xspf"}
+
+func (g *Gateway) onPlaylistCommand(i *structs.Interaction) error {
+	sub, ok := i.Data.SubCommand()
+	if !ok {
+		return g.reply(i, "Use `/playlist load` or `/playlist save`.")
+	}
+	name, ok := sub.Option("name")
+	if !ok || name.String() == "" {
+		return g.reply(i, "Tell me the playlist name.")
+	}
+	switch sub.Name {
+	case "load":
+		return g.onPlaylistLoad(i, name.String())
+	case "save":
+		return g.onPlaylistSave(i, name.String())
+	default:
+		return g.reply(i, "Use `/playlist load` or `/playlist save`.")
+	}
+}
+
+// Enqueues every entry found in the library or playable as url.
+func (g *Gateway) onPlaylistLoad(i *structs.Interaction, name string) error {
+	userVoiceState, err := g.callerVoiceState(i)
+	if err != nil {
+		return err
+	}
+	if userVoiceState == nil {
+		return g.reply(i, fmt.Sprintf("%s, join to a voice channel first.", i.Member.User.Mention()))
+	}
+	path, err := g.playlistPath(name, true)
+	if errors.Is(err, ErrPlaylistName) || errors.Is(err, playlist.ErrUnknownFormat) || errors.Is(err, os.ErrNotExist) {
+		return g.reply(i, fmt.Sprintf("No playlist named '%s'.", name))
+	}
+	if err != nil {
+		return err
+	}
+	p, err := playlist.Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Removed since it was found.
+		return g.reply(i, fmt.Sprintf("No playlist named '%s'.", name))
+	}
+	if errors.Is(err, playlist.ErrInvalid) {
+		return g.reply(i, fmt.Sprintf("Playlist '%s' could not be read.", name))
+	}
+	if err != nil {
+		return err
+	}
+	return g.enqueueEntries(i, userVoiceState, name, filepath.Dir(path), p.Entries)
+}
+
+// enqueueEntries queues the playable entries of a playlist and starts
+// playing if idle.
+func (g *Gateway) enqueueEntries(i *structs.Interaction, userVoiceState *structs.VoiceState, name, dir string, entries []playlist.Entry) error {
+	tracks := []queue.Track{}
+	for _, e := range entries {
+		track, ok := g.playlistTrack(dir, e)
+		if !ok {
+			continue
+		}
+		track.RequestedBy = i.Member.User.ID
+		track.RequesterName = i.Member.DisplayName()
+		track.AddedAt = time.Now()
+		tracks = append(tracks, track)
+	}
+	if len(tracks) == 0 {
+		return g.reply(i, fmt.Sprintf("Nothing in '%s' could be played.", name))
+	}
+	g.voiceManager.Queue(i.GuildID).Enqueue(tracks...)
+
+	msg := fmt.Sprintf("Queued %d tracks from '%s' for %s", len(tracks), name, i.Member.User.Mention())
+	if skipped := len(entries) - len(tracks); skipped > 0 {
+		msg += fmt.Sprintf(", skipped %d not in the library or on a disallowed site", skipped)
+	}
+	if v := g.voiceManager.Get(i.GuildID); v != nil && v.IsPlaying() {
+		return g.reply(i, msg)
+	}
+	if err := g.reply(i, msg); err != nil {
+		return err
+	}
+	return g.startQueue(i.GuildID, userVoiceState)
+}
+
+// Local entries must be indexed in the library, relative ones are relative
+// to the playlist file. Urls must be on an allowed host.
+func (g *Gateway) playlistTrack(dir string, e playlist.Entry) (queue.Track, bool) {
+	if e.IsURL() {
+		if audio.CheckURL(e.Location) != nil {
+			return queue.Track{}, false
+		}
+		title := e.Title
+		if title == "" {
+			title = e.Location
+		}
+		track := queue.Track{Name: e.Location, Title: title, Source: audio.NewHTTPSource(e.Location)}
+		track.Metadata.Duration = e.Duration
+		return track, true
+	}
+	if g.library == nil {
+		return queue.Track{}, false
+	}
+	path := e.Location
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	entry, ok := g.library.Lookup(path)
+	if !ok {
+		return queue.Track{}, false
+	}
+	track := libraryTrack(entry)
+	// Prefer the playlist title over the file name.
+	if entry.Metadata.Title == "" && e.Title != "" {
+		track.Title = e.Title
+	}
+	return track, true
+}
+
+// Exports the current track and the queue.
+func (g *Gateway) onPlaylistSave(i *structs.Interaction, name string) error {
+	path, err := g.playlistPath(name, false)
+	if errors.Is(err, ErrPlaylistName) || errors.Is(err, playlist.ErrUnknownFormat) {
+		return g.reply(i, fmt.Sprintf("Invalid playlist name '%s', use a file name ending with one of %s.", name, strings.Join(PLAYLIST_EXTENSIONS, ", ")))
+	}
+	if err != nil {
+		return err
+	}
+	q := g.voiceManager.Queue(i.GuildID)
+	tracks := q.Tracks()
+	if current, ok := q.Current(); ok {
+		tracks = append([]queue.Track{current}, tracks...)
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	p := &playlist.Playlist{Title: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	for _, track := range tracks {
+		location := trackLocation(dir, track)
+		if location == "" {
+			// Streams produced in process can not be saved.
+			continue
+		}
+		p.Entries = append(p.Entries, playlist.Entry{
+			Location: location,
+			Title:    track.Title,
+			Duration: track.Metadata.Duration,
+		})
+	}
+	if len(p.Entries) == 0 {
+		return g.reply(i, "Queue is empty.")
+	}
+	if err := os.MkdirAll(g.playlistDir, 0o755); err != nil {
+		return err
+	}
+	if err := playlist.Save(path, p); err != nil {
+		return err
+	}
+	return g.reply(i, fmt.Sprintf("Saved %d tracks to '%s'.", len(p.Entries), filepath.Base(path)))
+}
+
+// Files under dir are written relative to it so playlists move with the
+// media, absolute without dir. Empty if the track has no location.
+func trackLocation(dir string, track queue.Track) string {
+	source := track.Source
+	if source == nil {
+		source = audio.NewMediaSource(track.Name)
+	}
+	switch source := source.(type) {
+	case *audio.HTTPSource:
+		return source.URL
+	case audio.PathSource:
+		path, err := filepath.Abs(source.Path())
+		if err != nil {
+			return ""
+		}
+		if dir == "" {
+			return path
+		}
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+		return path
+	default:
+		return ""
+	}
+}
+
+// playlistPath maps a name to a file directly in the playlist directory.
+// Names without extension are looked up by PLAYLIST_EXTENSIONS when
+// existing, or saved as the first one.
+func (g *Gateway) playlistPath(name string, existing bool) (string, error) {
+	if name != filepath.Base(name) || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", ErrPlaylistName
+	}
+	if filepath.Ext(name) != "" {
+		if _, err := playlist.FormatOf(name); err != nil {
+			return "", err
+		}
+		return filepath.Join(g.playlistDir, name), nil
+	}
+	if !existing {
+		return filepath.Join(g.playlistDir, name+PLAYLIST_EXTENSIONS[0]), nil
+	}
+	for _, ext := range PLAYLIST_EXTENSIONS {
+		path := filepath.Join(g.playlistDir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// Autocomplete suggests playlist files matching the query.
+func (g *Gateway) onPlaylistAutocomplete(i *structs.Interaction) error {
+	choices := []structs.ApplicationCommandOptionChoice{}
+	focused, ok := i.Data.Focused()
+	if !ok {
+		return g.autocomplete(i, choices)
+	}
+	entries, err := os.ReadDir(g.playlistDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	query := strings.ToLower(focused.String())
+	for _, e := range entries {
+		if len(choices) == structs.MaxAutocompleteChoices {
+			break
+		}
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if _, err := playlist.FormatOf(e.Name()); err != nil {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(e.Name()), query) {
+			continue
+		}
+		choices = append(choices, structs.ApplicationCommandOptionChoice{
+			Name:  truncate(e.Name(), MAX_CHOICE_NAME),
+			Value: e.Name(),
+		})
+	}
+	return g.autocomplete(i, choices)
+}
@@ -0,0 +1,284 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/library"
+	"github.com/hendrywilliam/siren/src/playlist"
+	"github.com/hendrywilliam/siren/src/queue"
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+// Entries shown by /playlists show.
+var MAX_PLAYLIST_LINES = 15
+
+// onPlaylistsCommand manages saved playlists. Subcommands work on the
+// caller's playlists, or the guild's with the guild option, which requires
+// Manage Server to change.
+func (g *Gateway) onPlaylistsCommand(i *structs.Interaction) error {
+	if g.playlists == nil {
+		return g.reply(i, "Saved playlists are not available.")
+	}
+	sub, ok := i.Data.SubCommand()
+	if !ok {
+		return g.reply(i, "Use `/playlists list` to see your playlists.")
+	}
+	var name string
+	if option, ok := sub.Option("name"); ok {
+		name = option.String()
+	}
+	owner := playlist.UserOwner(i.Member.User.ID)
+	if option, ok := sub.Option("guild"); ok && option.Bool() {
+		owner = playlist.GuildOwner(i.GuildID)
+	}
+	switch sub.Name {
+	case "list":
+		return g.onPlaylistsList(i)
+	case "show":
+		return g.onPlaylistsShow(i, owner, name)
+	case "play":
+		return g.onPlaylistsPlay(i, name)
+	}
+	if owner.Kind == playlist.OwnerGuild && !i.Member.HasPermission(structs.PermissionManageGuild) {
+		return g.reply(i, "Changing server playlists requires the Manage Server permission.")
+	}
+	var err error
+	var msg string
+	switch sub.Name {
+	case "create":
+		_, err = g.playlists.Create(owner, name, i.Member.User.ID)
+		msg = fmt.Sprintf("Created playlist '%s'.", name)
+	case "delete":
+		err = g.playlists.Delete(owner, name)
+		msg = fmt.Sprintf("Deleted playlist '%s'.", name)
+	case "rename":
+		newName, ok := sub.Option("new_name")
+		if !ok {
+			return g.reply(i, "Tell me the new name.")
+		}
+		var s *playlist.Saved
+		s, err = g.playlists.Rename(owner, name, newName.String())
+		if err == nil {
+			msg = fmt.Sprintf("Renamed playlist '%s' to '%s'.", name, s.Name)
+		}
+	case "add":
+		return g.onPlaylistsAdd(i, sub, owner, name)
+	case "remove":
+		position, ok := sub.Option("position")
+		if !ok {
+			return g.reply(i, "Tell me which position to remove.")
+		}
+		var removed playlist.Entry
+		// Positions shown to users are one based.
+		removed, err = g.playlists.Remove(owner, name, int(position.Int())-1)
+		msg = fmt.Sprintf("Removed '%s' from '%s'.", entryTitle(removed), name)
+	case "move":
+		from, ok := sub.Option("from")
+		to, ok2 := sub.Option("to")
+		if !ok || !ok2 {
+			return g.reply(i, "Tell me which track to move and where.")
+		}
+		_, err = g.playlists.Move(owner, name, int(from.Int())-1, int(to.Int())-1)
+		msg = fmt.Sprintf("Moved track %d of '%s' to position %d.", from.Int(), name, to.Int())
+	case "share", "unshare":
+		shared := sub.Name == "share"
+		_, err = g.playlists.Share(owner, name, i.GuildID, shared)
+		msg = fmt.Sprintf("Playlist '%s' is no longer shared with this server.", name)
+		if shared {
+			msg = fmt.Sprintf("Playlist '%s' is now playable by everyone in this server.", name)
+		}
+	default:
+		return g.reply(i, "Use `/playlists list` to see your playlists.")
+	}
+	if reply, ok := playlistsErrorReply(err, name); ok {
+		return g.reply(i, reply)
+	}
+	if err != nil {
+		return err
+	}
+	return g.reply(i, msg)
+}
+
+// Replies for errors caused by the user input.
+func playlistsErrorReply(err error, name string) (string, bool) {
+	switch {
+	case errors.Is(err, playlist.ErrNotFound):
+		return fmt.Sprintf("No playlist named '%s'.", name), true
+	case errors.Is(err, playlist.ErrExists):
+		return "A playlist with that name already exists.", true
+	case errors.Is(err, playlist.ErrName):
+		return fmt.Sprintf("Playlist names must be 1 to %d characters.", playlist.MAX_NAME_LENGTH), true
+	case errors.Is(err, playlist.ErrFull):
+		return fmt.Sprintf("Playlists hold at most %d tracks.", playlist.MAX_ENTRIES), true
+	case errors.Is(err, playlist.ErrOutOfRange):
+		return "There is no track at that position.", true
+	case errors.Is(err, playlist.ErrNotShareable):
+		return "Only your own playlists can be shared.", true
+	default:
+		return "", false
+	}
+}
+
+// Adds the queried track, or the current one without query.
+func (g *Gateway) onPlaylistsAdd(i *structs.Interaction, sub *structs.InteractionApplicationCommandDataOption, owner playlist.Owner, name string) error {
+	var track queue.Track
+	if query, ok := sub.Option("query"); ok && query.String() != "" {
+		var err error
+		track, err = g.resolveTrack(query.String())
+		if errors.Is(err, library.ErrNotFound) {
+			return g.reply(i, fmt.Sprintf("Nothing in the library matches '%s'.", query.String()))
+		}
+		if errors.Is(err, audio.ErrHostNotAllowed) {
+			return g.reply(i, "I can't play urls from that site.")
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		current, ok := g.voiceManager.Queue(i.GuildID).Current()
+		if !ok {
+			return g.reply(i, "Nothing is playing, tell me what to add.")
+		}
+		track = current
+	}
+	location := trackLocation("", track)
+	if location == "" {
+		return g.reply(i, fmt.Sprintf("'%s' can not be saved.", track.Title))
+	}
+	s, err := g.playlists.Add(owner, name, playlist.Entry{
+		Location: location,
+		Title:    track.Title,
+		Duration: track.Metadata.Duration,
+	})
+	if reply, ok := playlistsErrorReply(err, name); ok {
+		return g.reply(i, reply)
+	}
+	if err != nil {
+		return err
+	}
+	return g.reply(i, fmt.Sprintf("Added '%s' to '%s' at position %d.", track.Title, s.Name, len(s.Entries)))
+}
+
+func (g *Gateway) onPlaylistsList(i *structs.Interaction) error {
+	var b strings.Builder
+	sections := []struct {
+		title string
+		list  func() ([]*playlist.Saved, error)
+	}{
+		{"Your playlists", func() ([]*playlist.Saved, error) {
+			return g.playlists.List(playlist.UserOwner(i.Member.User.ID))
+		}},
+		{"Server playlists", func() ([]*playlist.Saved, error) {
+			return g.playlists.List(playlist.GuildOwner(i.GuildID))
+		}},
+		{"Shared with this server", func() ([]*playlist.Saved, error) {
+			return g.playlists.Shared(i.GuildID)
+		}},
+	}
+	for _, section := range sections {
+		saved, err := section.list()
+		if err != nil {
+			return err
+		}
+		if len(saved) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:\n", section.title)
+		for _, s := range saved {
+			fmt.Fprintf(&b, "- '%s' (%d tracks)", s.Name, len(s.Entries))
+			if s.Owner.Kind == playlist.OwnerUser && s.Owner.ID != i.Member.User.ID {
+				fmt.Fprintf(&b, " by <@%s>", s.Owner.ID)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if b.Len() == 0 {
+		return g.reply(i, "No playlists yet, create one with `/playlists create`.")
+	}
+	return g.reply(i, b.String())
+}
+
+func (g *Gateway) onPlaylistsShow(i *structs.Interaction, owner playlist.Owner, name string) error {
+	s, err := g.playlists.Get(owner, name)
+	if errors.Is(err, playlist.ErrNotFound) && owner.Kind == playlist.OwnerUser {
+		s, err = g.playlists.Find(i.Member.User.ID, i.GuildID, name)
+	}
+	if reply, ok := playlistsErrorReply(err, name); ok {
+		return g.reply(i, reply)
+	}
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Playlist '%s', %d tracks:\n", s.Name, len(s.Entries))
+	for n, e := range s.Entries {
+		if n == MAX_PLAYLIST_LINES {
+			fmt.Fprintf(&b, "...and %d more.", len(s.Entries)-n)
+			break
+		}
+		fmt.Fprintf(&b, "%d. '%s'%s\n", n+1, entryTitle(e), formatDuration(e.Duration))
+	}
+	return g.reply(i, b.String())
+}
+
+// Enqueues a playlist the caller can see, own playlists first.
+func (g *Gateway) onPlaylistsPlay(i *structs.Interaction, name string) error {
+	userVoiceState, err := g.callerVoiceState(i)
+	if err != nil {
+		return err
+	}
+	if userVoiceState == nil {
+		return g.reply(i, fmt.Sprintf("%s, join to a voice channel first.", i.Member.User.Mention()))
+	}
+	s, err := g.playlists.Find(i.Member.User.ID, i.GuildID, name)
+	if reply, ok := playlistsErrorReply(err, name); ok {
+		return g.reply(i, reply)
+	}
+	if err != nil {
+		return err
+	}
+	// Saved entries are absolute paths or urls.
+	return g.enqueueEntries(i, userVoiceState, s.Name, "", s.Entries)
+}
+
+// Autocomplete suggests playlists the caller can see.
+func (g *Gateway) onPlaylistsAutocomplete(i *structs.Interaction) error {
+	choices := []structs.ApplicationCommandOptionChoice{}
+	focused, ok := i.Data.Focused()
+	if !ok || focused.Name != "name" || g.playlists == nil {
+		return g.autocomplete(i, choices)
+	}
+	query := strings.ToLower(focused.String())
+	seen := map[string]struct{}{}
+	for _, owner := range []playlist.Owner{playlist.UserOwner(i.Member.User.ID), playlist.GuildOwner(i.GuildID)} {
+		saved, err := g.playlists.List(owner)
+		if err != nil {
+			return err
+		}
+		for _, s := range saved {
+			key := strings.ToLower(s.Name)
+			if _, ok := seen[key]; ok || !strings.Contains(key, query) {
+				continue
+			}
+			if len(choices) == structs.MaxAutocompleteChoices {
+				return g.autocomplete(i, choices)
+			}
+			seen[key] = struct{}{}
+			choices = append(choices, structs.ApplicationCommandOptionChoice{
+				Name:  truncate(s.Name, MAX_CHOICE_NAME),
+				Value: s.Name,
+			})
+		}
+	}
+	return g.autocomplete(i, choices)
+}
+
+func entryTitle(e playlist.Entry) string {
+	if e.Title != "" {
+		return e.Title
+	}
+	return e.Location
+}
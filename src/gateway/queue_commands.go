@@ -0,0 +1,239 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/library"
+	"github.com/hendrywilliam/siren/src/metadata"
+	"github.com/hendrywilliam/siren/src/queue"
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+// Upcoming tracks shown by /queue.
+var MAX_QUEUE_LINES = 10
+
+// Probing runs within the interaction response deadline.
+var PROBE_TIMEOUT = 1500 * time.Millisecond
+
+// Recent tracks autoplay avoids repeating.
+var AUTOPLAY_HISTORY = 20
+
+// Discord limit of choice names.
+var MAX_CHOICE_NAME = 100
+
+func (g *Gateway) onPlayCommand(i *structs.Interaction) error {
+	userVoiceState, err := g.callerVoiceState(i)
+	if err != nil {
+		return err
+	}
+	// User hasn't joined to a voice channel.
+	if userVoiceState == nil {
+		return g.reply(i, fmt.Sprintf("%s, join to a voice channel first.", i.Member.User.Mention()))
+	}
+	query, ok := i.Data.Option("query")
+	if !ok || query.String() == "" {
+		return g.reply(i, "Tell me what to play.")
+	}
+	track, err := g.resolveTrack(query.String())
+	if errors.Is(err, library.ErrNotFound) {
+		return g.reply(i, fmt.Sprintf("Nothing in the library matches '%s'.", query.String()))
+	}
+	if errors.Is(err, audio.ErrHostNotAllowed) {
+		return g.reply(i, "I can't play urls from that site.")
+	}
+	if err != nil {
+		return err
+	}
+	track.RequestedBy = i.Member.User.ID
+	track.RequesterName = i.Member.DisplayName()
+	track.AddedAt = time.Now()
+	q := g.voiceManager.Queue(i.GuildID)
+	q.Enqueue(track)
+
+	if v := g.voiceManager.Get(i.GuildID); v != nil && v.IsPlaying() {
+		return g.reply(i, fmt.Sprintf("Queued '%s' at position %d for %s", track.Title, q.Len(), i.Member.User.Mention()))
+	}
+	err = g.reply(i, fmt.Sprintf("Playing '%s' for %s", track.Title, i.Member.User.Mention()))
+	if err != nil {
+		return err
+	}
+	return g.startQueue(i.GuildID, userVoiceState)
+}
+
+// startQueue plays the queue, joining the caller's channel if not connected.
+func (g *Gateway) startQueue(guildID string, userVoiceState *structs.VoiceState) error {
+	if v := g.voiceManager.Get(guildID); v != nil && v.IsReady() {
+		v.Play()
+		return nil
+	}
+	return g.joinVoiceChannel(userVoiceState)
+}
+
+// Metadata of the source, empty if probing fails or takes too long.
+func (g *Gateway) probe(source audio.AudioSource) metadata.Metadata {
+	ctx, cancel := context.WithTimeout(g.ctx, PROBE_TIMEOUT)
+	defer cancel()
+	m, err := g.prober.Probe(ctx, source)
+	if err != nil {
+		g.log.Debug("failed to probe source.", "source", source.String(), "error", err.Error())
+	}
+	return m
+}
+
+// Shown after titles, empty if unknown.
+func formatDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", formatPosition(d))
+}
+
+// Autocomplete suggests library entries matching the query.
+func (g *Gateway) onPlayAutocomplete(i *structs.Interaction) error {
+	choices := []structs.ApplicationCommandOptionChoice{}
+	focused, ok := i.Data.Focused()
+	if !ok || g.library == nil {
+		return g.autocomplete(i, choices)
+	}
+	for _, e := range g.library.Search(focused.String(), structs.MaxAutocompleteChoices) {
+		choices = append(choices, structs.ApplicationCommandOptionChoice{
+			Name:  truncate(e.Title(), MAX_CHOICE_NAME),
+			Value: e.Name,
+		})
+	}
+	return g.autocomplete(i, choices)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// resolveTrack plays URLs on allowed hosts as is, anything else must
+// resolve to an indexed library entry.
+func (g *Gateway) resolveTrack(query string) (queue.Track, error) {
+	if strings.HasPrefix(query, "http://") || strings.HasPrefix(query, "https://") {
+		if err := audio.CheckURL(query); err != nil {
+			return queue.Track{}, err
+		}
+		track := queue.Track{Name: query, Source: audio.NewHTTPSource(query)}
+		track.Metadata = g.probe(track.Source)
+		track.Title = track.Metadata.DisplayTitle(query)
+		return track, nil
+	}
+	if g.library == nil {
+		return queue.Track{}, library.ErrNotFound
+	}
+	entry, err := g.library.Resolve(query)
+	if err != nil {
+		return queue.Track{}, err
+	}
+	return libraryTrack(entry), nil
+}
+
+// Mention of the user who queued the track.
+func requester(track queue.Track) string {
+	if track.Autoplay {
+		return "autoplay"
+	}
+	return fmt.Sprintf("<@%s>", track.RequestedBy)
+}
+
+// recommend picks a library track related to the last one played, avoiding
+// the ones played recently.
+func (g *Gateway) recommend(history []queue.Track) (queue.Track, bool) {
+	if g.library == nil {
+		return queue.Track{}, false
+	}
+	history = history[max(len(history)-AUTOPLAY_HISTORY, 0):]
+	exclude := map[string]struct{}{}
+	var seed library.Entry
+	for _, track := range history {
+		ps, ok := track.Source.(audio.PathSource)
+		if !ok {
+			continue
+		}
+		if entry, ok := g.library.Lookup(ps.Path()); ok {
+			exclude[entry.Path] = struct{}{}
+			seed = entry
+		}
+	}
+	entry, ok := g.library.Related(seed, exclude)
+	if !ok {
+		// Small library, allow repeats rather than stopping.
+		entry, ok = g.library.Related(seed, nil)
+	}
+	if !ok {
+		return queue.Track{}, false
+	}
+	return libraryTrack(entry), true
+}
+
+func libraryTrack(entry library.Entry) queue.Track {
+	return queue.Track{
+		Name:     entry.Name,
+		Title:    entry.Title(),
+		Source:   entry.Source(),
+		Metadata: entry.Metadata,
+	}
+}
+
+func (g *Gateway) onQueueCommand(i *structs.Interaction) error {
+	q := g.voiceManager.Queue(i.GuildID)
+	var b strings.Builder
+	if current, ok := q.Current(); ok {
+		fmt.Fprintf(&b, "Now playing: '%s'%s requested by %s\n", current.Title, formatDuration(current.Metadata.Duration), requester(current))
+	}
+	tracks := q.Tracks()
+	if len(tracks) == 0 {
+		b.WriteString("Queue is empty.")
+		return g.reply(i, b.String())
+	}
+	for n, track := range tracks {
+		if n == MAX_QUEUE_LINES {
+			fmt.Fprintf(&b, "...and %d more.", len(tracks)-n)
+			break
+		}
+		fmt.Fprintf(&b, "%d. '%s'%s requested by %s\n", n+1, track.Title, formatDuration(track.Metadata.Duration), requester(track))
+	}
+	return g.reply(i, b.String())
+}
+
+func (g *Gateway) onSkipCommand(i *structs.Interaction) error {
+	v := g.voiceManager.Get(i.GuildID)
+	if v == nil || !v.IsPlaying() {
+		return g.reply(i, "Nothing is playing.")
+	}
+	current, _ := v.NowPlaying()
+	v.Skip()
+	return g.reply(i, fmt.Sprintf("Skipped '%s'.", current.Title))
+}
+
+func (g *Gateway) onRemoveCommand(i *structs.Interaction) error {
+	position, ok := i.Data.Option("position")
+	if !ok {
+		return g.reply(i, "Tell me which position to remove.")
+	}
+	// Positions shown to users are one based.
+	removed, err := g.voiceManager.Queue(i.GuildID).Remove(int(position.Int()) - 1)
+	if errors.Is(err, queue.ErrOutOfRange) {
+		return g.reply(i, fmt.Sprintf("There is no track at position %d.", position.Int()))
+	}
+	if err != nil {
+		return err
+	}
+	return g.reply(i, fmt.Sprintf("Removed '%s'.", removed.Title))
+}
+
+func (g *Gateway) onClearCommand(i *structs.Interaction) error {
+	g.voiceManager.Queue(i.GuildID).Clear()
+	return g.reply(i, "Queue cleared.")
+}
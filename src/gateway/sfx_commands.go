@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/structs"
+	"github.com/hendrywilliam/siren/src/voice"
+)
+
+var (
+	ErrUnknownSFX = errors.New("unknown sound effect")
+)
+
+func (g *Gateway) onSFXCommand(i *structs.Interaction) error {
+	option, ok := i.Data.Option("name")
+	if !ok || option.String() == "" {
+		names, err := g.sfxNames()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return g.reply(i, "No sound effects available.")
+		}
+		return g.reply(i, fmt.Sprintf("Sound effects: %s.", strings.Join(names, ", ")))
+	}
+	v := g.voiceManager.Get(i.GuildID)
+	if v == nil {
+		return g.reply(i, "Not connected to a voice channel.")
+	}
+	path, err := g.resolveSFX(option.String())
+	if errors.Is(err, ErrUnknownSFX) {
+		return g.reply(i, fmt.Sprintf("Unknown sound effect '%s'.", option.String()))
+	}
+	if err != nil {
+		return err
+	}
+	err = v.PlayOverlay(audio.NewFileSource(path))
+	if errors.Is(err, audio.ErrTooManyOverlays) {
+		return g.reply(i, "Too many sound effects playing.")
+	}
+	if errors.Is(err, voice.ErrVoiceNotReady) {
+		return g.reply(i, "Voice connection is not ready yet.")
+	}
+	if err != nil {
+		return err
+	}
+	return g.reply(i, fmt.Sprintf("Playing '%s'.", option.String()))
+}
+
+// Sound effect names are file names without extension.
+func (g *Gateway) sfxNames() ([]string, error) {
+	entries, err := os.ReadDir(g.sfxDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())))
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Only files directly in the sound effects directory can be played.
+func (g *Gateway) resolveSFX(name string) (string, error) {
+	if name != filepath.Base(name) || strings.ContainsAny(name, `/\`) {
+		return "", ErrUnknownSFX
+	}
+	entries, err := os.ReadDir(g.sfxDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrUnknownSFX
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())), name) {
+			return filepath.Join(g.sfxDir, e.Name()), nil
+		}
+	}
+	return "", ErrUnknownSFX
+}
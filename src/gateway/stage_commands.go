@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/api"
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+// Topic of stages started without one.
+var DEFAULT_STAGE_TOPIC = "Listening party"
+
+// takeStage makes the bot a speaker of the stage it joined. Without Mute
+// Members in the stage it raises its hand instead, for a moderator to
+// accept.
+func (g *Gateway) takeStage(guildID, channelID string) {
+	suppress := false
+	err := g.voice.ModifyCurrentUserVoiceState(g.ctx, guildID, structs.ModifyCurrentUserVoiceState{
+		ChannelID: channelID,
+		Suppress:  &suppress,
+	})
+	if err == nil {
+		g.log.Info("speaking on stage.", "guild_id", guildID, "channel_id", channelID)
+		return
+	}
+	if !errors.Is(err, api.ErrForbidden) {
+		g.log.Warn("failed to become a stage speaker.", "guild_id", guildID, "error", err.Error())
+		return
+	}
+	now := time.Now()
+	err = g.voice.ModifyCurrentUserVoiceState(g.ctx, guildID, structs.ModifyCurrentUserVoiceState{
+		ChannelID:               channelID,
+		RequestToSpeakTimestamp: &now,
+	})
+	if err != nil {
+		g.log.Warn("failed to request to speak.", "guild_id", guildID, "error", err.Error())
+		return
+	}
+	g.log.Info("requested to speak on stage.", "guild_id", guildID, "channel_id", channelID)
+}
+
+// onStageCommand starts, retitles or ends the stage the bot plays in.
+// Hosting is limited to stage moderators, and the bot must be one too. It
+// must be used in the stage's chat, the member's permissions are those of
+// the channel the command was used in.
+func (g *Gateway) onStageCommand(i *structs.Interaction) error {
+	sub, ok := i.Data.SubCommand()
+	if !ok {
+		return g.reply(i, "Use `/stage start` to host a listening party.")
+	}
+	v := g.voiceManager.Get(i.GuildID)
+	if v == nil {
+		return g.reply(i, "Not connected to a voice channel.")
+	}
+	if !g.isStageChannel(i.GuildID, v.ChannelID) {
+		return g.reply(i, "Not in a stage channel.")
+	}
+	if i.Channel.ID != v.ChannelID {
+		return g.reply(i, fmt.Sprintf("Use this command in the chat of <#%s>.", v.ChannelID))
+	}
+	if !i.Member.HasPermission(structs.PermissionMuteMembers) {
+		return g.reply(i, "Hosting a stage requires the Mute Members permission.")
+	}
+	var err error
+	var msg string
+	switch sub.Name {
+	case "start":
+		topic := DEFAULT_STAGE_TOPIC
+		if option, ok := sub.Option("topic"); ok && strings.TrimSpace(option.String()) != "" {
+			topic = strings.TrimSpace(option.String())
+		}
+		if len([]rune(topic)) > api.MAX_STAGE_TOPIC_LENGTH {
+			topic = string([]rune(topic)[:api.MAX_STAGE_TOPIC_LENGTH])
+		}
+		msg, err = g.startStage(i.GuildID, v.ChannelID, topic)
+	case "end":
+		err = g.stage.DeleteStageInstance(g.ctx, v.ChannelID)
+		if errors.Is(err, api.ErrNotFound) {
+			return g.reply(i, "The stage is not live.")
+		}
+		msg = "Stage ended."
+	default:
+		return g.reply(i, fmt.Sprintf("Unknown subcommand '%s'.", sub.Name))
+	}
+	if errors.Is(err, api.ErrForbidden) {
+		return g.reply(i, "I need to be a stage moderator to host.")
+	}
+	if err != nil {
+		return err
+	}
+	return g.reply(i, msg)
+}
+
+// startStage starts the stage, or changes the topic if it is live.
+func (g *Gateway) startStage(guildID, channelID, topic string) (string, error) {
+	_, err := g.stage.GetStageInstance(g.ctx, channelID)
+	if err == nil {
+		_, err = g.stage.ModifyStageInstance(g.ctx, channelID, api.ModifyStageInstanceData{Topic: topic})
+		return fmt.Sprintf("Stage topic changed to '%s'.", topic), err
+	}
+	if !errors.Is(err, api.ErrNotFound) {
+		return "", err
+	}
+	_, err = g.stage.CreateStageInstance(g.ctx, api.CreateStageInstanceData{
+		ChannelID:    channelID,
+		Topic:        topic,
+		PrivacyLevel: structs.StagePrivacyLevelGuildOnly,
+	})
+	if err != nil {
+		return "", err
+	}
+	// Hosting needs the moderator permissions that make the bot a speaker.
+	go g.takeStage(guildID, channelID)
+	return fmt.Sprintf("Stage '%s' is live.", topic), nil
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/queue"
+	"github.com/hendrywilliam/siren/src/structs"
+	"github.com/hendrywilliam/siren/src/tts"
+	"github.com/hendrywilliam/siren/src/voice"
+)
+
+func (g *Gateway) onSayCommand(i *structs.Interaction) error {
+	if g.speaker == nil {
+		return g.reply(i, "Text to speech is not available.")
+	}
+	option, ok := i.Data.Option("text")
+	if !ok || strings.TrimSpace(option.String()) == "" {
+		return g.reply(i, "Tell me what to say.")
+	}
+	v := g.voiceManager.Get(i.GuildID)
+	if v == nil {
+		return g.reply(i, "Not connected to a voice channel.")
+	}
+	err := v.Say(option.String())
+	if errors.Is(err, audio.ErrTooManyOverlays) {
+		return g.reply(i, "Too many sound effects playing.")
+	}
+	if errors.Is(err, voice.ErrVoiceNotReady) {
+		return g.reply(i, "Voice connection is not ready yet.")
+	}
+	if err != nil {
+		return err
+	}
+	return g.reply(i, "Speaking.")
+}
+
+func (g *Gateway) onAnnounceCommand(i *structs.Interaction) error {
+	announcements := g.voiceManager.Guild(i.GuildID).Announcements
+	option, ok := i.Data.Option("mode")
+	if !ok {
+		return g.reply(i, fmt.Sprintf("Announcements are %s.", announcements.Mode()))
+	}
+	err := announcements.Set(strings.ToLower(option.String()))
+	if errors.Is(err, tts.ErrUnknownMode) {
+		return g.reply(i, fmt.Sprintf("Unknown announcement mode '%s', use off, before or over.", option.String()))
+	}
+	if err != nil {
+		return err
+	}
+	if announcements.Mode() != tts.ModeOff && g.speaker == nil {
+		return g.reply(i, "Announcements enabled, but text to speech is not available.")
+	}
+	switch announcements.Mode() {
+	case tts.ModeBefore:
+		return g.reply(i, "Tracks are announced before they play.")
+	case tts.ModeOver:
+		return g.reply(i, "Tracks are announced over their start.")
+	default:
+		return g.reply(i, "Announcements disabled.")
+	}
+}
+
+// announcement is the text spoken before track.
+func announcement(track queue.Track) string {
+	title := track.Title
+	if track.Metadata.Title != "" {
+		title = track.Metadata.Title
+		if track.Metadata.Artist != "" {
+			title += " by " + track.Metadata.Artist
+		}
+	}
+	if title == "" {
+		title = track.Name
+	}
+	switch {
+	case track.Autoplay:
+		return fmt.Sprintf("Now playing %s, picked by autoplay.", title)
+	case track.RequesterName != "":
+		return fmt.Sprintf("Now playing %s, requested by %s.", title, track.RequesterName)
+	default:
+		return fmt.Sprintf("Now playing %s.", title)
+	}
+}
@@ -0,0 +1,309 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/metadata"
+	"github.com/hendrywilliam/siren/src/utils"
+)
+
+var (
+	ErrNotFound = errors.New("no matching track in library")
+)
+
+// Files with these extensions are indexed.
+var EXTENSIONS = []string{
+	".mp3", ".flac", ".ogg", ".opus", ".oga", ".wav", ".m4a", ".aac",
+	".webm", ".mka", ".wma", ".aiff", ".aif", ".alac", ".mp4",
+}
+
+// Entry is an indexed file.
+type Entry struct {
+	// Absolute path, used to play the file.
+	Path string `json:"path"`
+	// Path relative to its root, used as identifier in commands.
+	Name     string            `json:"name"`
+	Root     string            `json:"root"`
+	Size     int64             `json:"size"`
+	ModTime  time.Time         `json:"mod_time"`
+	Metadata metadata.Metadata `json:"metadata"`
+	// Probing failed, the next scan probes again.
+	Unprobed bool `json:"unprobed,omitempty"`
+}
+
+// Title shown to users, falls back to the file name.
+func (e Entry) Title() string {
+	return e.Metadata.DisplayTitle(strings.TrimSuffix(filepath.Base(e.Name), filepath.Ext(e.Name)))
+}
+
+func (e Entry) Source() audio.AudioSource {
+	return audio.NewFileSource(e.Path)
+}
+
+type Options struct {
+	// Directories scanned recursively.
+	Dirs []string
+	// Index file, kept in memory only if empty.
+	IndexPath string
+	Prober    *metadata.Prober
+	// Defaults to slog.Default().
+	Log *slog.Logger
+}
+
+// Library indexes media files of configured directories.
+type Library struct {
+	roots     []string
+	indexPath string
+	prober    *metadata.Prober
+	log       *slog.Logger
+
+	scanMu  sync.Mutex
+	mu      sync.RWMutex
+	entries map[string]Entry // By path.
+}
+
+func NewLibrary(opts Options) *Library {
+	roots := []string{}
+	for _, dir := range opts.Dirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			roots = append(roots, abs)
+		}
+	}
+	l := &Library{
+		roots:     roots,
+		indexPath: opts.IndexPath,
+		prober:    opts.Prober,
+		log:       opts.Log,
+		entries:   make(map[string]Entry),
+	}
+	if l.log == nil {
+		l.log = slog.Default()
+	}
+	if err := l.load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		l.log.Warn("failed to load library index.", "error", err.Error())
+	}
+	return l
+}
+
+// Roots returns the absolute library directories.
+func (l *Library) Roots() []string {
+	return slices.Clone(l.roots)
+}
+
+// Scan indexes new and modified files and drops removed ones.
+func (l *Library) Scan(ctx context.Context) error {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+	seen := make(map[string]struct{})
+	changed := false
+	for _, root := range l.roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path == root {
+					return err
+				}
+				// Unreadable subdirectory, keep scanning the rest.
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if strings.HasPrefix(d.Name(), ".") && path != root {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || !d.Type().IsRegular() || !isMedia(path) {
+				return nil
+			}
+			seen[path] = struct{}{}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			l.mu.RLock()
+			prev, ok := l.entries[path]
+			l.mu.RUnlock()
+			if ok && !prev.Unprobed && prev.Size == info.Size() && prev.ModTime.Equal(info.ModTime()) {
+				return nil
+			}
+			name, _ := filepath.Rel(root, path)
+			e := Entry{
+				Path:    path,
+				Name:    filepath.ToSlash(name),
+				Root:    root,
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			}
+			if l.prober != nil {
+				m, err := l.prober.Probe(ctx, e.Source())
+				if err != nil {
+					if ctx.Err() == nil {
+						l.log.Debug("failed to probe library file.", "path", path, "error", err.Error())
+					}
+					// Still playable by name.
+					e.Unprobed = true
+				}
+				e.Metadata = m
+			}
+			if ok && e.Unprobed && prev.Unprobed && prev.Size == e.Size && prev.ModTime.Equal(e.ModTime) {
+				// Failed again, nothing to save.
+				return nil
+			}
+			l.mu.Lock()
+			l.entries[path] = e
+			l.mu.Unlock()
+			changed = true
+			return nil
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	for path := range l.entries {
+		if _, ok := seen[path]; !ok {
+			delete(l.entries, path)
+			changed = true
+		}
+	}
+	l.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	l.log.Info("library indexed.", "tracks", l.Len())
+	return l.save()
+}
+
+// Watch scans the library and polls for changes by rescanning every interval
+// until ctx is done, there is no filesystem notification. A non positive
+// interval scans once.
+func (l *Library) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		if err := l.Scan(ctx); err != nil && ctx.Err() == nil {
+			l.log.Warn("library scan failed.", "error", err.Error())
+		}
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := l.Scan(ctx); err != nil && ctx.Err() == nil {
+			l.log.Warn("library scan failed.", "error", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *Library) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.entries)
+}
+
+// Entries sorted by name.
+func (l *Library) Entries() []Entry {
+	l.mu.RLock()
+	entries := make([]Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		entries = append(entries, e)
+	}
+	l.mu.RUnlock()
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return entries
+}
+
+// Lookup returns the entry of the file at path, if indexed.
+func (l *Library) Lookup(path string) (Entry, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Entry{}, false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	e, ok := l.entries[abs]
+	return e, ok
+}
+
+// Resolve returns the entry named by query, or the best search match scoring
+// MIN_RESOLVE_SCORE. Only indexed files are returned, query is never used as
+// a path.
+func (l *Library) Resolve(query string) (Entry, error) {
+	name := strings.TrimPrefix(filepath.ToSlash(query), "./")
+	l.mu.RLock()
+	for _, e := range l.entries {
+		if e.Name == name {
+			l.mu.RUnlock()
+			return e, nil
+		}
+	}
+	l.mu.RUnlock()
+	if normalize(query) == "" {
+		return Entry{}, ErrNotFound
+	}
+	for _, m := range l.search(query) {
+		if m.worst >= MIN_RESOLVE_SCORE {
+			return m.entry, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+func (l *Library) load() error {
+	if l.indexPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(l.indexPath)
+	if err != nil {
+		return err
+	}
+	entries := []Entry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range entries {
+		// Roots may have changed since the index was written.
+		if slices.Contains(l.roots, e.Root) {
+			l.entries[e.Path] = e
+		}
+	}
+	return nil
+}
+
+func (l *Library) save() error {
+	if l.indexPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.Entries(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(l.indexPath, data)
+}
+
+func isMedia(path string) bool {
+	return slices.Contains(EXTENSIONS, strings.ToLower(filepath.Ext(path)))
+}
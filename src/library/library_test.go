@@ -0,0 +1,29 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hendrywilliam/siren/src/metadata"
+)
+
+func TestScanUnprobeable(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.mp3"), []byte("not audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLibrary(Options{Dirs: []string{dir}, Prober: metadata.NewProber()})
+	if err := l.Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	e, err := l.Resolve("broken.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Kept playable, probed again by the next scan.
+	if !e.Unprobed {
+		t.Fatal("failed probe not marked")
+	}
+}
@@ -0,0 +1,56 @@
+package library
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// Relatedness of a candidate sharing a tag with the seed.
+const (
+	artistScore = 3
+	albumScore  = 2
+	genreScore  = 1
+)
+
+// Related picks a random entry among the ones sharing the most tags with
+// seed, any entry if none does. Entries whose path is in exclude are
+// skipped, false if nothing is left.
+func (l *Library) Related(seed Entry, exclude map[string]struct{}) (Entry, bool) {
+	best := 0
+	candidates := []Entry{}
+	for _, e := range l.Entries() {
+		if _, ok := exclude[e.Path]; ok || e.Path == seed.Path {
+			continue
+		}
+		score := relatedness(seed, e)
+		switch {
+		case score > best:
+			best = score
+			candidates = append(candidates[:0], e)
+		case score == best:
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		return Entry{}, false
+	}
+	return candidates[rand.Intn(len(candidates))], true
+}
+
+func relatedness(a, b Entry) int {
+	score := 0
+	if sameTag(a.Metadata.Artist, b.Metadata.Artist) {
+		score += artistScore
+	}
+	if sameTag(a.Metadata.Album, b.Metadata.Album) {
+		score += albumScore
+	}
+	if sameTag(a.Metadata.Genre, b.Metadata.Genre) {
+		score += genreScore
+	}
+	return score
+}
+
+func sameTag(a, b string) bool {
+	return a != "" && strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
@@ -0,0 +1,149 @@
+package library
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+type field struct {
+	weight float64
+	text   string
+	words  []string
+}
+
+// Resolve only falls back to a search match whose every word scored at
+// least this, unweighted. Typos pass, subsequences alone do not.
+var MIN_RESOLVE_SCORE = 0.5
+
+type match struct {
+	entry Entry
+	score float64
+	// Lowest unweighted score of a query word.
+	worst float64
+}
+
+// Search returns up to limit entries matching every word of query in title,
+// artist, album or file name, best first. Small typos are tolerated.
+func (l *Library) Search(query string, limit int) []Entry {
+	matches := l.search(query)
+	results := make([]Entry, 0, min(limit, len(matches)))
+	for _, m := range matches[:min(limit, len(matches))] {
+		results = append(results, m.entry)
+	}
+	return results
+}
+
+// Every entry matches an empty query, in name order.
+func (l *Library) search(query string) []match {
+	tokens := strings.Fields(normalize(query))
+	matches := []match{}
+	for _, e := range l.Entries() {
+		fields := entryFields(e)
+		m := match{entry: e, worst: 1}
+		for _, token := range tokens {
+			best, raw := 0.0, 0.0
+			for _, f := range fields {
+				score := tokenScore(token, f)
+				best = max(best, f.weight*score)
+				raw = max(raw, score)
+			}
+			if best == 0 {
+				m.score = -1
+				break
+			}
+			m.score += best
+			m.worst = min(m.worst, raw)
+		}
+		if m.score >= 0 {
+			matches = append(matches, m)
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int {
+		return cmp.Compare(b.score, a.score)
+	})
+	return matches
+}
+
+func entryFields(e Entry) []field {
+	fields := []field{}
+	add := func(weight float64, text string) {
+		text = normalize(text)
+		if text != "" {
+			fields = append(fields, field{weight: weight, text: text, words: strings.Fields(text)})
+		}
+	}
+	add(3, e.Metadata.Title)
+	add(2, e.Metadata.Artist)
+	add(1.5, e.Metadata.Album)
+	add(1, e.Name)
+	return fields
+}
+
+func tokenScore(token string, f field) float64 {
+	score := 0.0
+	for _, w := range f.words {
+		switch {
+		case w == token:
+			return 1
+		case strings.HasPrefix(w, token):
+			score = max(score, 0.8)
+		case len(token) >= 4 && levenshtein(w, token) <= maxTypos(token):
+			score = max(score, 0.5)
+		}
+	}
+	if score == 0 && strings.Contains(f.text, token) {
+		score = 0.6
+	}
+	if score == 0 && len(token) >= 3 && isSubsequence(token, f.text) {
+		score = 0.3
+	}
+	return score
+}
+
+func maxTypos(token string) int {
+	if len(token) >= 8 {
+		return 2
+	}
+	return 1
+}
+
+// Lower case letters and digits, anything else separates words.
+func normalize(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+func isSubsequence(needle, haystack string) bool {
+	n := []rune(needle)
+	i := 0
+	for _, r := range haystack {
+		if i < len(n) && r == n[i] {
+			i++
+		}
+	}
+	return i == len(n)
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
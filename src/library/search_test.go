@@ -0,0 +1,96 @@
+package library
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/hendrywilliam/siren/src/metadata"
+)
+
+func testLibrary(entries ...Entry) *Library {
+	l := NewLibrary(Options{})
+	for _, e := range entries {
+		e.Path = "/music/" + e.Name
+		l.entries[e.Path] = e
+	}
+	return l
+}
+
+func names(entries []Entry) []string {
+	out := []string{}
+	for _, e := range entries {
+		out = append(out, e.Name)
+	}
+	return out
+}
+
+func TestSearchRanking(t *testing.T) {
+	l := testLibrary(
+		Entry{Name: "a.mp3", Metadata: metadata.Metadata{Title: "Moonlight Sonata", Artist: "Beethoven"}},
+		Entry{Name: "b.mp3", Metadata: metadata.Metadata{Title: "Moon River", Artist: "Audrey Hepburn"}},
+		Entry{Name: "c.mp3", Metadata: metadata.Metadata{Title: "Harvest Moon", Artist: "Neil Young"}},
+		Entry{Name: "d.mp3", Metadata: metadata.Metadata{Title: "Clair de Lune", Album: "Suite bergamasque"}},
+		Entry{Name: "moon/e.mp3"},
+	)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// Whole words, prefixes in the title, then the file name.
+		{"moon", []string{"b.mp3", "c.mp3", "a.mp3", "moon/e.mp3"}},
+		// Prefix before typos.
+		{"moonl", []string{"a.mp3", "b.mp3", "c.mp3", "moon/e.mp3"}},
+		// One typo in short words, two from eight letters.
+		{"beethovn", []string{"a.mp3"}},
+		{"beehtoven", []string{"a.mp3"}},
+		// Letters in order.
+		{"bhvn", []string{"a.mp3"}},
+		{"xyzzy", []string{}},
+		// Every word must match.
+		{"moon young", []string{"c.mp3"}},
+		{"moon lune", []string{}},
+		// Title beats album.
+		{"clair bergamasque", []string{"d.mp3"}},
+		{"", []string{"a.mp3", "b.mp3", "c.mp3", "d.mp3", "moon/e.mp3"}},
+	}
+	for _, tt := range tests {
+		if got := names(l.Search(tt.query, 10)); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+	if got := names(l.Search("moon", 2)); !slices.Equal(got, []string{"b.mp3", "c.mp3"}) {
+		t.Errorf("limited: got %v", got)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	l := testLibrary(
+		Entry{Name: "rock/song.mp3", Metadata: metadata.Metadata{Title: "Bohemian Rhapsody", Artist: "Queen"}},
+		Entry{Name: "jazz/take five.flac"},
+	)
+	tests := []struct {
+		query string
+		want  string
+		err   error
+	}{
+		{"rock/song.mp3", "rock/song.mp3", nil},
+		{"./jazz/take five.flac", "jazz/take five.flac", nil},
+		{"rhapsody", "rock/song.mp3", nil},
+		{"bohemain", "rock/song.mp3", nil},
+		{"take", "jazz/take five.flac", nil},
+		// Only a subsequence of the title.
+		{"bhmn", "", ErrNotFound},
+		{"", "", ErrNotFound},
+		{"/etc/passwd", "", ErrNotFound},
+	}
+	for _, tt := range tests {
+		e, err := l.Resolve(tt.query)
+		if !errors.Is(err, tt.err) || e.Name != tt.want {
+			t.Errorf("%q: got %q, %v, want %q, %v", tt.query, e.Name, err, tt.want, tt.err)
+		}
+	}
+	if got := names(l.Search("bhmn", 1)); !slices.Equal(got, []string{"rock/song.mp3"}) {
+		t.Errorf("search still matches subsequences: got %v", got)
+	}
+}
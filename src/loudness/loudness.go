@@ -0,0 +1,277 @@
+package loudness
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/ffmpeg"
+	"github.com/hendrywilliam/siren/src/utils"
+)
+
+var (
+	ErrNoStats = errors.New("no loudness stats in ffmpeg output")
+)
+
+const (
+	// Streaming platforms target around -14 LUFS.
+	DefaultTarget = -14.0
+	MinTarget     = -70.0
+	MaxTarget     = -5.0
+
+	// Boosting stops before the true peak reaches this level, soft clipping
+	// takes care of the rest.
+	MaxTruePeak = -1.0
+)
+
+// Stats of a file as measured by ffmpeg loudnorm, in LUFS and dBTP.
+type Stats struct {
+	Integrated float64 `json:"integrated"`
+	TruePeak   float64 `json:"true_peak"`
+	Range      float64 `json:"range"`
+	Threshold  float64 `json:"threshold"`
+}
+
+// Gain in dB bringing the file to the target loudness.
+func (s Stats) Gain(target float64) float64 {
+	gain := target - s.Integrated
+	if headroom := MaxTruePeak - s.TruePeak; gain > headroom {
+		gain = max(headroom, 0)
+	}
+	return gain
+}
+
+type entry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Stats   Stats     `json:"stats"`
+}
+
+type Options struct {
+	// Cache file, analysis is kept in memory only if empty.
+	CachePath string
+	Log       *slog.Logger
+}
+
+// Analyzer measures files once, results are cached until the file changes.
+type Analyzer struct {
+	cachePath string
+	log       *slog.Logger
+
+	mu       sync.Mutex
+	entries  map[string]entry
+	inflight map[string]chan struct{}
+	// One analysis at a time each for tracks about to play and for
+	// AnalyzeDir, it decodes the whole file. Plays never queue behind a scan.
+	sem    chan struct{}
+	dirSem chan struct{}
+	// Saves in order, an older snapshot never replaces a newer one.
+	saveMu sync.Mutex
+}
+
+func NewAnalyzer(opts Options) *Analyzer {
+	a := &Analyzer{
+		cachePath: opts.CachePath,
+		log:       opts.Log,
+		entries:   make(map[string]entry),
+		inflight:  make(map[string]chan struct{}),
+		sem:       make(chan struct{}, 1),
+		dirSem:    make(chan struct{}, 1),
+	}
+	if err := a.load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		a.log.Warn("failed to load loudness cache.", "error", err.Error())
+	}
+	return a
+}
+
+// Lookup returns cached stats, false if the file was not analyzed or changed.
+func (a *Analyzer) Lookup(path string) (Stats, bool) {
+	key, info, err := stat(path)
+	if err != nil {
+		return Stats{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.entries[key]
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return Stats{}, false
+	}
+	return e.Stats, true
+}
+
+// Analyze returns cached stats or measures the file.
+func (a *Analyzer) Analyze(ctx context.Context, path string) (Stats, error) {
+	return a.analyze(ctx, path, a.sem)
+}
+
+func (a *Analyzer) analyze(ctx context.Context, path string, sem chan struct{}) (Stats, error) {
+	if stats, ok := a.Lookup(path); ok {
+		return stats, nil
+	}
+	key, info, err := stat(path)
+	if err != nil {
+		return Stats{}, err
+	}
+	a.mu.Lock()
+	if wait, ok := a.inflight[key]; ok {
+		a.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return Stats{}, ctx.Err()
+		}
+		if stats, ok := a.Lookup(path); ok {
+			return stats, nil
+		}
+		return Stats{}, ErrNoStats
+	}
+	wait := make(chan struct{})
+	a.inflight[key] = wait
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.inflight, key)
+		a.mu.Unlock()
+		close(wait)
+	}()
+
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return Stats{}, ctx.Err()
+	}
+	stats, err := measure(ctx, path)
+	<-sem
+	if err != nil {
+		return Stats{}, err
+	}
+	a.mu.Lock()
+	a.entries[key] = entry{Size: info.Size(), ModTime: info.ModTime(), Stats: stats}
+	a.mu.Unlock()
+	if err := a.save(); err != nil {
+		a.log.Warn("failed to save loudness cache.", "error", err.Error())
+	}
+	a.log.Debug("analyzed loudness.", "path", path, "integrated", stats.Integrated, "true_peak", stats.TruePeak)
+	return stats, nil
+}
+
+// AnalyzeDir measures every file under dir not already cached.
+func (a *Analyzer) AnalyzeDir(ctx context.Context, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if _, err := a.analyze(ctx, path, a.dirSem); err != nil && ctx.Err() == nil {
+			a.log.Debug("skipped loudness analysis.", "path", path, "error", err.Error())
+		}
+		return nil
+	})
+}
+
+func (a *Analyzer) load() error {
+	if a.cachePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.cachePath)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return json.Unmarshal(data, &a.entries)
+}
+
+func (a *Analyzer) save() error {
+	if a.cachePath == "" {
+		return nil
+	}
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+	a.mu.Lock()
+	data, err := json.MarshalIndent(a.entries, "", "  ")
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(a.cachePath, data)
+}
+
+func stat(path string) (string, fs.FileInfo, error) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, info, nil
+}
+
+// First pass of ffmpeg loudnorm, the measurement is printed as json to stderr.
+func measure(ctx context.Context, path string) (Stats, error) {
+	out, err := ffmpeg.Run(ctx, "ffmpeg", []string{
+		"-hide_banner",
+		"-nostats",
+		"-i", path,
+		"-vn",
+		"-af", "loudnorm=print_format=json",
+		"-f", "null",
+		"-",
+	}, nil)
+	if err != nil {
+		return Stats{}, err
+	}
+	return parseStats(out.Stderr)
+}
+
+func parseStats(output []byte) (Stats, error) {
+	start := bytes.LastIndexByte(output, '{')
+	end := bytes.LastIndexByte(output, '}')
+	if start < 0 || end < start {
+		return Stats{}, ErrNoStats
+	}
+	// Values are quoted, e.g. "input_i" : "-23.54".
+	raw := map[string]string{}
+	if err := json.Unmarshal(output[start:end+1], &raw); err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{}
+	fields := map[string]*float64{
+		"input_i":      &stats.Integrated,
+		"input_tp":     &stats.TruePeak,
+		"input_lra":    &stats.Range,
+		"input_thresh": &stats.Threshold,
+	}
+	for k, v := range fields {
+		f, err := strconv.ParseFloat(raw[k], 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			// Silent input measures as -inf.
+			return Stats{}, ErrNoStats
+		}
+		*v = f
+	}
+	return stats, nil
+}
@@ -0,0 +1,35 @@
+package loudness
+
+import "sync"
+
+// Target loudness of a guild, shared with its voice session.
+type Target struct {
+	mu      sync.Mutex
+	lufs    float64
+	enabled bool
+}
+
+func NewTarget(lufs float64) *Target {
+	return &Target{lufs: lufs, enabled: true}
+}
+
+// Set target in LUFS and enable normalization.
+func (t *Target) Set(lufs float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lufs = min(max(lufs, MinTarget), MaxTarget)
+	t.enabled = true
+}
+
+func (t *Target) Disable() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enabled = false
+}
+
+// Get returns the target, false if normalization is disabled.
+func (t *Target) Get() (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lufs, t.enabled
+}
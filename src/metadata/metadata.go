@@ -0,0 +1,267 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/ffmpeg"
+)
+
+var (
+	ErrNotProbeable = errors.New("source can not be probed")
+	ErrNoCover      = errors.New("no embedded cover art")
+)
+
+// Cached entries, oldest are dropped first.
+var MAX_CACHE_ENTRIES = 1000
+
+// Metadata of a source, fields are empty when unknown.
+type Metadata struct {
+	Title    string        `json:"title,omitempty"`
+	Artist   string        `json:"artist,omitempty"`
+	Album    string        `json:"album,omitempty"`
+	Genre    string        `json:"genre,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	// Bits per second.
+	Bitrate int64 `json:"bitrate,omitempty"`
+	// Codec of the embedded cover art, e.g. mjpeg or png.
+	CoverCodec string `json:"cover_codec,omitempty"`
+}
+
+func (m Metadata) HasCover() bool {
+	return m.CoverCodec != ""
+}
+
+// Display name, "Artist - Title" or fallback if there is no title.
+func (m Metadata) DisplayTitle(fallback string) string {
+	switch {
+	case m.Title == "":
+		return fallback
+	case m.Artist == "":
+		return m.Title
+	default:
+		return fmt.Sprintf("%s - %s", m.Artist, m.Title)
+	}
+}
+
+type cacheEntry struct {
+	// Files are probed again once modified.
+	size     int64
+	modTime  time.Time
+	metadata Metadata
+}
+
+// Prober runs ffprobe, results are cached per file or url.
+type Prober struct {
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+	order []string
+}
+
+func NewProber() *Prober {
+	return &Prober{
+		cache: make(map[string]cacheEntry),
+	}
+}
+
+// Probe returns metadata of files and http sources.
+func (p *Prober) Probe(ctx context.Context, source audio.AudioSource) (Metadata, error) {
+	input, size, modTime, err := probeInput(source)
+	if err != nil {
+		return Metadata{}, err
+	}
+	p.mu.Lock()
+	e, ok := p.cache[input]
+	p.mu.Unlock()
+	if ok && e.size == size && e.modTime.Equal(modTime) {
+		return e.metadata, nil
+	}
+	arg, in, err := openInput(ctx, source, input)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if in != nil {
+		defer in.Close()
+	}
+	m, err := ffprobe(ctx, arg, in)
+	if err != nil {
+		return Metadata{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.cache[input]; !ok {
+		p.order = append(p.order, input)
+	}
+	p.cache[input] = cacheEntry{size: size, modTime: modTime, metadata: m}
+	for len(p.order) > MAX_CACHE_ENTRIES {
+		delete(p.cache, p.order[0])
+		p.order = p.order[1:]
+	}
+	return m, nil
+}
+
+// Cover extracts the embedded cover art, returns image and its content type.
+func (p *Prober) Cover(ctx context.Context, source audio.AudioSource) ([]byte, string, error) {
+	m, err := p.Probe(ctx, source)
+	if err != nil {
+		return nil, "", err
+	}
+	if !m.HasCover() {
+		return nil, "", ErrNoCover
+	}
+	input, _, _, err := probeInput(source)
+	if err != nil {
+		return nil, "", err
+	}
+	arg, in, err := openInput(ctx, source, input)
+	if err != nil {
+		return nil, "", err
+	}
+	if in != nil {
+		defer in.Close()
+	}
+	out, err := ffmpeg.Run(ctx, "ffmpeg", []string{
+		"-hide_banner",
+		"-i", arg,
+		"-an",
+		"-map", "0:v:0",
+		"-c:v", "copy",
+		"-frames:v", "1",
+		"-f", "image2pipe",
+		"-",
+	}, in)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(out.Stdout) == 0 {
+		return nil, "", ErrNoCover
+	}
+	contentType := "image/jpeg"
+	if m.CoverCodec == "png" {
+		contentType = "image/png"
+	}
+	return out.Stdout, contentType, nil
+}
+
+// Input passed to ffprobe, files carry size and modification time.
+func probeInput(source audio.AudioSource) (string, int64, time.Time, error) {
+	switch s := source.(type) {
+	case audio.PathSource:
+		path, err := filepath.Abs(s.Path())
+		if err != nil {
+			return "", 0, time.Time{}, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", 0, time.Time{}, err
+		}
+		return path, info.Size(), info.ModTime(), nil
+	case *audio.HTTPSource:
+		if err := audio.CheckURL(s.URL); err != nil {
+			return "", 0, time.Time{}, err
+		}
+		return s.URL, 0, time.Time{}, nil
+	default:
+		return "", 0, time.Time{}, ErrNotProbeable
+	}
+}
+
+// openInput returns the ffprobe or ffmpeg input and the reader to feed to
+// stdin. Urls are fetched by the source, so their redirects never leave the
+// allowed hosts, and piped on pipe:0.
+func openInput(ctx context.Context, source audio.AudioSource, input string) (string, io.ReadCloser, error) {
+	if s, ok := source.(*audio.HTTPSource); ok {
+		body, err := s.Open(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		return "pipe:0", body, nil
+	}
+	return input, nil, nil
+}
+
+type probeOutput struct {
+	Format struct {
+		Duration string            `json:"duration"`
+		BitRate  string            `json:"bit_rate"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType   string            `json:"codec_type"`
+		CodecName   string            `json:"codec_name"`
+		Tags        map[string]string `json:"tags"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+func ffprobe(ctx context.Context, input string, in io.Reader) (Metadata, error) {
+	out, err := ffmpeg.Run(ctx, "ffprobe", []string{
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		input,
+	}, in)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return parseProbe(out.Stdout)
+}
+
+func parseProbe(data []byte) (Metadata, error) {
+	out := probeOutput{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return Metadata{}, err
+	}
+	// Tag keys differ in case between containers, ogg uses upper case.
+	tags := map[string]string{}
+	for _, s := range out.Streams {
+		if s.CodecType == "audio" {
+			mergeTags(tags, s.Tags)
+		}
+	}
+	mergeTags(tags, out.Format.Tags)
+	m := Metadata{
+		Title:  tags["title"],
+		Artist: tags["artist"],
+		Album:  tags["album"],
+		Genre:  tags["genre"],
+	}
+	if m.Artist == "" {
+		m.Artist = tags["album_artist"]
+	}
+	if secs, err := strconv.ParseFloat(out.Format.Duration, 64); err == nil {
+		m.Duration = time.Duration(secs * float64(time.Second))
+	}
+	if bitrate, err := strconv.ParseInt(out.Format.BitRate, 10, 64); err == nil {
+		m.Bitrate = bitrate
+	}
+	for _, s := range out.Streams {
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 1 {
+			m.CoverCodec = s.CodecName
+			break
+		}
+	}
+	return m, nil
+}
+
+// Format tags win over stream tags.
+func mergeTags(dst, src map[string]string) {
+	for k, v := range src {
+		if v = strings.TrimSpace(v); v != "" {
+			dst[strings.ToLower(k)] = v
+		}
+	}
+}
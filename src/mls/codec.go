@@ -0,0 +1,192 @@
+package mls
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrMalformed = errors.New("malformed mls message")
+
+// writer appends the TLS presentation language encoding used by MLS,
+// RFC 9420 section 2.1.
+type writer struct {
+	b []byte
+}
+
+func (w *writer) u8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+func (w *writer) u16(v uint16) {
+	w.b = binary.BigEndian.AppendUint16(w.b, v)
+}
+
+func (w *writer) u32(v uint32) {
+	w.b = binary.BigEndian.AppendUint32(w.b, v)
+}
+
+func (w *writer) u64(v uint64) {
+	w.b = binary.BigEndian.AppendUint64(w.b, v)
+}
+
+// varint is the variable length vector header, section 2.1.2.
+func (w *writer) varint(n int) {
+	switch {
+	case n < 1<<6:
+		w.b = append(w.b, byte(n))
+	case n < 1<<14:
+		w.b = binary.BigEndian.AppendUint16(w.b, uint16(n)|0x4000)
+	default:
+		w.b = binary.BigEndian.AppendUint32(w.b, uint32(n)|0x80000000)
+	}
+}
+
+// opaque writes a variable length byte vector.
+func (w *writer) opaque(v []byte) {
+	w.varint(len(v))
+	w.b = append(w.b, v...)
+}
+
+// vector writes the elements written by f as a variable length vector.
+func (w *writer) vector(f func(w *writer)) {
+	inner := &writer{}
+	f(inner)
+	w.opaque(inner.b)
+}
+
+func (w *writer) optional(present bool, f func(w *writer)) {
+	if !present {
+		w.u8(0)
+		return
+	}
+	w.u8(1)
+	f(w)
+}
+
+func (w *writer) raw(v []byte) {
+	w.b = append(w.b, v...)
+}
+
+// reader consumes the encoding written by writer. Once an error is
+// recorded every read returns zero values.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func newReader(b []byte) *reader {
+	return &reader{b: b}
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = ErrMalformed
+		return nil
+	}
+	v := r.b[:n:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) u8() uint8 {
+	b := r.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) u16() uint16 {
+	b := r.take(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) u32() uint32 {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *reader) u64() uint64 {
+	b := r.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// varint rejects the reserved 8 byte form and non minimal encodings.
+func (r *reader) varint() int {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) == 0 {
+		r.err = ErrMalformed
+		return 0
+	}
+	var n, least int
+	switch r.b[0] >> 6 {
+	case 0:
+		n = int(r.u8())
+	case 1:
+		n, least = int(r.u16()&0x3fff), 1<<6
+	case 2:
+		n, least = int(r.u32()&0x3fffffff), 1<<14
+	default:
+		r.err = ErrMalformed
+		return 0
+	}
+	if n < least {
+		r.err = ErrMalformed
+		return 0
+	}
+	return n
+}
+
+func (r *reader) opaque() []byte {
+	n := r.varint()
+	return r.take(n)
+}
+
+// vector calls f until the vector's bytes are consumed.
+func (r *reader) vector(f func(r *reader)) {
+	b := r.opaque()
+	if r.err != nil {
+		return
+	}
+	inner := newReader(b)
+	for len(inner.b) > 0 && inner.err == nil {
+		f(inner)
+	}
+	if inner.err != nil {
+		r.err = inner.err
+	}
+}
+
+func (r *reader) optional() bool {
+	switch r.u8() {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		r.err = ErrMalformed
+		return false
+	}
+}
+
+// done fails if bytes are left over.
+func (r *reader) done() error {
+	if r.err == nil && len(r.b) != 0 {
+		r.err = ErrMalformed
+	}
+	return r.err
+}
@@ -0,0 +1,194 @@
+package mls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// The only supported ciphersuite, MLS_128_DHKEMP256_AES128GCM_SHA256_P256.
+const (
+	ProtocolVersion uint16 = 1 // mls10
+	CipherSuite     uint16 = 0x0002
+
+	hashSize  = sha256.Size
+	keySize   = 16
+	nonceSize = 12
+)
+
+var (
+	ErrSignature        = errors.New("invalid mls signature")
+	ErrSignatureKey     = errors.New("invalid mls signature key")
+	ErrEncryptionKey    = errors.New("invalid mls encryption key")
+	ErrUnsupportedSuite = errors.New("unsupported mls ciphersuite")
+)
+
+func hash(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:]
+}
+
+func mac(key, b []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(b)
+	return m.Sum(nil)
+}
+
+func extract(salt, ikm []byte) []byte {
+	return hkdf.Extract(sha256.New, ikm, salt)
+}
+
+// expandWithLabel, RFC 9420 section 8.
+func expandWithLabel(secret []byte, label string, context []byte, length int) []byte {
+	w := &writer{}
+	w.u16(uint16(length))
+	w.opaque([]byte("MLS 1.0 " + label))
+	w.opaque(context)
+	out := make([]byte, length)
+	// Lengths are far below the HKDF limit.
+	io.ReadFull(hkdf.Expand(sha256.New, secret, w.b), out)
+	return out
+}
+
+func deriveSecret(secret []byte, label string) []byte {
+	return expandWithLabel(secret, label, nil, hashSize)
+}
+
+// refHash, RFC 9420 section 5.2.
+func refHash(label string, value []byte) []byte {
+	w := &writer{}
+	w.opaque([]byte(label))
+	w.opaque(value)
+	return hash(w.b)
+}
+
+func seal(key, nonce, aad, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+func open(key, nonce, aad, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func labeledContent(label string, content []byte) []byte {
+	w := &writer{}
+	w.opaque([]byte("MLS 1.0 " + label))
+	w.opaque(content)
+	return w.b
+}
+
+// signWithLabel signs with ECDSA P-256 over SHA-256, DER encoded.
+func signWithLabel(key *ecdsa.PrivateKey, label string, content []byte) ([]byte, error) {
+	digest := sha256.Sum256(labeledContent(label, content))
+	return ecdsa.SignASN1(rand.Reader, key, digest[:])
+}
+
+func verifyWithLabel(publicKey []byte, label string, content, signature []byte) error {
+	key, err := parseSignatureKey(publicKey)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(labeledContent(label, content))
+	if !ecdsa.VerifyASN1(key, digest[:], signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+func generateSignatureKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// Signature keys are uncompressed SEC1 points.
+func signatureKeyBytes(key *ecdsa.PrivateKey) []byte {
+	pub, _ := key.PublicKey.ECDH()
+	return pub.Bytes()
+}
+
+func parseSignatureKey(b []byte) (*ecdsa.PublicKey, error) {
+	// ecdh validates the point is on the curve.
+	if _, err := ecdh.P256().NewPublicKey(b); err != nil {
+		return nil, ErrSignatureKey
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), b)
+	if x == nil {
+		return nil, ErrSignatureKey
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+func parseEncryptionKey(b []byte) (*ecdh.PublicKey, error) {
+	key, err := ecdh.P256().NewPublicKey(b)
+	if err != nil {
+		return nil, ErrEncryptionKey
+	}
+	return key, nil
+}
+
+type hpkeCiphertext struct {
+	kemOutput  []byte
+	ciphertext []byte
+}
+
+func (c *hpkeCiphertext) marshal(w *writer) {
+	w.opaque(c.kemOutput)
+	w.opaque(c.ciphertext)
+}
+
+func (c *hpkeCiphertext) unmarshal(r *reader) {
+	c.kemOutput = r.opaque()
+	c.ciphertext = r.opaque()
+}
+
+// encryptWithLabel, RFC 9420 section 5.1.3.
+func encryptWithLabel(publicKey []byte, label string, context, plaintext []byte) (hpkeCiphertext, error) {
+	key, err := parseEncryptionKey(publicKey)
+	if err != nil {
+		return hpkeCiphertext{}, err
+	}
+	enc, ciphertext, err := sealBase(key, labeledContent(label, context), nil, plaintext)
+	if err != nil {
+		return hpkeCiphertext{}, err
+	}
+	return hpkeCiphertext{kemOutput: enc, ciphertext: ciphertext}, nil
+}
+
+func decryptWithLabel(key *ecdh.PrivateKey, label string, context []byte, c hpkeCiphertext) ([]byte, error) {
+	return openBase(c.kemOutput, key, labeledContent(label, context), nil, c.ciphertext)
+}
+
+// nodeKeyPair derives the key pair of a parent node from its path secret.
+func nodeKeyPair(pathSecret []byte) (*ecdh.PrivateKey, error) {
+	return deriveKeyPair(deriveSecret(pathSecret, "node"))
+}
+
+func randomSecret() []byte {
+	b := make([]byte, hashSize)
+	rand.Read(b)
+	return b
+}
@@ -0,0 +1,70 @@
+package mls
+
+import (
+	"crypto/ecdsa"
+)
+
+// ExternalSigner sends proposals to groups that list it as their first
+// external sender, the role of a voice server.
+type ExternalSigner struct {
+	sender ExternalSender
+	key    *ecdsa.PrivateKey
+}
+
+func NewExternalSigner(identity []byte) (*ExternalSigner, error) {
+	key, err := generateSignatureKey()
+	if err != nil {
+		return nil, err
+	}
+	return &ExternalSigner{
+		sender: ExternalSender{SignatureKey: signatureKeyBytes(key), Identity: identity},
+		key:    key,
+	}, nil
+}
+
+func (e *ExternalSigner) Sender() ExternalSender {
+	return e.sender
+}
+
+// ProposeAdd returns an add proposal for a serialized key package.
+func (e *ExternalSigner) ProposeAdd(groupID []byte, epoch uint64, keyPackage []byte) ([]byte, error) {
+	p := &proposal{typ: proposalTypeAdd}
+	r := newReader(keyPackage)
+	p.keyPackage.unmarshal(r)
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return e.propose(groupID, epoch, p)
+}
+
+func (e *ExternalSigner) ProposeRemove(groupID []byte, epoch uint64, leaf uint32) ([]byte, error) {
+	return e.propose(groupID, epoch, &proposal{typ: proposalTypeRemove, removed: leaf})
+}
+
+func (e *ExternalSigner) propose(groupID []byte, epoch uint64, p *proposal) ([]byte, error) {
+	m := &publicMessage{
+		content: framedContent{
+			groupID:     groupID,
+			epoch:       epoch,
+			sender:      sender{typ: senderTypeExternal},
+			contentType: contentTypeProposal,
+			proposal:    p,
+		},
+	}
+	var err error
+	m.signature, err = signWithLabel(e.key, "FramedContentTBS", m.content.tbs(nil))
+	if err != nil {
+		return nil, err
+	}
+	return m.bytes(), nil
+}
+
+// ProposalRef returns the reference of a serialized proposal message, used
+// to revoke it.
+func ProposalRef(message []byte) ([]byte, error) {
+	m, err := parsePublicMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	return refHash("MLS 1.0 Proposal Reference", m.authenticatedContent()), nil
+}
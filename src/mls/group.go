@@ -0,0 +1,767 @@
+package mls
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"errors"
+	"math"
+	"slices"
+)
+
+var (
+	ErrWrongGroup       = errors.New("mls message for another group")
+	ErrWrongEpoch       = errors.New("mls message for another epoch")
+	ErrOwnCommit        = errors.New("mls commit sent by this member")
+	ErrRemoved          = errors.New("removed from the mls group")
+	ErrNotInvited       = errors.New("mls welcome is not for this key package")
+	ErrUnknownSender    = errors.New("unknown mls sender")
+	ErrUnknownProposal  = errors.New("unknown mls proposal reference")
+	ErrMembershipTag    = errors.New("invalid mls membership tag")
+	ErrConfirmationTag  = errors.New("invalid mls confirmation tag")
+	ErrPath             = errors.New("invalid mls update path")
+	ErrUnexpectedSender = errors.New("unexpected mls sender type")
+)
+
+// KeyPackage is a key package with the private keys needed to join with it.
+type KeyPackage struct {
+	keyPackage    keyPackage
+	initKey       *ecdh.PrivateKey
+	encryptionKey *ecdh.PrivateKey
+	signatureKey  *ecdsa.PrivateKey
+}
+
+// NewKeyPackage creates a key package with a basic credential.
+func NewKeyPackage(identity []byte) (*KeyPackage, error) {
+	initKey, err := generateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	encryptionKey, err := generateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	signatureKey, err := generateSignatureKey()
+	if err != nil {
+		return nil, err
+	}
+	k := &KeyPackage{
+		keyPackage: keyPackage{
+			initKey: initKey.PublicKey().Bytes(),
+			leafNode: leafNode{
+				encryptionKey: encryptionKey.PublicKey().Bytes(),
+				signatureKey:  signatureKeyBytes(signatureKey),
+				credential:    credential{identity: identity},
+				capabilities: capabilities{
+					versions:     []uint16{ProtocolVersion},
+					cipherSuites: []uint16{CipherSuite},
+					credentials:  []uint16{credentialTypeBasic},
+				},
+				source:   leafNodeSourceKeyPackage,
+				notAfter: math.MaxUint64,
+			},
+		},
+		initKey:       initKey,
+		encryptionKey: encryptionKey,
+		signatureKey:  signatureKey,
+	}
+	if err := k.keyPackage.leafNode.sign(signatureKey, nil, 0); err != nil {
+		return nil, err
+	}
+	w := &writer{}
+	k.keyPackage.marshalContent(w)
+	k.keyPackage.signature, err = signWithLabel(signatureKey, "KeyPackageTBS", w.b)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Bytes returns the serialized key package, without an MLSMessage header.
+func (k *KeyPackage) Bytes() []byte {
+	return k.keyPackage.bytes()
+}
+
+func (k *KeyPackage) Ref() []byte {
+	return k.keyPackage.ref()
+}
+
+type epochSecrets struct {
+	init         []byte
+	exporter     []byte
+	confirmation []byte
+	membership   []byte
+}
+
+// Key schedule, RFC 9420 section 8.
+func newEpochSecrets(epochSecret []byte) epochSecrets {
+	return epochSecrets{
+		init:         deriveSecret(epochSecret, "init"),
+		exporter:     deriveSecret(epochSecret, "exporter"),
+		confirmation: deriveSecret(epochSecret, "confirm"),
+		membership:   deriveSecret(epochSecret, "membership"),
+	}
+}
+
+// memberSecret skips pre-shared keys, they are never used.
+func memberSecret(joinerSecret []byte) []byte {
+	return extract(joinerSecret, make([]byte, hashSize))
+}
+
+func joinerSecret(initSecret, commitSecret []byte, context *groupContext) []byte {
+	return expandWithLabel(extract(initSecret, commitSecret), "joiner", context.bytes(), hashSize)
+}
+
+func welcomeKey(joinerSecret []byte) ([]byte, []byte) {
+	welcomeSecret := deriveSecret(memberSecret(joinerSecret), "welcome")
+	return expandWithLabel(welcomeSecret, "key", nil, keySize), expandWithLabel(welcomeSecret, "nonce", nil, nonceSize)
+}
+
+func epochSecretsFromJoiner(joinerSecret []byte, context *groupContext) epochSecrets {
+	return newEpochSecrets(expandWithLabel(memberSecret(joinerSecret), "epoch", context.bytes(), hashSize))
+}
+
+func interimTranscriptHash(confirmedTranscriptHash, confirmationTag []byte) []byte {
+	w := &writer{}
+	w.raw(confirmedTranscriptHash)
+	w.opaque(confirmationTag)
+	return hash(w.b)
+}
+
+type cachedProposal struct {
+	ref      []byte
+	proposal *proposal
+}
+
+type addedMember struct {
+	leaf       uint32
+	keyPackage *keyPackage
+}
+
+// Group is the state of one epoch of an MLS group. Operations that move to
+// the next epoch return a new Group and leave the receiver untouched.
+type Group struct {
+	context               groupContext
+	tree                  *ratchetTree
+	self                  uint32
+	signatureKey          *ecdsa.PrivateKey
+	privateKeys           map[uint32]*ecdh.PrivateKey // by node index
+	secrets               epochSecrets
+	interimTranscriptHash []byte
+	externalSenders       []ExternalSender
+	proposals             []cachedProposal
+}
+
+// NewGroup creates a group with the key package owner as its only member.
+func NewGroup(groupID []byte, k *KeyPackage, externalSenders ...ExternalSender) (*Group, error) {
+	g := &Group{
+		tree:            &ratchetTree{},
+		signatureKey:    k.signatureKey,
+		privateKeys:     map[uint32]*ecdh.PrivateKey{0: k.encryptionKey},
+		externalSenders: externalSenders,
+	}
+	leaf := k.keyPackage.leafNode
+	g.self = g.tree.add(&leaf)
+	g.context = groupContext{
+		groupID:  groupID,
+		treeHash: g.tree.treeHash(),
+	}
+	if len(externalSenders) > 0 {
+		g.context.extensions = []extension{externalSendersExtension(externalSenders)}
+	}
+	g.secrets = newEpochSecrets(randomSecret())
+	g.interimTranscriptHash = interimTranscriptHash(nil, mac(g.secrets.confirmation, nil))
+	return g, nil
+}
+
+func (g *Group) Epoch() uint64 {
+	return g.context.epoch
+}
+
+func (g *Group) GroupID() []byte {
+	return g.context.groupID
+}
+
+// Members returns the credential identities of the members in leaf order.
+func (g *Group) Members() [][]byte {
+	var members [][]byte
+	for i := uint32(0); i < g.tree.leaves(); i++ {
+		if l := g.tree.leaf(i); l != nil {
+			members = append(members, l.credential.identity)
+		}
+	}
+	return members
+}
+
+// Export is the MLS exporter, RFC 9420 section 8.5.
+func (g *Group) Export(label string, context []byte, length int) []byte {
+	return expandWithLabel(deriveSecret(g.secrets.exporter, label), "exported", hash(context), length)
+}
+
+// AddProposal validates and caches a proposal to be committed or referenced
+// by the next commit.
+func (g *Group) AddProposal(message []byte) error {
+	m, err := parsePublicMessage(message)
+	if err != nil {
+		return err
+	}
+	if err := g.checkContent(&m.content); err != nil {
+		return err
+	}
+	if m.content.contentType != contentTypeProposal {
+		return ErrUnsupported
+	}
+	switch m.content.sender.typ {
+	case senderTypeExternal:
+		if int(m.content.sender.index) >= len(g.externalSenders) {
+			return ErrUnknownSender
+		}
+		key := g.externalSenders[m.content.sender.index].SignatureKey
+		if err := verifyWithLabel(key, "FramedContentTBS", m.content.tbs(&g.context), m.signature); err != nil {
+			return err
+		}
+	case senderTypeMember:
+		if err := g.verifyMember(m); err != nil {
+			return err
+		}
+	default:
+		return ErrUnexpectedSender
+	}
+	p := m.content.proposal
+	switch p.typ {
+	case proposalTypeAdd:
+		if err := p.keyPackage.verify(); err != nil {
+			return err
+		}
+	case proposalTypeRemove:
+		if g.tree.leaf(p.removed) == nil {
+			return ErrTree
+		}
+	}
+	g.proposals = append(g.proposals, cachedProposal{
+		ref:      refHash("MLS 1.0 Proposal Reference", m.authenticatedContent()),
+		proposal: p,
+	})
+	return nil
+}
+
+// Proposals returns the number of cached proposals.
+func (g *Group) Proposals() int {
+	return len(g.proposals)
+}
+
+// RevokeProposal drops a cached proposal.
+func (g *Group) RevokeProposal(ref []byte) {
+	g.proposals = slices.DeleteFunc(g.proposals, func(p cachedProposal) bool {
+		return bytes.Equal(p.ref, ref)
+	})
+}
+
+func (g *Group) checkContent(c *framedContent) error {
+	if !bytes.Equal(c.groupID, g.context.groupID) {
+		return ErrWrongGroup
+	}
+	if c.epoch != g.context.epoch {
+		return ErrWrongEpoch
+	}
+	return nil
+}
+
+func (g *Group) verifyMember(m *publicMessage) error {
+	if !hmac.Equal(m.membershipTag, mac(g.secrets.membership, m.membershipInput(&g.context))) {
+		return ErrMembershipTag
+	}
+	l := g.tree.leaf(m.content.sender.index)
+	if l == nil {
+		return ErrUnknownSender
+	}
+	return verifyWithLabel(l.signatureKey, "FramedContentTBS", m.content.tbs(&g.context), m.signature)
+}
+
+// apply applies removes before adds to a copy of the tree.
+func (g *Group) apply(proposals []*proposal) (*ratchetTree, []addedMember, bool, error) {
+	tree := g.tree.clone()
+	removes := false
+	for _, p := range proposals {
+		if p.typ != proposalTypeRemove {
+			continue
+		}
+		if tree.leaf(p.removed) == nil {
+			return nil, nil, false, ErrTree
+		}
+		tree.remove(p.removed)
+		removes = true
+	}
+	var added []addedMember
+	for _, p := range proposals {
+		if p.typ != proposalTypeAdd {
+			continue
+		}
+		if err := p.keyPackage.verify(); err != nil {
+			return nil, nil, false, err
+		}
+		leaf := p.keyPackage.leafNode
+		added = append(added, addedMember{leaf: tree.add(&leaf), keyPackage: &p.keyPackage})
+	}
+	return tree, added, removes, nil
+}
+
+// next returns the group of the following epoch, private keys of nodes
+// that no longer exist are dropped.
+func (g *Group) next(tree *ratchetTree, privateKeys map[uint32]*ecdh.PrivateKey) *Group {
+	n := &Group{
+		context:         g.context,
+		tree:            tree,
+		self:            g.self,
+		signatureKey:    g.signatureKey,
+		privateKeys:     privateKeys,
+		externalSenders: g.externalSenders,
+	}
+	n.context.epoch++
+	n.context.treeHash = tree.treeHash()
+	for x := range privateKeys {
+		if x >= uint32(len(tree.nodes)) || tree.nodes[x] == nil {
+			delete(privateKeys, x)
+		}
+	}
+	return n
+}
+
+// confirm adds the commit to the transcript of the next epoch.
+func (n *Group) confirm(m *publicMessage, interimTranscriptHash []byte) {
+	n.context.confirmedTranscriptHash = hash(append(slices.Clone(interimTranscriptHash), m.confirmedTranscriptInput()...))
+}
+
+// Commit commits every cached proposal and returns the commit message, a
+// welcome for added members or nil, and the group to use once the commit
+// is accepted. A path is only included when proposals require one.
+func (g *Group) Commit() (commitMessage []byte, welcomeMessage []byte, next *Group, err error) {
+	var refs []proposalOrRef
+	var proposals []*proposal
+	for _, p := range g.proposals {
+		refs = append(refs, proposalOrRef{ref: p.ref})
+		proposals = append(proposals, p.proposal)
+	}
+	tree, added, removes, err := g.apply(proposals)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, p := range proposals {
+		if p.typ == proposalTypeRemove && p.removed == g.self {
+			return nil, nil, nil, ErrRemoved
+		}
+	}
+	privateKeys := make(map[uint32]*ecdh.PrivateKey, len(g.privateKeys))
+	for x, k := range g.privateKeys {
+		privateKeys[x] = k
+	}
+
+	c := &commit{proposals: refs}
+	commitSecret := make([]byte, hashSize)
+	pathSecrets := map[uint32][]byte{}
+	if removes || len(proposals) == 0 {
+		c.path, commitSecret, err = g.newPath(tree, privateKeys, pathSecrets, added)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	n := g.next(tree, privateKeys)
+	if c.path != nil {
+		// Path secrets are encrypted to the provisional group context.
+		if err := g.encryptPath(tree, c.path, pathSecrets, added, &n.context); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	m := &publicMessage{
+		content: framedContent{
+			groupID:     g.context.groupID,
+			epoch:       g.context.epoch,
+			sender:      sender{typ: senderTypeMember, index: g.self},
+			contentType: contentTypeCommit,
+			commit:      c,
+		},
+	}
+	m.signature, err = signWithLabel(g.signatureKey, "FramedContentTBS", m.content.tbs(&g.context))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	n.confirm(m, g.interimTranscriptHash)
+	joiner := joinerSecret(g.secrets.init, commitSecret, &n.context)
+	n.secrets = epochSecretsFromJoiner(joiner, &n.context)
+	m.confirmationTag = mac(n.secrets.confirmation, n.context.confirmedTranscriptHash)
+	m.membershipTag = mac(g.secrets.membership, m.membershipInput(&g.context))
+	n.interimTranscriptHash = interimTranscriptHash(n.context.confirmedTranscriptHash, m.confirmationTag)
+
+	if len(added) > 0 {
+		welcomeMessage, err = n.welcome(joiner, m.confirmationTag, added, c.path != nil, pathSecrets)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return m.bytes(), welcomeMessage, n, nil
+}
+
+// newPath replaces the committer's leaf and direct path with fresh keys,
+// RFC 9420 section 7.5.
+func (g *Group) newPath(tree *ratchetTree, privateKeys map[uint32]*ecdh.PrivateKey, pathSecrets map[uint32][]byte, added []addedMember) (*updatePath, []byte, error) {
+	encryptionKey, err := generateKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	leaf := *tree.leaf(g.self)
+	leaf.encryptionKey = encryptionKey.PublicKey().Bytes()
+	leaf.source = leafNodeSourceCommit
+	leaf.notBefore, leaf.notAfter = 0, 0
+	privateKeys[2*g.self] = encryptionKey
+	for _, x := range directPath(2*g.self, tree.leaves()) {
+		tree.nodes[x] = nil
+		delete(privateKeys, x)
+	}
+
+	path := &updatePath{}
+	pathSecret := randomSecret()
+	for _, x := range tree.filteredDirectPath(g.self) {
+		key, err := nodeKeyPair(pathSecret)
+		if err != nil {
+			return nil, nil, err
+		}
+		tree.nodes[x] = &treeNode{parent: &parentNode{encryptionKey: key.PublicKey().Bytes()}}
+		privateKeys[x] = key
+		pathSecrets[x] = pathSecret
+		path.nodes = append(path.nodes, updatePathNode{encryptionKey: key.PublicKey().Bytes()})
+		pathSecret = deriveSecret(pathSecret, "path")
+	}
+	leaf.parentHash = tree.setPathParentHashes(g.self)
+	if err := leaf.sign(g.signatureKey, g.context.groupID, g.self); err != nil {
+		return nil, nil, err
+	}
+	tree.nodes[2*g.self] = &treeNode{leaf: &leaf}
+	path.leafNode = leaf
+	return path, pathSecret, nil
+}
+
+func (g *Group) encryptPath(tree *ratchetTree, path *updatePath, pathSecrets map[uint32][]byte, added []addedMember, context *groupContext) error {
+	for i, x := range tree.filteredDirectPath(g.self) {
+		for _, r := range pathRecipients(tree, x, g.self, added) {
+			c, err := encryptWithLabel(tree.nodeEncryptionKey(r), "UpdatePathNode", context.bytes(), pathSecrets[x])
+			if err != nil {
+				return err
+			}
+			path.nodes[i].encryptedPathSecret = append(path.nodes[i].encryptedPathSecret, c)
+		}
+	}
+	return nil
+}
+
+// pathRecipients is the resolution of the copath child of x, members added
+// by the commit get the path secret from the welcome instead.
+func pathRecipients(tree *ratchetTree, x uint32, committer uint32, added []addedMember) []uint32 {
+	return slices.DeleteFunc(tree.resolution(copathChild(x, committer)), func(r uint32) bool {
+		return slices.ContainsFunc(added, func(a addedMember) bool {
+			return 2*a.leaf == r
+		})
+	})
+}
+
+func (t *ratchetTree) nodeEncryptionKey(x uint32) []byte {
+	n := t.nodes[x]
+	if n.leaf != nil {
+		return n.leaf.encryptionKey
+	}
+	return n.parent.encryptionKey
+}
+
+func (g *Group) welcome(joiner []byte, confirmationTag []byte, added []addedMember, path bool, pathSecrets map[uint32][]byte) ([]byte, error) {
+	info := &groupInfo{
+		context:         g.context,
+		extensions:      []extension{{typ: extensionTypeRatchetTree, data: g.tree.bytes()}},
+		confirmationTag: confirmationTag,
+		signer:          g.self,
+	}
+	var err error
+	info.signature, err = signWithLabel(g.signatureKey, "GroupInfoTBS", info.tbs())
+	if err != nil {
+		return nil, err
+	}
+	w := &writer{}
+	info.marshal(w)
+	key, nonce := welcomeKey(joiner)
+	encryptedGroupInfo, err := seal(key, nonce, nil, w.b)
+	if err != nil {
+		return nil, err
+	}
+	m := &welcome{encryptedGroupInfo: encryptedGroupInfo}
+	for _, a := range added {
+		secrets := groupSecrets{joinerSecret: joiner}
+		if path {
+			// The lowest node of the committer's path above the new member.
+			for _, x := range g.tree.filteredDirectPath(g.self) {
+				if inSubtree(x, 2*a.leaf) {
+					secrets.pathSecret = pathSecrets[x]
+					break
+				}
+			}
+		}
+		w := &writer{}
+		secrets.marshal(w)
+		c, err := encryptWithLabel(a.keyPackage.initKey, "Welcome", encryptedGroupInfo, w.b)
+		if err != nil {
+			return nil, err
+		}
+		m.secrets = append(m.secrets, encryptedGroupSecrets{newMember: a.keyPackage.ref(), secrets: c})
+	}
+	return m.bytes(), nil
+}
+
+// ProcessCommit applies a commit sent by another member and returns the
+// group of the next epoch. Referenced proposals must have been added.
+func (g *Group) ProcessCommit(message []byte) (*Group, error) {
+	m, err := parsePublicMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.checkContent(&m.content); err != nil {
+		return nil, err
+	}
+	if m.content.contentType != contentTypeCommit {
+		return nil, ErrUnsupported
+	}
+	if m.content.sender.typ != senderTypeMember {
+		return nil, ErrUnexpectedSender
+	}
+	if err := g.verifyMember(m); err != nil {
+		return nil, err
+	}
+	committer := m.content.sender.index
+	if committer == g.self {
+		return nil, ErrOwnCommit
+	}
+	c := m.content.commit
+	var proposals []*proposal
+	for _, p := range c.proposals {
+		if p.proposal != nil {
+			proposals = append(proposals, p.proposal)
+			continue
+		}
+		i := slices.IndexFunc(g.proposals, func(cached cachedProposal) bool {
+			return bytes.Equal(cached.ref, p.ref)
+		})
+		if i < 0 {
+			return nil, ErrUnknownProposal
+		}
+		proposals = append(proposals, g.proposals[i].proposal)
+	}
+	tree, added, removes, err := g.apply(proposals)
+	if err != nil {
+		return nil, err
+	}
+	if tree.leaf(g.self) == nil {
+		return nil, ErrRemoved
+	}
+	if tree.leaf(committer) == nil {
+		return nil, ErrUnknownSender
+	}
+	if (removes || len(proposals) == 0) && c.path == nil {
+		return nil, ErrPath
+	}
+	privateKeys := make(map[uint32]*ecdh.PrivateKey, len(g.privateKeys))
+	for x, k := range g.privateKeys {
+		privateKeys[x] = k
+	}
+	commitSecret := make([]byte, hashSize)
+	n := g.next(tree, privateKeys)
+	if c.path != nil {
+		if err := g.applyPath(tree, committer, c.path, privateKeys); err != nil {
+			return nil, err
+		}
+		n.context.treeHash = tree.treeHash()
+		commitSecret, err = g.decryptPath(tree, committer, c.path, privateKeys, added, &n.context)
+		if err != nil {
+			return nil, err
+		}
+	}
+	n.confirm(m, g.interimTranscriptHash)
+	n.secrets = epochSecretsFromJoiner(joinerSecret(g.secrets.init, commitSecret, &n.context), &n.context)
+	if !hmac.Equal(m.confirmationTag, mac(n.secrets.confirmation, n.context.confirmedTranscriptHash)) {
+		return nil, ErrConfirmationTag
+	}
+	n.interimTranscriptHash = interimTranscriptHash(n.context.confirmedTranscriptHash, m.confirmationTag)
+	return n, nil
+}
+
+// applyPath installs the committer's new leaf and path public keys.
+func (g *Group) applyPath(tree *ratchetTree, committer uint32, path *updatePath, privateKeys map[uint32]*ecdh.PrivateKey) error {
+	leaf := path.leafNode
+	if leaf.source != leafNodeSourceCommit {
+		return ErrPath
+	}
+	if err := leaf.verify(g.context.groupID, committer); err != nil {
+		return err
+	}
+	for _, x := range directPath(2*committer, tree.leaves()) {
+		tree.nodes[x] = nil
+		delete(privateKeys, x)
+	}
+	fdp := tree.filteredDirectPath(committer)
+	if len(fdp) != len(path.nodes) {
+		return ErrPath
+	}
+	for i, x := range fdp {
+		tree.nodes[x] = &treeNode{parent: &parentNode{encryptionKey: path.nodes[i].encryptionKey}}
+	}
+	if !bytes.Equal(tree.setPathParentHashes(committer), leaf.parentHash) {
+		return ErrPath
+	}
+	tree.nodes[2*committer] = &treeNode{leaf: &leaf}
+	return nil
+}
+
+// decryptPath decrypts the path secret of the lowest common ancestor and
+// derives the keys above it, it returns the commit secret.
+func (g *Group) decryptPath(tree *ratchetTree, committer uint32, path *updatePath, privateKeys map[uint32]*ecdh.PrivateKey, added []addedMember, context *groupContext) ([]byte, error) {
+	fdp := tree.filteredDirectPath(committer)
+	i := slices.IndexFunc(fdp, func(x uint32) bool {
+		return inSubtree(x, 2*g.self)
+	})
+	if i < 0 {
+		return nil, ErrPath
+	}
+	recipients := pathRecipients(tree, fdp[i], committer, added)
+	j := slices.IndexFunc(recipients, func(r uint32) bool {
+		return privateKeys[r] != nil
+	})
+	if j < 0 || j >= len(path.nodes[i].encryptedPathSecret) {
+		return nil, ErrPath
+	}
+	pathSecret, err := decryptWithLabel(privateKeys[recipients[j]], "UpdatePathNode", context.bytes(), path.nodes[i].encryptedPathSecret[j])
+	if err != nil {
+		return nil, err
+	}
+	return implantPath(tree, fdp[i:], pathSecret, privateKeys)
+}
+
+// implantPath derives the private keys of path from the secret of its first
+// node and checks them against the tree.
+func implantPath(tree *ratchetTree, path []uint32, pathSecret []byte, privateKeys map[uint32]*ecdh.PrivateKey) ([]byte, error) {
+	for _, x := range path {
+		key, err := nodeKeyPair(pathSecret)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(key.PublicKey().Bytes(), tree.nodeEncryptionKey(x)) {
+			return nil, ErrPath
+		}
+		privateKeys[x] = key
+		pathSecret = deriveSecret(pathSecret, "path")
+	}
+	return pathSecret, nil
+}
+
+// Join joins a group from a welcome addressed to the key package.
+func Join(message []byte, k *KeyPackage) (*Group, error) {
+	m, err := parseWelcome(message)
+	if err != nil {
+		return nil, err
+	}
+	ref := k.Ref()
+	i := slices.IndexFunc(m.secrets, func(s encryptedGroupSecrets) bool {
+		return bytes.Equal(s.newMember, ref)
+	})
+	if i < 0 {
+		return nil, ErrNotInvited
+	}
+	plaintext, err := decryptWithLabel(k.initKey, "Welcome", m.encryptedGroupInfo, m.secrets[i].secrets)
+	if err != nil {
+		return nil, err
+	}
+	secrets := &groupSecrets{}
+	r := newReader(plaintext)
+	secrets.unmarshal(r)
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	key, nonce := welcomeKey(secrets.joinerSecret)
+	plaintext, err = open(key, nonce, nil, m.encryptedGroupInfo)
+	if err != nil {
+		return nil, err
+	}
+	info := &groupInfo{}
+	r = newReader(plaintext)
+	info.unmarshal(r)
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+
+	data, ok := findExtension(info.extensions, extensionTypeRatchetTree)
+	if !ok {
+		return nil, ErrTree
+	}
+	tree, err := parseRatchetTree(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyTree(tree, info); err != nil {
+		return nil, err
+	}
+	signer := tree.leaf(info.signer)
+	if signer == nil {
+		return nil, ErrUnknownSender
+	}
+	if err := verifyWithLabel(signer.signatureKey, "GroupInfoTBS", info.tbs(), info.signature); err != nil {
+		return nil, err
+	}
+	self, ok := tree.find(&k.keyPackage.leafNode)
+	if !ok {
+		return nil, ErrNotInvited
+	}
+
+	g := &Group{
+		context:      info.context,
+		tree:         tree,
+		self:         self,
+		signatureKey: k.signatureKey,
+		privateKeys:  map[uint32]*ecdh.PrivateKey{2 * self: k.encryptionKey},
+	}
+	if data, ok := findExtension(info.context.extensions, extensionTypeExternalSenders); ok {
+		if g.externalSenders, err = parseExternalSenders(data); err != nil {
+			return nil, err
+		}
+	}
+	if secrets.pathSecret != nil {
+		fdp := tree.filteredDirectPath(info.signer)
+		i := slices.IndexFunc(fdp, func(x uint32) bool {
+			return inSubtree(x, 2*self)
+		})
+		if i < 0 {
+			return nil, ErrPath
+		}
+		if _, err := implantPath(tree, fdp[i:], secrets.pathSecret, g.privateKeys); err != nil {
+			return nil, err
+		}
+	}
+	g.secrets = epochSecretsFromJoiner(secrets.joinerSecret, &g.context)
+	if !hmac.Equal(info.confirmationTag, mac(g.secrets.confirmation, g.context.confirmedTranscriptHash)) {
+		return nil, ErrConfirmationTag
+	}
+	g.interimTranscriptHash = interimTranscriptHash(g.context.confirmedTranscriptHash, info.confirmationTag)
+	return g, nil
+}
+
+func verifyTree(tree *ratchetTree, info *groupInfo) error {
+	if !bytes.Equal(tree.treeHash(), info.context.treeHash) {
+		return ErrTree
+	}
+	if err := tree.verifyParentHashes(); err != nil {
+		return err
+	}
+	for i := uint32(0); i < tree.leaves(); i++ {
+		if l := tree.leaf(i); l != nil {
+			if err := l.verify(info.context.groupID, i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package mls
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var testGroupID = []byte{0, 0, 0, 0, 0, 0, 0, 42}
+
+type member struct {
+	name       string
+	keyPackage *KeyPackage
+	group      *Group
+}
+
+func newMember(t *testing.T, name string) *member {
+	t.Helper()
+	k, err := NewKeyPackage([]byte(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &member{name: name, keyPackage: k}
+}
+
+// propose delivers the proposals to every member of the group.
+func propose(t *testing.T, members []*member, proposals ...[]byte) {
+	t.Helper()
+	for _, m := range members {
+		for _, p := range proposals {
+			if err := m.group.AddProposal(p); err != nil {
+				t.Fatalf("%s: add proposal: %v", m.name, err)
+			}
+		}
+	}
+}
+
+// commitAll has the committer commit, the others process it and the joiners
+// join with the welcome.
+func commitAll(t *testing.T, committer *member, others []*member, joiners ...*member) {
+	t.Helper()
+	c, w, next, err := committer.group.Commit()
+	if err != nil {
+		t.Fatalf("%s: commit: %v", committer.name, err)
+	}
+	if _, err := committer.group.ProcessCommit(c); !errors.Is(err, ErrOwnCommit) {
+		t.Fatalf("%s: processing own commit: got %v, want %v", committer.name, err, ErrOwnCommit)
+	}
+	committer.group = next
+	for _, m := range others {
+		next, err := m.group.ProcessCommit(c)
+		if err != nil {
+			t.Fatalf("%s: process commit: %v", m.name, err)
+		}
+		m.group = next
+	}
+	if len(joiners) > 0 && w == nil {
+		t.Fatal("no welcome for added members")
+	}
+	for _, m := range joiners {
+		g, err := Join(w, m.keyPackage)
+		if err != nil {
+			t.Fatalf("%s: join: %v", m.name, err)
+		}
+		m.group = g
+	}
+}
+
+func assertInSync(t *testing.T, members ...*member) {
+	t.Helper()
+	want := members[0].group
+	for _, m := range members[1:] {
+		if m.group.Epoch() != want.Epoch() {
+			t.Fatalf("%s is at epoch %d, want %d", m.name, m.group.Epoch(), want.Epoch())
+		}
+		if !bytes.Equal(m.group.Export("test", []byte("context"), 16), want.Export("test", []byte("context"), 16)) {
+			t.Fatalf("%s exports a different secret", m.name)
+		}
+		got, expected := m.group.Members(), want.Members()
+		if len(got) != len(expected) {
+			t.Fatalf("%s has %d members, want %d", m.name, len(got), len(expected))
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], expected[i]) {
+				t.Fatalf("%s member %d is %s, want %s", m.name, i, got[i], expected[i])
+			}
+		}
+	}
+}
+
+func proposeAdd(t *testing.T, server *ExternalSigner, g *Group, m *member) []byte {
+	t.Helper()
+	p, err := server.ProposeAdd(g.GroupID(), g.Epoch(), m.keyPackage.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func proposeRemove(t *testing.T, server *ExternalSigner, g *Group, leaf uint32) []byte {
+	t.Helper()
+	p, err := server.ProposeRemove(g.GroupID(), g.Epoch(), leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestGroupLifecycle(t *testing.T) {
+	server, err := NewExternalSigner([]byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob, carol, dave, erin := newMember(t, "alice"), newMember(t, "bob"), newMember(t, "carol"), newMember(t, "dave"), newMember(t, "erin")
+	alice.group, err = NewGroup(testGroupID, alice.keyPackage, server.Sender())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Adds are committed without a path.
+	propose(t, []*member{alice}, proposeAdd(t, server, alice.group, bob))
+	commitAll(t, alice, nil, bob)
+	assertInSync(t, alice, bob)
+
+	propose(t, []*member{alice, bob},
+		proposeAdd(t, server, alice.group, carol),
+		proposeAdd(t, server, alice.group, dave))
+	commitAll(t, bob, []*member{alice}, carol, dave)
+	assertInSync(t, alice, bob, carol, dave)
+
+	// Removes need a path, the removed member can not follow.
+	remove := proposeRemove(t, server, alice.group, 1)
+	propose(t, []*member{alice, bob, carol, dave}, remove)
+	c, _, next, err := carol.group.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.group.ProcessCommit(c); !errors.Is(err, ErrRemoved) {
+		t.Fatalf("removed member: got %v, want %v", err, ErrRemoved)
+	}
+	for _, m := range []*member{alice, dave} {
+		if m.group, err = m.group.ProcessCommit(c); err != nil {
+			t.Fatalf("%s: %v", m.name, err)
+		}
+	}
+	carol.group = next
+	assertInSync(t, alice, carol, dave)
+
+	// A path and an add, the joiner gets its path secret in the welcome.
+	propose(t, []*member{alice, carol, dave},
+		proposeRemove(t, server, alice.group, 3),
+		proposeAdd(t, server, alice.group, erin))
+	commitAll(t, alice, []*member{carol}, erin)
+	assertInSync(t, alice, carol, erin)
+
+	// An empty commit rotates the committer's path.
+	commitAll(t, erin, []*member{alice, carol})
+	assertInSync(t, alice, carol, erin)
+	commitAll(t, carol, []*member{alice, erin})
+	assertInSync(t, alice, carol, erin)
+}
+
+func TestGroupRevokeProposal(t *testing.T) {
+	server, err := NewExternalSigner([]byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := newMember(t, "alice"), newMember(t, "bob")
+	alice.group, err = NewGroup(testGroupID, alice.keyPackage, server.Sender())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := proposeAdd(t, server, alice.group, bob)
+	propose(t, []*member{alice}, p)
+	ref, err := ProposalRef(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice.group.RevokeProposal(ref)
+	_, w, _, err := alice.group.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != nil {
+		t.Fatal("revoked add was committed")
+	}
+}
+
+func TestGroupRejectsProposals(t *testing.T) {
+	server, err := NewExternalSigner([]byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor, err := NewExternalSigner([]byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := newMember(t, "alice"), newMember(t, "bob")
+	alice.group, err = NewGroup(testGroupID, alice.keyPackage, server.Sender())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := server.ProposeAdd(testGroupID, 1, bob.keyPackage.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.group.AddProposal(stale); !errors.Is(err, ErrWrongEpoch) {
+		t.Fatalf("other epoch: got %v, want %v", err, ErrWrongEpoch)
+	}
+	other, err := server.ProposeAdd([]byte("other"), 0, bob.keyPackage.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.group.AddProposal(other); !errors.Is(err, ErrWrongGroup) {
+		t.Fatalf("other group: got %v, want %v", err, ErrWrongGroup)
+	}
+	if err := alice.group.AddProposal(proposeAdd(t, impostor, alice.group, bob)); !errors.Is(err, ErrSignature) {
+		t.Fatalf("impostor: got %v, want %v", err, ErrSignature)
+	}
+}
+
+func TestGroupRejectsTamperedCommit(t *testing.T) {
+	server, err := NewExternalSigner([]byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob, carol := newMember(t, "alice"), newMember(t, "bob"), newMember(t, "carol")
+	alice.group, err = NewGroup(testGroupID, alice.keyPackage, server.Sender())
+	if err != nil {
+		t.Fatal(err)
+	}
+	propose(t, []*member{alice}, proposeAdd(t, server, alice.group, bob))
+	commitAll(t, alice, nil, bob)
+	propose(t, []*member{alice, bob}, proposeAdd(t, server, alice.group, carol))
+	c, _, _, err := alice.group.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c[len(c)-1] ^= 1
+	if _, err := bob.group.ProcessCommit(c); !errors.Is(err, ErrMembershipTag) {
+		t.Fatalf("got %v, want %v", err, ErrMembershipTag)
+	}
+}
+
+func TestJoinOtherKeyPackage(t *testing.T) {
+	server, err := NewExternalSigner([]byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob, carol := newMember(t, "alice"), newMember(t, "bob"), newMember(t, "carol")
+	alice.group, err = NewGroup(testGroupID, alice.keyPackage, server.Sender())
+	if err != nil {
+		t.Fatal(err)
+	}
+	propose(t, []*member{alice}, proposeAdd(t, server, alice.group, bob))
+	_, w, _, err := alice.group.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Join(w, carol.keyPackage); !errors.Is(err, ErrNotInvited) {
+		t.Fatalf("got %v, want %v", err, ErrNotInvited)
+	}
+	// Welcomes are also accepted wrapped in an MLSMessage.
+	wrapped := append([]byte{0, 1, 0, 3}, w...)
+	if _, err := Join(wrapped, bob.keyPackage); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,187 @@
+package mls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// HPKE base mode with DHKEM(P-256, HKDF-SHA256), HKDF-SHA256 and
+// AES-128-GCM, RFC 9180.
+const (
+	kemP256       uint16 = 0x0010
+	kdfHKDFSHA256 uint16 = 0x0001
+	aeadAES128GCM uint16 = 0x0001
+
+	hpkeModeBase = 0x00
+
+	hpkeNsecret = 32
+	hpkeNsk     = 32
+	hpkeNk      = 16
+	hpkeNn      = 12
+)
+
+var ErrDeriveKeyPair = errors.New("hpke: cannot derive key pair")
+
+var (
+	kemSuiteID  = binary.BigEndian.AppendUint16([]byte("KEM"), kemP256)
+	hpkeSuiteID = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16([]byte("HPKE"), kemP256), kdfHKDFSHA256), aeadAES128GCM)
+)
+
+func labeledExtract(suiteID []byte, salt []byte, label string, ikm []byte) []byte {
+	labeled := append([]byte("HPKE-v1"), suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, ikm...)
+	return hkdf.Extract(sha256.New, labeled, salt)
+}
+
+func labeledExpand(suiteID []byte, prk []byte, label string, info []byte, length int) []byte {
+	labeled := binary.BigEndian.AppendUint16(nil, uint16(length))
+	labeled = append(labeled, "HPKE-v1"...)
+	labeled = append(labeled, suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, info...)
+	out := make([]byte, length)
+	// Lengths are far below the HKDF limit.
+	io.ReadFull(hkdf.Expand(sha256.New, prk, labeled), out)
+	return out
+}
+
+// deriveKeyPair is DHKEM DeriveKeyPair, section 7.1.3.
+func deriveKeyPair(ikm []byte) (*ecdh.PrivateKey, error) {
+	prk := labeledExtract(kemSuiteID, nil, "dkp_prk", ikm)
+	for counter := 0; counter < 256; counter++ {
+		candidate := labeledExpand(kemSuiteID, prk, "candidate", []byte{byte(counter)}, hpkeNsk)
+		// NewPrivateKey rejects zero and values above the order.
+		if key, err := ecdh.P256().NewPrivateKey(candidate); err == nil {
+			return key, nil
+		}
+	}
+	return nil, ErrDeriveKeyPair
+}
+
+func generateKeyPair() (*ecdh.PrivateKey, error) {
+	return ecdh.P256().GenerateKey(rand.Reader)
+}
+
+func extractAndExpand(dh []byte, kemContext []byte) []byte {
+	prk := labeledExtract(kemSuiteID, nil, "eae_prk", dh)
+	return labeledExpand(kemSuiteID, prk, "shared_secret", kemContext, hpkeNsecret)
+}
+
+func encap(ephemeral *ecdh.PrivateKey, pkR *ecdh.PublicKey) (sharedSecret []byte, enc []byte, err error) {
+	dh, err := ephemeral.ECDH(pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc = ephemeral.PublicKey().Bytes()
+	kemContext := append(append([]byte{}, enc...), pkR.Bytes()...)
+	return extractAndExpand(dh, kemContext), enc, nil
+}
+
+func decap(enc []byte, skR *ecdh.PrivateKey) ([]byte, error) {
+	pkE, err := ecdh.P256().NewPublicKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	dh, err := skR.ECDH(pkE)
+	if err != nil {
+		return nil, err
+	}
+	kemContext := append(append([]byte{}, enc...), skR.PublicKey().Bytes()...)
+	return extractAndExpand(dh, kemContext), nil
+}
+
+// hpkeContext seals or opens messages in order.
+type hpkeContext struct {
+	aead      cipher.AEAD
+	baseNonce []byte
+	seq       uint64
+}
+
+func keySchedule(sharedSecret []byte, info []byte) (*hpkeContext, error) {
+	pskIDHash := labeledExtract(hpkeSuiteID, nil, "psk_id_hash", nil)
+	infoHash := labeledExtract(hpkeSuiteID, nil, "info_hash", info)
+	context := append([]byte{hpkeModeBase}, pskIDHash...)
+	context = append(context, infoHash...)
+	secret := labeledExtract(hpkeSuiteID, sharedSecret, "secret", nil)
+	key := labeledExpand(hpkeSuiteID, secret, "key", context, hpkeNk)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &hpkeContext{
+		aead:      aead,
+		baseNonce: labeledExpand(hpkeSuiteID, secret, "base_nonce", context, hpkeNn),
+	}, nil
+}
+
+func (c *hpkeContext) nonce() []byte {
+	nonce := append([]byte{}, c.baseNonce...)
+	seq := binary.BigEndian.AppendUint64(nil, c.seq)
+	for i := range seq {
+		nonce[len(nonce)-len(seq)+i] ^= seq[i]
+	}
+	c.seq++
+	return nonce
+}
+
+func (c *hpkeContext) seal(aad, plaintext []byte) []byte {
+	return c.aead.Seal(nil, c.nonce(), plaintext, aad)
+}
+
+func (c *hpkeContext) open(aad, ciphertext []byte) ([]byte, error) {
+	return c.aead.Open(nil, c.nonce(), ciphertext, aad)
+}
+
+func setupBaseS(ephemeral *ecdh.PrivateKey, pkR *ecdh.PublicKey, info []byte) ([]byte, *hpkeContext, error) {
+	sharedSecret, enc, err := encap(ephemeral, pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := keySchedule(sharedSecret, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, c, nil
+}
+
+func setupBaseR(enc []byte, skR *ecdh.PrivateKey, info []byte) (*hpkeContext, error) {
+	sharedSecret, err := decap(enc, skR)
+	if err != nil {
+		return nil, err
+	}
+	return keySchedule(sharedSecret, info)
+}
+
+// sealBase encrypts a single message to pkR.
+func sealBase(pkR *ecdh.PublicKey, info, aad, plaintext []byte) (enc []byte, ciphertext []byte, err error) {
+	ephemeral, err := generateKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	enc, c, err := setupBaseS(ephemeral, pkR, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, c.seal(aad, plaintext), nil
+}
+
+func openBase(enc []byte, skR *ecdh.PrivateKey, info, aad, ciphertext []byte) ([]byte, error) {
+	c, err := setupBaseR(enc, skR, info)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(aad, ciphertext)
+}
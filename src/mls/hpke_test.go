@@ -0,0 +1,125 @@
+package mls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 9180 test vector for mode base, DHKEM(P-256, HKDF-SHA256),
+// HKDF-SHA256 and AES-128-GCM, encryptions accumulated as in the CFRG
+// vectors repository.
+var hpkeVector = struct {
+	info, ikmE, ikmR, skRm, pkRm, enc string
+	encryptionsAccumulated            string
+}{
+	info:                   "4f6465206f6e2061204772656369616e2055726e",
+	ikmE:                   "4270e54ffd08d79d5928020af4686d8f6b7d35dbe470265f1f5aa22816ce860e",
+	ikmR:                   "668b37171f1072f3cf12ea8a236a45df23fc13b82af3609ad1e354f6ef817550",
+	skRm:                   "f3ce7fdae57e1a310d87f1ebbde6f328be0a99cdbcadf4d6589cf29de4b8ffd2",
+	pkRm:                   "04fe8c19ce0905191ebc298a9245792531f26f0cece2460639e8bc39cb7f706a826a779b4cf969b8a0e539c7f62fb3d30ad6aa8f80e30f1d128aafd68a2ce72ea0",
+	enc:                    "04a92719c6195d5085104f469a8b9814d5838ff72b60501e2c4466e5e67b325ac98536d7b61a1af4b78e5b7f951c0900be863c403ce65c9bfcb9382657222d18c4",
+	encryptionsAccumulated: "fcb852ae6a1e19e874fbd18a199df3e4",
+}
+
+func drawRandomInput(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+	l := make([]byte, 1)
+	if _, err := r.Read(l); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, int(l[0]))
+	if _, err := r.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestHPKEDeriveKeyPair(t *testing.T) {
+	v := hpkeVector
+	skR, err := deriveKeyPair(mustHex(t, v.ikmR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(skR.Bytes(), mustHex(t, v.skRm)) {
+		t.Fatalf("skR %x, want %s", skR.Bytes(), v.skRm)
+	}
+	if !bytes.Equal(skR.PublicKey().Bytes(), mustHex(t, v.pkRm)) {
+		t.Fatalf("pkR %x, want %s", skR.PublicKey().Bytes(), v.pkRm)
+	}
+}
+
+func TestHPKEVector(t *testing.T) {
+	v := hpkeVector
+	skE, err := deriveKeyPair(mustHex(t, v.ikmE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	skR, err := deriveKeyPair(mustHex(t, v.ikmR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := mustHex(t, v.info)
+	enc, sender, err := setupBaseS(skE, skR.PublicKey(), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(enc, mustHex(t, v.enc)) {
+		t.Fatalf("enc %x, want %s", enc, v.enc)
+	}
+	recipient, err := setupBaseR(enc, skR, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, sink := sha3.NewShake128(), sha3.NewShake128()
+	for range 1000 {
+		aad, plaintext := drawRandomInput(t, source), drawRandomInput(t, source)
+		ciphertext := sender.seal(aad, plaintext)
+		sink.Write(ciphertext)
+		got, err := recipient.open(aad, ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("opened %x, want %x", got, plaintext)
+		}
+	}
+	accumulated := make([]byte, 16)
+	sink.Read(accumulated)
+	if !bytes.Equal(accumulated, mustHex(t, v.encryptionsAccumulated)) {
+		t.Fatalf("accumulated encryptions %x, want %s", accumulated, v.encryptionsAccumulated)
+	}
+}
+
+func TestHPKESingleShot(t *testing.T) {
+	skR, err := generateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, ciphertext, err := sealBase(skR.PublicKey(), []byte("info"), []byte("aad"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := openBase(enc, skR, []byte("info"), []byte("aad"), ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Fatalf("opened %q", plaintext)
+	}
+	if _, err := openBase(enc, skR, []byte("other"), []byte("aad"), ciphertext); err == nil {
+		t.Fatal("opened with the wrong info")
+	}
+}
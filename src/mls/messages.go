@@ -0,0 +1,752 @@
+package mls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+)
+
+// Wire formats, RFC 9420 section 6.
+const (
+	wireFormatPublicMessage uint16 = 1
+	wireFormatWelcome       uint16 = 3
+
+	credentialTypeBasic uint16 = 1
+
+	extensionTypeRatchetTree     uint16 = 2
+	extensionTypeExternalSenders uint16 = 5
+
+	leafNodeSourceKeyPackage uint8 = 1
+	leafNodeSourceUpdate     uint8 = 2
+	leafNodeSourceCommit     uint8 = 3
+
+	senderTypeMember   uint8 = 1
+	senderTypeExternal uint8 = 2
+
+	contentTypeProposal uint8 = 2
+	contentTypeCommit   uint8 = 3
+
+	proposalTypeAdd    uint16 = 1
+	proposalTypeRemove uint16 = 3
+
+	proposalOrRefProposal  uint8 = 1
+	proposalOrRefReference uint8 = 2
+
+	nodeTypeLeaf   uint8 = 1
+	nodeTypeParent uint8 = 2
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported mls message")
+	ErrWireFormat  = errors.New("unexpected mls wire format")
+)
+
+type credential struct {
+	identity []byte
+}
+
+func (c *credential) marshal(w *writer) {
+	w.u16(credentialTypeBasic)
+	w.opaque(c.identity)
+}
+
+func (c *credential) unmarshal(r *reader) {
+	if r.u16() != credentialTypeBasic && r.err == nil {
+		r.err = ErrUnsupported
+	}
+	c.identity = r.opaque()
+}
+
+type extension struct {
+	typ  uint16
+	data []byte
+}
+
+func marshalExtensions(w *writer, extensions []extension) {
+	w.vector(func(w *writer) {
+		for _, e := range extensions {
+			w.u16(e.typ)
+			w.opaque(e.data)
+		}
+	})
+}
+
+func unmarshalExtensions(r *reader) []extension {
+	var extensions []extension
+	r.vector(func(r *reader) {
+		extensions = append(extensions, extension{typ: r.u16(), data: r.opaque()})
+	})
+	return extensions
+}
+
+func findExtension(extensions []extension, typ uint16) ([]byte, bool) {
+	for _, e := range extensions {
+		if e.typ == typ {
+			return e.data, true
+		}
+	}
+	return nil, false
+}
+
+func marshalUint16s(w *writer, v []uint16) {
+	w.vector(func(w *writer) {
+		for _, x := range v {
+			w.u16(x)
+		}
+	})
+}
+
+func unmarshalUint16s(r *reader) []uint16 {
+	var v []uint16
+	r.vector(func(r *reader) {
+		v = append(v, r.u16())
+	})
+	return v
+}
+
+type capabilities struct {
+	versions     []uint16
+	cipherSuites []uint16
+	extensions   []uint16
+	proposals    []uint16
+	credentials  []uint16
+}
+
+func (c *capabilities) marshal(w *writer) {
+	marshalUint16s(w, c.versions)
+	marshalUint16s(w, c.cipherSuites)
+	marshalUint16s(w, c.extensions)
+	marshalUint16s(w, c.proposals)
+	marshalUint16s(w, c.credentials)
+}
+
+func (c *capabilities) unmarshal(r *reader) {
+	c.versions = unmarshalUint16s(r)
+	c.cipherSuites = unmarshalUint16s(r)
+	c.extensions = unmarshalUint16s(r)
+	c.proposals = unmarshalUint16s(r)
+	c.credentials = unmarshalUint16s(r)
+}
+
+type leafNode struct {
+	encryptionKey []byte
+	signatureKey  []byte
+	credential    credential
+	capabilities  capabilities
+	source        uint8
+	notBefore     uint64 // key package source
+	notAfter      uint64
+	parentHash    []byte // commit source
+	extensions    []extension
+	signature     []byte
+}
+
+func (l *leafNode) marshalContent(w *writer) {
+	w.opaque(l.encryptionKey)
+	w.opaque(l.signatureKey)
+	l.credential.marshal(w)
+	l.capabilities.marshal(w)
+	w.u8(l.source)
+	switch l.source {
+	case leafNodeSourceKeyPackage:
+		w.u64(l.notBefore)
+		w.u64(l.notAfter)
+	case leafNodeSourceCommit:
+		w.opaque(l.parentHash)
+	}
+	marshalExtensions(w, l.extensions)
+}
+
+func (l *leafNode) marshal(w *writer) {
+	l.marshalContent(w)
+	w.opaque(l.signature)
+}
+
+func (l *leafNode) unmarshal(r *reader) {
+	l.encryptionKey = r.opaque()
+	l.signatureKey = r.opaque()
+	l.credential.unmarshal(r)
+	l.capabilities.unmarshal(r)
+	l.source = r.u8()
+	switch l.source {
+	case leafNodeSourceKeyPackage:
+		l.notBefore = r.u64()
+		l.notAfter = r.u64()
+	case leafNodeSourceUpdate:
+	case leafNodeSourceCommit:
+		l.parentHash = r.opaque()
+	default:
+		if r.err == nil {
+			r.err = ErrMalformed
+		}
+	}
+	l.extensions = unmarshalExtensions(r)
+	l.signature = r.opaque()
+}
+
+// tbs is LeafNodeTBS, leaves in a group are bound to its id and position.
+func (l *leafNode) tbs(groupID []byte, leafIndex uint32) []byte {
+	w := &writer{}
+	l.marshalContent(w)
+	if l.source != leafNodeSourceKeyPackage {
+		w.opaque(groupID)
+		w.u32(leafIndex)
+	}
+	return w.b
+}
+
+func (l *leafNode) sign(key *ecdsa.PrivateKey, groupID []byte, leafIndex uint32) error {
+	signature, err := signWithLabel(key, "LeafNodeTBS", l.tbs(groupID, leafIndex))
+	l.signature = signature
+	return err
+}
+
+func (l *leafNode) verify(groupID []byte, leafIndex uint32) error {
+	return verifyWithLabel(l.signatureKey, "LeafNodeTBS", l.tbs(groupID, leafIndex), l.signature)
+}
+
+func (l *leafNode) bytes() []byte {
+	w := &writer{}
+	l.marshal(w)
+	return w.b
+}
+
+type keyPackage struct {
+	initKey    []byte
+	leafNode   leafNode
+	extensions []extension
+	signature  []byte
+}
+
+func (k *keyPackage) marshalContent(w *writer) {
+	w.u16(ProtocolVersion)
+	w.u16(CipherSuite)
+	w.opaque(k.initKey)
+	k.leafNode.marshal(w)
+	marshalExtensions(w, k.extensions)
+}
+
+func (k *keyPackage) marshal(w *writer) {
+	k.marshalContent(w)
+	w.opaque(k.signature)
+}
+
+func (k *keyPackage) unmarshal(r *reader) {
+	if r.u16() != ProtocolVersion && r.err == nil {
+		r.err = ErrUnsupported
+	}
+	if r.u16() != CipherSuite && r.err == nil {
+		r.err = ErrUnsupportedSuite
+	}
+	k.initKey = r.opaque()
+	k.leafNode.unmarshal(r)
+	k.extensions = unmarshalExtensions(r)
+	k.signature = r.opaque()
+}
+
+func (k *keyPackage) bytes() []byte {
+	w := &writer{}
+	k.marshal(w)
+	return w.b
+}
+
+func (k *keyPackage) ref() []byte {
+	return refHash("MLS 1.0 KeyPackage Reference", k.bytes())
+}
+
+func (k *keyPackage) verify() error {
+	if k.leafNode.source != leafNodeSourceKeyPackage {
+		return ErrMalformed
+	}
+	if bytes.Equal(k.initKey, k.leafNode.encryptionKey) {
+		return ErrMalformed
+	}
+	if err := k.leafNode.verify(nil, 0); err != nil {
+		return err
+	}
+	w := &writer{}
+	k.marshalContent(w)
+	return verifyWithLabel(k.leafNode.signatureKey, "KeyPackageTBS", w.b, k.signature)
+}
+
+type groupContext struct {
+	groupID                 []byte
+	epoch                   uint64
+	treeHash                []byte
+	confirmedTranscriptHash []byte
+	extensions              []extension
+}
+
+func (g *groupContext) marshal(w *writer) {
+	w.u16(ProtocolVersion)
+	w.u16(CipherSuite)
+	w.opaque(g.groupID)
+	w.u64(g.epoch)
+	w.opaque(g.treeHash)
+	w.opaque(g.confirmedTranscriptHash)
+	marshalExtensions(w, g.extensions)
+}
+
+func (g *groupContext) unmarshal(r *reader) {
+	if r.u16() != ProtocolVersion && r.err == nil {
+		r.err = ErrUnsupported
+	}
+	if r.u16() != CipherSuite && r.err == nil {
+		r.err = ErrUnsupportedSuite
+	}
+	g.groupID = r.opaque()
+	g.epoch = r.u64()
+	g.treeHash = r.opaque()
+	g.confirmedTranscriptHash = r.opaque()
+	g.extensions = unmarshalExtensions(r)
+}
+
+func (g *groupContext) bytes() []byte {
+	w := &writer{}
+	g.marshal(w)
+	return w.b
+}
+
+type proposal struct {
+	typ        uint16
+	keyPackage keyPackage // add
+	removed    uint32     // remove
+}
+
+func (p *proposal) marshal(w *writer) {
+	w.u16(p.typ)
+	switch p.typ {
+	case proposalTypeAdd:
+		p.keyPackage.marshal(w)
+	case proposalTypeRemove:
+		w.u32(p.removed)
+	}
+}
+
+func (p *proposal) unmarshal(r *reader) {
+	p.typ = r.u16()
+	switch p.typ {
+	case proposalTypeAdd:
+		p.keyPackage.unmarshal(r)
+	case proposalTypeRemove:
+		p.removed = r.u32()
+	default:
+		if r.err == nil {
+			r.err = ErrUnsupported
+		}
+	}
+}
+
+type proposalOrRef struct {
+	proposal *proposal
+	ref      []byte
+}
+
+type updatePathNode struct {
+	encryptionKey       []byte
+	encryptedPathSecret []hpkeCiphertext
+}
+
+type updatePath struct {
+	leafNode leafNode
+	nodes    []updatePathNode
+}
+
+type commit struct {
+	proposals []proposalOrRef
+	path      *updatePath
+}
+
+func (c *commit) marshal(w *writer) {
+	w.vector(func(w *writer) {
+		for _, p := range c.proposals {
+			if p.proposal != nil {
+				w.u8(proposalOrRefProposal)
+				p.proposal.marshal(w)
+				continue
+			}
+			w.u8(proposalOrRefReference)
+			w.opaque(p.ref)
+		}
+	})
+	w.optional(c.path != nil, func(w *writer) {
+		c.path.leafNode.marshal(w)
+		w.vector(func(w *writer) {
+			for _, n := range c.path.nodes {
+				w.opaque(n.encryptionKey)
+				w.vector(func(w *writer) {
+					for _, c := range n.encryptedPathSecret {
+						c.marshal(w)
+					}
+				})
+			}
+		})
+	})
+}
+
+func (c *commit) unmarshal(r *reader) {
+	r.vector(func(r *reader) {
+		switch r.u8() {
+		case proposalOrRefProposal:
+			p := &proposal{}
+			p.unmarshal(r)
+			c.proposals = append(c.proposals, proposalOrRef{proposal: p})
+		case proposalOrRefReference:
+			c.proposals = append(c.proposals, proposalOrRef{ref: r.opaque()})
+		default:
+			r.err = ErrMalformed
+		}
+	})
+	if !r.optional() {
+		return
+	}
+	c.path = &updatePath{}
+	c.path.leafNode.unmarshal(r)
+	r.vector(func(r *reader) {
+		n := updatePathNode{encryptionKey: r.opaque()}
+		r.vector(func(r *reader) {
+			var c hpkeCiphertext
+			c.unmarshal(r)
+			n.encryptedPathSecret = append(n.encryptedPathSecret, c)
+		})
+		c.path.nodes = append(c.path.nodes, n)
+	})
+}
+
+type sender struct {
+	typ   uint8
+	index uint32 // leaf index or external sender index
+}
+
+// framedContent holds either a proposal or a commit, application data is
+// never sent in public messages.
+type framedContent struct {
+	groupID           []byte
+	epoch             uint64
+	sender            sender
+	authenticatedData []byte
+	contentType       uint8
+	proposal          *proposal
+	commit            *commit
+}
+
+func (f *framedContent) marshal(w *writer) {
+	w.opaque(f.groupID)
+	w.u64(f.epoch)
+	w.u8(f.sender.typ)
+	w.u32(f.sender.index)
+	w.opaque(f.authenticatedData)
+	w.u8(f.contentType)
+	switch f.contentType {
+	case contentTypeProposal:
+		f.proposal.marshal(w)
+	case contentTypeCommit:
+		f.commit.marshal(w)
+	}
+}
+
+func (f *framedContent) unmarshal(r *reader) {
+	f.groupID = r.opaque()
+	f.epoch = r.u64()
+	f.sender.typ = r.u8()
+	switch f.sender.typ {
+	case senderTypeMember, senderTypeExternal:
+		f.sender.index = r.u32()
+	default:
+		if r.err == nil {
+			r.err = ErrUnsupported
+		}
+	}
+	f.authenticatedData = r.opaque()
+	f.contentType = r.u8()
+	switch f.contentType {
+	case contentTypeProposal:
+		f.proposal = &proposal{}
+		f.proposal.unmarshal(r)
+	case contentTypeCommit:
+		f.commit = &commit{}
+		f.commit.unmarshal(r)
+	default:
+		if r.err == nil {
+			r.err = ErrUnsupported
+		}
+	}
+}
+
+// tbs is FramedContentTBS, member senders also sign the group context.
+func (f *framedContent) tbs(context *groupContext) []byte {
+	w := &writer{}
+	w.u16(ProtocolVersion)
+	w.u16(wireFormatPublicMessage)
+	f.marshal(w)
+	if f.sender.typ == senderTypeMember {
+		context.marshal(w)
+	}
+	return w.b
+}
+
+// publicMessage is a signed, unencrypted handshake message.
+type publicMessage struct {
+	content         framedContent
+	signature       []byte
+	confirmationTag []byte // commits
+	membershipTag   []byte // member senders
+}
+
+func (p *publicMessage) marshalAuth(w *writer) {
+	w.opaque(p.signature)
+	if p.content.contentType == contentTypeCommit {
+		w.opaque(p.confirmationTag)
+	}
+}
+
+func (p *publicMessage) marshal(w *writer) {
+	w.u16(ProtocolVersion)
+	w.u16(wireFormatPublicMessage)
+	p.content.marshal(w)
+	p.marshalAuth(w)
+	if p.content.sender.typ == senderTypeMember {
+		w.opaque(p.membershipTag)
+	}
+}
+
+func (p *publicMessage) unmarshal(r *reader) {
+	p.content.unmarshal(r)
+	p.signature = r.opaque()
+	if p.content.contentType == contentTypeCommit {
+		p.confirmationTag = r.opaque()
+	}
+	if p.content.sender.typ == senderTypeMember {
+		p.membershipTag = r.opaque()
+	}
+}
+
+func (p *publicMessage) bytes() []byte {
+	w := &writer{}
+	p.marshal(w)
+	return w.b
+}
+
+// authenticatedContent is what proposal references and transcript hashes cover.
+func (p *publicMessage) authenticatedContent() []byte {
+	w := &writer{}
+	w.u16(wireFormatPublicMessage)
+	p.content.marshal(w)
+	p.marshalAuth(w)
+	return w.b
+}
+
+func (p *publicMessage) membershipInput(context *groupContext) []byte {
+	w := &writer{}
+	w.raw(p.content.tbs(context))
+	p.marshalAuth(w)
+	return w.b
+}
+
+func (p *publicMessage) confirmedTranscriptInput() []byte {
+	w := &writer{}
+	w.u16(wireFormatPublicMessage)
+	p.content.marshal(w)
+	w.opaque(p.signature)
+	return w.b
+}
+
+func parsePublicMessage(b []byte) (*publicMessage, error) {
+	r := newReader(b)
+	if r.u16() != ProtocolVersion && r.err == nil {
+		return nil, ErrUnsupported
+	}
+	if r.u16() != wireFormatPublicMessage && r.err == nil {
+		return nil, ErrWireFormat
+	}
+	p := &publicMessage{}
+	p.unmarshal(r)
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ReadMessage splits the first public message off concatenated messages.
+func ReadMessage(b []byte) (message []byte, rest []byte, err error) {
+	r := newReader(b)
+	if r.u16() != ProtocolVersion && r.err == nil {
+		return nil, nil, ErrUnsupported
+	}
+	if r.u16() != wireFormatPublicMessage && r.err == nil {
+		return nil, nil, ErrWireFormat
+	}
+	(&publicMessage{}).unmarshal(r)
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	n := len(b) - len(r.b)
+	return b[:n:n], r.b, nil
+}
+
+type groupInfo struct {
+	context         groupContext
+	extensions      []extension
+	confirmationTag []byte
+	signer          uint32
+	signature       []byte
+}
+
+func (g *groupInfo) marshalContent(w *writer) {
+	g.context.marshal(w)
+	marshalExtensions(w, g.extensions)
+	w.opaque(g.confirmationTag)
+	w.u32(g.signer)
+}
+
+func (g *groupInfo) tbs() []byte {
+	w := &writer{}
+	g.marshalContent(w)
+	return w.b
+}
+
+func (g *groupInfo) marshal(w *writer) {
+	g.marshalContent(w)
+	w.opaque(g.signature)
+}
+
+func (g *groupInfo) unmarshal(r *reader) {
+	g.context.unmarshal(r)
+	g.extensions = unmarshalExtensions(r)
+	g.confirmationTag = r.opaque()
+	g.signer = r.u32()
+	g.signature = r.opaque()
+}
+
+type groupSecrets struct {
+	joinerSecret []byte
+	pathSecret   []byte // optional
+}
+
+func (g *groupSecrets) marshal(w *writer) {
+	w.opaque(g.joinerSecret)
+	w.optional(g.pathSecret != nil, func(w *writer) {
+		w.opaque(g.pathSecret)
+	})
+	// No pre-shared keys.
+	w.varint(0)
+}
+
+func (g *groupSecrets) unmarshal(r *reader) {
+	g.joinerSecret = r.opaque()
+	if r.optional() {
+		g.pathSecret = r.opaque()
+	}
+	if len(r.opaque()) != 0 && r.err == nil {
+		r.err = ErrUnsupported
+	}
+}
+
+type encryptedGroupSecrets struct {
+	newMember []byte
+	secrets   hpkeCiphertext
+}
+
+type welcome struct {
+	secrets            []encryptedGroupSecrets
+	encryptedGroupInfo []byte
+}
+
+func (m *welcome) marshal(w *writer) {
+	w.u16(CipherSuite)
+	w.vector(func(w *writer) {
+		for _, s := range m.secrets {
+			w.opaque(s.newMember)
+			s.secrets.marshal(w)
+		}
+	})
+	w.opaque(m.encryptedGroupInfo)
+}
+
+func (m *welcome) unmarshal(r *reader) {
+	if r.u16() != CipherSuite && r.err == nil {
+		r.err = ErrUnsupportedSuite
+	}
+	r.vector(func(r *reader) {
+		s := encryptedGroupSecrets{newMember: r.opaque()}
+		s.secrets.unmarshal(r)
+		m.secrets = append(m.secrets, s)
+	})
+	m.encryptedGroupInfo = r.opaque()
+}
+
+func (m *welcome) bytes() []byte {
+	w := &writer{}
+	m.marshal(w)
+	return w.b
+}
+
+// parseWelcome accepts a bare Welcome or one wrapped in an MLSMessage.
+func parseWelcome(b []byte) (*welcome, error) {
+	r := newReader(b)
+	if len(b) >= 4 && b[0] == 0 && b[1] == byte(ProtocolVersion) && b[2] == 0 && b[3] == byte(wireFormatWelcome) {
+		r.u16()
+		r.u16()
+	}
+	m := &welcome{}
+	m.unmarshal(r)
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ExternalSender is a server allowed to send proposals to the group.
+type ExternalSender struct {
+	SignatureKey []byte
+	Identity     []byte
+}
+
+func (e *ExternalSender) marshal(w *writer) {
+	w.opaque(e.SignatureKey)
+	(&credential{identity: e.Identity}).marshal(w)
+}
+
+func (e *ExternalSender) unmarshal(r *reader) {
+	e.SignatureKey = r.opaque()
+	c := credential{}
+	c.unmarshal(r)
+	e.Identity = c.identity
+}
+
+func (e *ExternalSender) Bytes() []byte {
+	w := &writer{}
+	e.marshal(w)
+	return w.b
+}
+
+func ParseExternalSender(b []byte) (*ExternalSender, error) {
+	r := newReader(b)
+	e := &ExternalSender{}
+	e.unmarshal(r)
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func externalSendersExtension(senders []ExternalSender) extension {
+	w := &writer{}
+	w.vector(func(w *writer) {
+		for i := range senders {
+			senders[i].marshal(w)
+		}
+	})
+	return extension{typ: extensionTypeExternalSenders, data: w.b}
+}
+
+func parseExternalSenders(data []byte) ([]ExternalSender, error) {
+	r := newReader(data)
+	var senders []ExternalSender
+	r.vector(func(r *reader) {
+		var e ExternalSender
+		e.unmarshal(r)
+		senders = append(senders, e)
+	})
+	return senders, r.done()
+}
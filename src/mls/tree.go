@@ -0,0 +1,378 @@
+package mls
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"slices"
+)
+
+var ErrTree = errors.New("invalid mls ratchet tree")
+
+// Array based binary tree, RFC 9420 appendix C. Leaf i is node 2i and the
+// number of leaves is always a power of two.
+
+func level(x uint32) int {
+	return bits.TrailingZeros32(^x)
+}
+
+func nodeWidth(leaves uint32) uint32 {
+	if leaves == 0 {
+		return 0
+	}
+	return 2*(leaves-1) + 1
+}
+
+func root(leaves uint32) uint32 {
+	w := nodeWidth(leaves)
+	return 1<<(bits.Len32(w)-1) - 1
+}
+
+func left(x uint32) uint32 {
+	return x ^ (1 << (level(x) - 1))
+}
+
+func right(x uint32) uint32 {
+	return x ^ (3 << (level(x) - 1))
+}
+
+func parent(x uint32) uint32 {
+	k := level(x)
+	return (x | 1<<k) &^ (1 << (k + 1))
+}
+
+func sibling(x uint32) uint32 {
+	p := parent(x)
+	if x < p {
+		return right(p)
+	}
+	return left(p)
+}
+
+func directPath(x uint32, leaves uint32) []uint32 {
+	r := root(leaves)
+	var path []uint32
+	for x != r {
+		x = parent(x)
+		path = append(path, x)
+	}
+	return path
+}
+
+// inSubtree reports whether leaf node x is under a.
+func inSubtree(a, x uint32) bool {
+	k := level(a)
+	return x>>(k+1) == a>>(k+1)
+}
+
+type parentNode struct {
+	encryptionKey  []byte
+	parentHash     []byte
+	unmergedLeaves []uint32
+}
+
+func (p *parentNode) marshal(w *writer) {
+	w.opaque(p.encryptionKey)
+	w.opaque(p.parentHash)
+	w.vector(func(w *writer) {
+		for _, l := range p.unmergedLeaves {
+			w.u32(l)
+		}
+	})
+}
+
+func (p *parentNode) unmarshal(r *reader) {
+	p.encryptionKey = r.opaque()
+	p.parentHash = r.opaque()
+	r.vector(func(r *reader) {
+		p.unmergedLeaves = append(p.unmergedLeaves, r.u32())
+	})
+}
+
+// treeNode is a leaf or a parent, a nil *treeNode is blank.
+type treeNode struct {
+	leaf   *leafNode
+	parent *parentNode
+}
+
+type ratchetTree struct {
+	nodes []*treeNode
+}
+
+func (t *ratchetTree) leaves() uint32 {
+	return uint32(len(t.nodes)+1) / 2
+}
+
+func (t *ratchetTree) leaf(i uint32) *leafNode {
+	if 2*i >= uint32(len(t.nodes)) || t.nodes[2*i] == nil {
+		return nil
+	}
+	return t.nodes[2*i].leaf
+}
+
+func (t *ratchetTree) parentNode(x uint32) *parentNode {
+	if t.nodes[x] == nil {
+		return nil
+	}
+	return t.nodes[x].parent
+}
+
+// clone copies parent nodes, leaf nodes are replaced and never modified.
+func (t *ratchetTree) clone() *ratchetTree {
+	c := &ratchetTree{nodes: make([]*treeNode, len(t.nodes))}
+	for i, n := range t.nodes {
+		if n == nil {
+			continue
+		}
+		if n.parent == nil {
+			c.nodes[i] = n
+			continue
+		}
+		p := *n.parent
+		p.unmergedLeaves = slices.Clone(p.unmergedLeaves)
+		c.nodes[i] = &treeNode{parent: &p}
+	}
+	return c
+}
+
+// add places the leaf in the leftmost blank leaf, doubling the tree when it
+// is full.
+func (t *ratchetTree) add(l *leafNode) uint32 {
+	i := uint32(0)
+	for ; i < t.leaves(); i++ {
+		if t.nodes[2*i] == nil {
+			break
+		}
+	}
+	if i == t.leaves() {
+		width := nodeWidth(max(1, 2*t.leaves()))
+		t.nodes = append(t.nodes, make([]*treeNode, int(width)-len(t.nodes))...)
+	}
+	t.nodes[2*i] = &treeNode{leaf: l}
+	for _, x := range directPath(2*i, t.leaves()) {
+		if p := t.parentNode(x); p != nil {
+			p.unmergedLeaves = append(p.unmergedLeaves, i)
+		}
+	}
+	return i
+}
+
+// remove blanks the leaf and its direct path, then drops the right half of
+// the tree while it is empty.
+func (t *ratchetTree) remove(i uint32) {
+	t.nodes[2*i] = nil
+	for _, x := range directPath(2*i, t.leaves()) {
+		t.nodes[x] = nil
+	}
+	for t.leaves() > 1 {
+		half := t.leaves() / 2
+		for l := half; l < t.leaves(); l++ {
+			if t.nodes[2*l] != nil {
+				return
+			}
+		}
+		t.nodes = t.nodes[:nodeWidth(half)]
+	}
+}
+
+// resolution, RFC 9420 section 4.1.1.
+func (t *ratchetTree) resolution(x uint32) []uint32 {
+	n := t.nodes[x]
+	switch {
+	case n != nil && n.leaf != nil:
+		return []uint32{x}
+	case n != nil:
+		r := []uint32{x}
+		for _, l := range n.parent.unmergedLeaves {
+			r = append(r, 2*l)
+		}
+		return r
+	case level(x) == 0:
+		return nil
+	default:
+		return append(t.resolution(left(x)), t.resolution(right(x))...)
+	}
+}
+
+// filteredDirectPath skips nodes whose copath child has an empty resolution.
+func (t *ratchetTree) filteredDirectPath(leaf uint32) []uint32 {
+	var path []uint32
+	child := 2 * leaf
+	for _, x := range directPath(child, t.leaves()) {
+		if len(t.resolution(sibling(child))) > 0 {
+			path = append(path, x)
+		}
+		child = x
+	}
+	return path
+}
+
+// copathChild is the child of x that is not an ancestor of leaf.
+func copathChild(x, leaf uint32) uint32 {
+	if inSubtree(left(x), 2*leaf) {
+		return right(x)
+	}
+	return left(x)
+}
+
+func (t *ratchetTree) treeHash() []byte {
+	return t.hash(root(t.leaves()), nil)
+}
+
+// hash is the tree hash of x, RFC 9420 section 7.8, with the excluded
+// leaves blanked and dropped from unmerged leaves.
+func (t *ratchetTree) hash(x uint32, exclude []uint32) []byte {
+	w := &writer{}
+	n := t.nodes[x]
+	if level(x) == 0 {
+		w.u8(nodeTypeLeaf)
+		w.u32(x / 2)
+		present := n != nil && !slices.Contains(exclude, x/2)
+		w.optional(present, func(w *writer) {
+			n.leaf.marshal(w)
+		})
+		return hash(w.b)
+	}
+	w.u8(nodeTypeParent)
+	w.optional(n != nil, func(w *writer) {
+		p := *n.parent
+		p.unmergedLeaves = slices.DeleteFunc(slices.Clone(p.unmergedLeaves), func(l uint32) bool {
+			return slices.Contains(exclude, l)
+		})
+		p.marshal(w)
+	})
+	w.opaque(t.hash(left(x), exclude))
+	w.opaque(t.hash(right(x), exclude))
+	return hash(w.b)
+}
+
+// parentHash is the hash of parent p that its child opposite sibling s
+// carries, RFC 9420 section 7.9.
+func (t *ratchetTree) parentHash(p, s uint32) []byte {
+	n := t.parentNode(p)
+	w := &writer{}
+	w.opaque(n.encryptionKey)
+	w.opaque(n.parentHash)
+	w.opaque(t.hash(s, n.unmergedLeaves))
+	return hash(w.b)
+}
+
+func (t *ratchetTree) nodeParentHash(x uint32) []byte {
+	n := t.nodes[x]
+	if n.leaf != nil {
+		return n.leaf.parentHash
+	}
+	return n.parent.parentHash
+}
+
+// setPathParentHashes fills the parent hashes along the filtered direct
+// path of leaf from the root down and returns the leaf's parent hash.
+func (t *ratchetTree) setPathParentHashes(leaf uint32) []byte {
+	path := t.filteredDirectPath(leaf)
+	var h []byte
+	for i := len(path) - 1; i >= 0; i-- {
+		t.parentNode(path[i]).parentHash = h
+		h = t.parentHash(path[i], copathChild(path[i], leaf))
+	}
+	return h
+}
+
+// verifyParentHashes checks every parent node is chained to a leaf that
+// signed it.
+func (t *ratchetTree) verifyParentHashes() error {
+	for x := uint32(1); x < uint32(len(t.nodes)); x += 2 {
+		if t.nodes[x] == nil {
+			continue
+		}
+		if !t.hasParentHash(left(x), t.parentHash(x, right(x))) &&
+			!t.hasParentHash(right(x), t.parentHash(x, left(x))) {
+			return ErrTree
+		}
+	}
+	return nil
+}
+
+func (t *ratchetTree) hasParentHash(child uint32, h []byte) bool {
+	for _, x := range t.resolution(child) {
+		if bytes.Equal(t.nodeParentHash(x), h) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the index of the leaf with the same encoding.
+func (t *ratchetTree) find(l *leafNode) (uint32, bool) {
+	b := l.bytes()
+	for i := uint32(0); i < t.leaves(); i++ {
+		if other := t.leaf(i); other != nil && bytes.Equal(other.bytes(), b) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// marshal writes the ratchet tree extension with trailing blanks omitted.
+func (t *ratchetTree) marshal(w *writer) {
+	end := len(t.nodes)
+	for end > 0 && t.nodes[end-1] == nil {
+		end--
+	}
+	w.vector(func(w *writer) {
+		for _, n := range t.nodes[:end] {
+			w.optional(n != nil, func(w *writer) {
+				if n.leaf != nil {
+					w.u8(nodeTypeLeaf)
+					n.leaf.marshal(w)
+					return
+				}
+				w.u8(nodeTypeParent)
+				n.parent.marshal(w)
+			})
+		}
+	})
+}
+
+func (t *ratchetTree) bytes() []byte {
+	w := &writer{}
+	t.marshal(w)
+	return w.b
+}
+
+func parseRatchetTree(b []byte) (*ratchetTree, error) {
+	r := newReader(b)
+	t := &ratchetTree{}
+	r.vector(func(r *reader) {
+		if !r.optional() {
+			t.nodes = append(t.nodes, nil)
+			return
+		}
+		n := &treeNode{}
+		switch r.u8() {
+		case nodeTypeLeaf:
+			n.leaf = &leafNode{}
+			n.leaf.unmarshal(r)
+		case nodeTypeParent:
+			n.parent = &parentNode{}
+			n.parent.unmarshal(r)
+		default:
+			r.err = ErrMalformed
+		}
+		t.nodes = append(t.nodes, n)
+	})
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	if len(t.nodes) == 0 || t.nodes[len(t.nodes)-1] == nil {
+		return nil, ErrTree
+	}
+	// Leaves sit at even indices and the width grows to a full tree.
+	width := nodeWidth(1 << bits.Len32(uint32(len(t.nodes))/2))
+	t.nodes = append(t.nodes, make([]*treeNode, int(width)-len(t.nodes))...)
+	for x, n := range t.nodes {
+		if n != nil && (n.leaf != nil) != (level(uint32(x)) == 0) {
+			return nil, ErrTree
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,207 @@
+package mls
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestTreeMath(t *testing.T) {
+	roots := map[uint32]uint32{1: 0, 2: 1, 4: 3, 8: 7, 16: 15}
+	for leaves, want := range roots {
+		if got := root(leaves); got != want {
+			t.Errorf("root(%d) = %d, want %d", leaves, got, want)
+		}
+	}
+	if left(7) != 3 || right(7) != 11 || left(3) != 1 || right(3) != 5 {
+		t.Error("wrong children of 7 or 3")
+	}
+	if parent(0) != 1 || parent(2) != 1 || parent(1) != 3 || parent(5) != 3 || parent(3) != 7 || parent(11) != 7 {
+		t.Error("wrong parents")
+	}
+	if sibling(0) != 2 || sibling(5) != 1 || sibling(3) != 11 {
+		t.Error("wrong siblings")
+	}
+	if got := directPath(0, 8); !slices.Equal(got, []uint32{1, 3, 7}) {
+		t.Errorf("direct path of 0 is %v", got)
+	}
+	if got := directPath(12, 8); !slices.Equal(got, []uint32{13, 11, 7}) {
+		t.Errorf("direct path of 12 is %v", got)
+	}
+	if directPath(0, 1) != nil {
+		t.Error("single leaf has a direct path")
+	}
+	if !inSubtree(3, 4) || inSubtree(3, 8) || !inSubtree(7, 14) || !inSubtree(0, 0) {
+		t.Error("wrong subtrees")
+	}
+	if copathChild(3, 1) != 5 || copathChild(3, 2) != 1 || copathChild(7, 0) != 11 {
+		t.Error("wrong copath children")
+	}
+}
+
+func testLeaf(t *testing.T, identity string) *leafNode {
+	t.Helper()
+	k, err := NewKeyPackage([]byte(identity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &k.keyPackage.leafNode
+}
+
+func TestTreeAddRemove(t *testing.T) {
+	tree := &ratchetTree{}
+	for i, name := range []string{"a", "b", "c"} {
+		if got := tree.add(testLeaf(t, name)); got != uint32(i) {
+			t.Fatalf("leaf %s added at %d", name, got)
+		}
+	}
+	if tree.leaves() != 4 {
+		t.Fatalf("%d leaves, want 4", tree.leaves())
+	}
+	tree.nodes[3] = &treeNode{parent: &parentNode{encryptionKey: []byte("k")}}
+	if got := tree.resolution(5); !slices.Equal(got, []uint32{4}) {
+		t.Fatalf("resolution of 5 is %v", got)
+	}
+
+	// New leaves are unmerged at their non blank ancestors.
+	tree.remove(1)
+	if tree.nodes[2] != nil || tree.nodes[3] != nil {
+		t.Fatal("remove left the direct path")
+	}
+	tree.nodes[3] = &treeNode{parent: &parentNode{encryptionKey: []byte("k")}}
+	if got := tree.add(testLeaf(t, "d")); got != 1 {
+		t.Fatalf("leaf added at %d, want the blank 1", got)
+	}
+	if got := tree.parentNode(3).unmergedLeaves; !slices.Equal(got, []uint32{1}) {
+		t.Fatalf("unmerged leaves %v", got)
+	}
+	if got := tree.resolution(3); !slices.Equal(got, []uint32{3, 2}) {
+		t.Fatalf("resolution of 3 is %v", got)
+	}
+
+	// The right half is dropped once empty.
+	tree.remove(2)
+	if tree.leaves() != 2 {
+		t.Fatalf("%d leaves after truncation, want 2", tree.leaves())
+	}
+	tree.remove(1)
+	if tree.leaves() != 1 {
+		t.Fatalf("%d leaves after truncation, want 1", tree.leaves())
+	}
+}
+
+func TestFilteredDirectPath(t *testing.T) {
+	tree := &ratchetTree{}
+	for _, name := range []string{"a", "b", "c"} {
+		tree.add(testLeaf(t, name))
+	}
+	// Leaf 3 is blank, so node 5 has an empty copath for leaf 2.
+	if got := tree.filteredDirectPath(2); !slices.Equal(got, []uint32{3}) {
+		t.Fatalf("filtered direct path of 2 is %v", got)
+	}
+	if got := tree.filteredDirectPath(0); !slices.Equal(got, []uint32{1, 3}) {
+		t.Fatalf("filtered direct path of 0 is %v", got)
+	}
+}
+
+func TestRatchetTreeEncoding(t *testing.T) {
+	tree := &ratchetTree{}
+	for _, name := range []string{"a", "b", "c"} {
+		tree.add(testLeaf(t, name))
+	}
+	tree.nodes[1] = &treeNode{parent: &parentNode{encryptionKey: []byte("k"), unmergedLeaves: []uint32{1}}}
+	parsed, err := parseRatchetTree(tree.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.nodes) != len(tree.nodes) {
+		t.Fatalf("%d nodes, want %d", len(parsed.nodes), len(tree.nodes))
+	}
+	if !bytes.Equal(parsed.treeHash(), tree.treeHash()) {
+		t.Fatal("tree hash changed")
+	}
+	if _, err := parseRatchetTree([]byte{0x01, 0x00}); err == nil {
+		t.Fatal("parsed a tree ending with a blank")
+	}
+}
+
+// Reference tree math transcribed from RFC 9420 appendix C.
+func rfcLog2(x uint32) int {
+	if x == 0 {
+		return 0
+	}
+	k := 0
+	for x>>k > 0 {
+		k++
+	}
+	return k - 1
+}
+
+func rfcLevel(x uint32) int {
+	if x&1 == 0 {
+		return 0
+	}
+	k := 0
+	for (x>>k)&1 == 1 {
+		k++
+	}
+	return k
+}
+
+func rfcRoot(n uint32) uint32 {
+	w := nodeWidth(n)
+	return 1<<rfcLog2(w) - 1
+}
+
+func rfcParent(x, n uint32) uint32 {
+	k := rfcLevel(x)
+	b := (x >> (k + 1)) & 1
+	return (x | 1<<k) ^ (b << (k + 1))
+}
+
+func TestTreeMathAppendixC(t *testing.T) {
+	for n := uint32(1); n <= 64; n *= 2 {
+		r := rfcRoot(n)
+		if root(n) != r {
+			t.Fatalf("root(%d) = %d, want %d", n, root(n), r)
+		}
+		for x := uint32(0); x < nodeWidth(n); x++ {
+			if level(x) != rfcLevel(x) {
+				t.Fatalf("level(%d) = %d, want %d", x, level(x), rfcLevel(x))
+			}
+			if k := rfcLevel(x); k > 0 {
+				if l := x ^ 1<<(k-1); left(x) != l {
+					t.Fatalf("left(%d) = %d, want %d", x, left(x), l)
+				}
+				if rt := x ^ 3<<(k-1); right(x) != rt {
+					t.Fatalf("right(%d) = %d, want %d", x, right(x), rt)
+				}
+			}
+			if x == r {
+				if directPath(x, n) != nil {
+					t.Fatalf("root %d has a direct path", x)
+				}
+				continue
+			}
+			p := rfcParent(x, n)
+			if parent(x) != p {
+				t.Fatalf("parent(%d) = %d, want %d", x, parent(x), p)
+			}
+			s := left(p)
+			if x < p {
+				s = right(p)
+			}
+			if sibling(x) != s {
+				t.Fatalf("sibling(%d) = %d, want %d", x, sibling(x), s)
+			}
+			var path []uint32
+			for y := x; y != r; {
+				y = rfcParent(y, n)
+				path = append(path, y)
+			}
+			if got := directPath(x, n); !slices.Equal(got, path) {
+				t.Fatalf("directPath(%d, %d) = %v, want %v", x, n, got, path)
+			}
+		}
+	}
+}
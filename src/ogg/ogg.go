@@ -0,0 +1,151 @@
+package ogg
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Ogg bitstream, RFC 3533.
+// Source: https://www.rfc-editor.org/rfc/rfc3533
+const (
+	HeaderTypeContinuation byte = 0x01
+	HeaderTypeBOS          byte = 0x02
+	HeaderTypeEOS          byte = 0x04
+
+	pageHeaderSize  = 27
+	maxSegments     = 255
+	maxSegmentSize  = 255
+	capturePattern  = "OggS"
+	streamStructVer = 0
+)
+
+var (
+	ErrWriterClosed = errors.New("ogg writer is closed")
+)
+
+var crcTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = (r << 1) ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// Ogg uses a non reflected crc32 with 0x04c11db7 polynomial.
+func crc32(b []byte) uint32 {
+	var crc uint32
+	for _, v := range b {
+		crc = (crc << 8) ^ crcTable[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+// Writer muxes packets of a single logical bitstream into ogg pages.
+type Writer struct {
+	w        io.Writer
+	serial   uint32
+	sequence uint32
+	bos      bool
+	closed   bool
+
+	// Pending page.
+	segments []byte
+	body     []byte
+	granule  uint64
+	packets  int
+
+	// Packets per page before it gets flushed.
+	PacketsPerPage int
+}
+
+func NewWriter(w io.Writer, serial uint32) *Writer {
+	return &Writer{
+		w:              w,
+		serial:         serial,
+		bos:            true,
+		PacketsPerPage: 50,
+	}
+}
+
+// WritePacket appends packet to the pending page. granule is the
+// absolute granule position after this packet.
+func (ow *Writer) WritePacket(packet []byte, granule uint64) error {
+	if ow.closed {
+		return ErrWriterClosed
+	}
+	// Lacing values, packet of size multiple of 255 ends with 0.
+	lacing := len(packet)/maxSegmentSize + 1
+	if len(ow.segments)+lacing > maxSegments {
+		if err := ow.flush(0); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < lacing-1; i++ {
+		ow.segments = append(ow.segments, maxSegmentSize)
+	}
+	ow.segments = append(ow.segments, byte(len(packet)%maxSegmentSize))
+	ow.body = append(ow.body, packet...)
+	ow.granule = granule
+	ow.packets++
+	if ow.packets >= ow.PacketsPerPage {
+		return ow.flush(0)
+	}
+	return nil
+}
+
+// Flush writes the pending page, if any.
+func (ow *Writer) Flush() error {
+	if ow.closed {
+		return ErrWriterClosed
+	}
+	if len(ow.segments) == 0 {
+		return nil
+	}
+	return ow.flush(0)
+}
+
+// Close writes the last page with end of stream flag.
+// It does not close the underlying writer.
+func (ow *Writer) Close() error {
+	if ow.closed {
+		return nil
+	}
+	err := ow.flush(HeaderTypeEOS)
+	ow.closed = true
+	return err
+}
+
+func (ow *Writer) flush(headerType byte) error {
+	if ow.bos {
+		headerType |= HeaderTypeBOS
+		ow.bos = false
+	}
+	// Packet larger than a page is not supported, we never produce one.
+	page := make([]byte, pageHeaderSize, pageHeaderSize+len(ow.segments)+len(ow.body))
+	copy(page, capturePattern)
+	page[4] = streamStructVer
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:14], ow.granule)
+	binary.LittleEndian.PutUint32(page[14:18], ow.serial)
+	binary.LittleEndian.PutUint32(page[18:22], ow.sequence)
+	page[26] = byte(len(ow.segments))
+	page = append(page, ow.segments...)
+	page = append(page, ow.body...)
+	binary.LittleEndian.PutUint32(page[22:26], crc32(page))
+
+	ow.sequence++
+	ow.segments = ow.segments[:0]
+	ow.body = ow.body[:0]
+	ow.packets = 0
+	_, err := ow.w.Write(page)
+	return err
+}
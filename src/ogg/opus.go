@@ -0,0 +1,96 @@
+package ogg
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Ogg encapsulation for opus, RFC 7845.
+// Source: https://www.rfc-editor.org/rfc/rfc7845
+const (
+	OpusSampleRate = 48000
+	// 20ms frame at 48kHz.
+	OpusFrameSamples = 960
+)
+
+var (
+	ErrInvalidOpusPacket = errors.New("invalid opus packet")
+)
+
+// Opus silence frame, 20ms.
+var OpusSilenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+type OpusHead struct {
+	Channels        uint8
+	PreSkip         uint16
+	InputSampleRate uint32
+	OutputGain      int16
+}
+
+func (h OpusHead) Marshal() []byte {
+	b := make([]byte, 0, 19)
+	b = append(b, "OpusHead"...)
+	b = append(b, 1) // version
+	b = append(b, h.Channels)
+	b = binary.LittleEndian.AppendUint16(b, h.PreSkip)
+	b = binary.LittleEndian.AppendUint32(b, h.InputSampleRate)
+	b = binary.LittleEndian.AppendUint16(b, uint16(h.OutputGain))
+	b = append(b, 0) // channel mapping family
+	return b
+}
+
+func (h *OpusHead) Unmarshal(b []byte) error {
+	if len(b) < 19 || string(b[0:8]) != "OpusHead" {
+		return ErrNotOpus
+	}
+	h.Channels = b[9]
+	h.PreSkip = binary.LittleEndian.Uint16(b[10:12])
+	h.InputSampleRate = binary.LittleEndian.Uint32(b[12:16])
+	h.OutputGain = int16(binary.LittleEndian.Uint16(b[16:18]))
+	return nil
+}
+
+func OpusTags(vendor string, comments ...string) []byte {
+	b := make([]byte, 0, 16+len(vendor))
+	b = append(b, "OpusTags"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(vendor)))
+	b = append(b, vendor...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(comments)))
+	for _, c := range comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+		b = append(b, c...)
+	}
+	return b
+}
+
+// OpusPacketSamples returns the duration of an opus packet in 48kHz samples,
+// computed from the TOC byte. Source: RFC 6716 section 3.1.
+func OpusPacketSamples(packet []byte) (int, error) {
+	if len(packet) < 1 {
+		return 0, ErrInvalidOpusPacket
+	}
+	toc := packet[0]
+	config := toc >> 3
+	var frameSamples int
+	switch {
+	case config < 12: // SILK: 10, 20, 40, 60ms
+		frameSamples = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid: 10, 20ms
+		frameSamples = []int{480, 960}[config%2]
+	default: // CELT: 2.5, 5, 10, 20ms
+		frameSamples = []int{120, 240, 480, 960}[config%4]
+	}
+	var frames int
+	switch toc & 0x3 {
+	case 0:
+		frames = 1
+	case 1, 2:
+		frames = 2
+	default:
+		if len(packet) < 2 {
+			return 0, ErrInvalidOpusPacket
+		}
+		frames = int(packet[1] & 0x3f)
+	}
+	return frames * frameSamples, nil
+}
@@ -0,0 +1,44 @@
+package ogg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var (
+	ErrNotOpus = errors.New("not an ogg opus stream")
+)
+
+// OpusReader reads opus packets from an ogg opus stream,
+// identification and comment headers are skipped.
+type OpusReader struct {
+	ogg  *Reader
+	Head OpusHead
+}
+
+func NewOpusReader(r io.Reader) (*OpusReader, error) {
+	or := &OpusReader{
+		ogg: NewReader(r),
+	}
+	head, _, err := or.ogg.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	if err := or.Head.Unmarshal(head); err != nil {
+		return nil, err
+	}
+	tags, _, err := or.ogg.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(tags, []byte("OpusTags")) {
+		return nil, ErrNotOpus
+	}
+	return or, nil
+}
+
+func (or *OpusReader) ReadPacket() ([]byte, error) {
+	packet, _, err := or.ogg.ReadPacket()
+	return packet, err
+}
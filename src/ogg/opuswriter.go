@@ -0,0 +1,50 @@
+package ogg
+
+import (
+	"io"
+)
+
+// OpusWriter writes opus packets into an ogg opus file.
+type OpusWriter struct {
+	ogg     *Writer
+	granule uint64
+}
+
+func NewOpusWriter(w io.Writer, serial uint32, head OpusHead, vendor string) (*OpusWriter, error) {
+	ow := &OpusWriter{
+		ogg:     NewWriter(w, serial),
+		granule: uint64(head.PreSkip),
+	}
+	// Identification and comment headers are on their own pages.
+	if err := ow.ogg.WritePacket(head.Marshal(), 0); err != nil {
+		return nil, err
+	}
+	if err := ow.ogg.Flush(); err != nil {
+		return nil, err
+	}
+	if err := ow.ogg.WritePacket(OpusTags(vendor), 0); err != nil {
+		return nil, err
+	}
+	if err := ow.ogg.Flush(); err != nil {
+		return nil, err
+	}
+	return ow, nil
+}
+
+func (ow *OpusWriter) WritePacket(packet []byte) error {
+	samples, err := OpusPacketSamples(packet)
+	if err != nil {
+		return err
+	}
+	ow.granule += uint64(samples)
+	return ow.ogg.WritePacket(packet, ow.granule)
+}
+
+// Granule returns the current granule position, pre-skip included.
+func (ow *OpusWriter) Granule() uint64 {
+	return ow.granule
+}
+
+func (ow *OpusWriter) Close() error {
+	return ow.ogg.Close()
+}
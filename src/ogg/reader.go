@@ -0,0 +1,126 @@
+package ogg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrInvalidPage = errors.New("invalid ogg page")
+	ErrBadCRC      = errors.New("ogg page crc mismatch")
+)
+
+type Page struct {
+	HeaderType byte
+	Granule    uint64
+	Serial     uint32
+	Sequence   uint32
+	Segments   []byte
+	Body       []byte
+}
+
+// Reader demuxes packets of the first logical bitstream in an ogg stream.
+type Reader struct {
+	r       *bufio.Reader
+	serial  uint32
+	started bool
+
+	// Packets of the current page not yet returned.
+	pending [][]byte
+	// Packet continued on the next page.
+	partial []byte
+	granule uint64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// ReadPage reads the next page, regardless of its bitstream.
+func (or *Reader) ReadPage() (*Page, error) {
+	header := make([]byte, pageHeaderSize)
+	if _, err := io.ReadFull(or.r, header); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != capturePattern || header[4] != streamStructVer {
+		return nil, ErrInvalidPage
+	}
+	p := &Page{
+		HeaderType: header[5],
+		Granule:    binary.LittleEndian.Uint64(header[6:14]),
+		Serial:     binary.LittleEndian.Uint32(header[14:18]),
+		Sequence:   binary.LittleEndian.Uint32(header[18:22]),
+	}
+	checksum := binary.LittleEndian.Uint32(header[22:26])
+	p.Segments = make([]byte, header[26])
+	if _, err := io.ReadFull(or.r, p.Segments); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	size := 0
+	for _, s := range p.Segments {
+		size += int(s)
+	}
+	p.Body = make([]byte, size)
+	if _, err := io.ReadFull(or.r, p.Body); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	// Checksum is computed with the crc field zeroed.
+	binary.LittleEndian.PutUint32(header[22:26], 0)
+	raw := make([]byte, 0, len(header)+len(p.Segments)+len(p.Body))
+	raw = append(raw, header...)
+	raw = append(raw, p.Segments...)
+	raw = append(raw, p.Body...)
+	if crc32(raw) != checksum {
+		return nil, fmt.Errorf("%w: page %d", ErrBadCRC, p.Sequence)
+	}
+	return p, nil
+}
+
+// ReadPacket returns the next complete packet and the granule position
+// of the page it ends on.
+func (or *Reader) ReadPacket() ([]byte, uint64, error) {
+	for len(or.pending) == 0 {
+		p, err := or.ReadPage()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !or.started {
+			or.serial = p.Serial
+			or.started = true
+		}
+		if p.Serial != or.serial {
+			continue
+		}
+		if p.HeaderType&HeaderTypeContinuation == 0 {
+			// Lost the rest of a continued packet.
+			or.partial = nil
+		}
+		offset := 0
+		packet := or.partial
+		for _, s := range p.Segments {
+			packet = append(packet, p.Body[offset:offset+int(s)]...)
+			offset += int(s)
+			if s < maxSegmentSize {
+				or.pending = append(or.pending, packet)
+				packet = nil
+			}
+		}
+		or.partial = packet
+		or.granule = p.Granule
+	}
+	packet := or.pending[0]
+	or.pending = or.pending[1:]
+	return packet, or.granule, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// parseM3U reads plain and extended M3U. Lines of legacy .m3u files that are
+// not valid UTF-8 are read as Latin-1.
+func parseM3U(r io.Reader) (*Playlist, error) {
+	p := &Playlist{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 64*1024)
+	var pending Entry
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\uFEFF")
+			first = false
+		}
+		if !utf8.ValidString(line) {
+			line = latin1(line)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			pending.Duration, pending.Title = parseExtinf(strings.TrimPrefix(line, "#EXTINF:"))
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			p.Title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#"):
+			// Header and unsupported directives.
+		default:
+			pending.Location = fileLocation(line)
+			p.add(pending)
+			pending = Entry{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	return p, nil
+}
+
+// "#EXTINF:<seconds> [attributes],<title>", -1 seconds for unknown length.
+func parseExtinf(s string) (time.Duration, string) {
+	info, title, _ := strings.Cut(s, ",")
+	fields := strings.Fields(info)
+	var d time.Duration
+	if len(fields) > 0 {
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if err == nil && seconds > 0 && !math.IsInf(seconds, 0) {
+			d = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return d, strings.TrimSpace(title)
+}
+
+func writeM3U(w io.Writer, p *Playlist) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#EXTM3U\n")
+	if p.Title != "" {
+		fmt.Fprintf(bw, "#PLAYLIST:%s\n", oneLine(p.Title))
+	}
+	for _, e := range p.Entries {
+		seconds := -1
+		if e.Duration > 0 {
+			seconds = int(math.Round(e.Duration.Seconds()))
+		}
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n", seconds, oneLine(e.Title))
+		fmt.Fprintf(bw, "%s\n", oneLine(e.Location))
+	}
+	return bw.Flush()
+}
+
+// Line breaks would start a new entry.
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+func latin1(s string) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
@@ -0,0 +1,145 @@
+package playlist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/utils"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown playlist format")
+	ErrInvalid       = errors.New("invalid playlist")
+)
+
+type Format = int
+
+const (
+	// M3U and M3U8 are written the same way, always UTF-8.
+	FormatM3U Format = iota
+	FormatPLS
+	FormatXSPF
+)
+
+// Extensions by format, the first one is used when saving.
+var EXTENSIONS = map[Format][]string{
+	FormatM3U:  {".m3u8", ".m3u"},
+	FormatPLS:  {".pls"},
+	FormatXSPF: {".xspf"},
+}
+
+// Entries above this are ignored when parsing.
+var MAX_ENTRIES = 5000
+
+type Entry struct {
+	// File path or url, as written in the playlist. file:// urls are
+	// converted to paths.
+	Location string `json:"location"`
+	// Empty if unknown.
+	Title    string        `json:"title,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// IsURL reports whether the entry is an http(s) stream rather than a file.
+func (e Entry) IsURL() bool {
+	return strings.HasPrefix(e.Location, "http://") || strings.HasPrefix(e.Location, "https://")
+}
+
+type Playlist struct {
+	Title   string
+	Entries []Entry
+}
+
+// FormatOf returns the format of the file from its extension.
+func FormatOf(path string) (Format, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	for format, exts := range EXTENSIONS {
+		for _, e := range exts {
+			if e == ext {
+				return format, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownFormat, ext)
+}
+
+func Parse(r io.Reader, format Format) (*Playlist, error) {
+	switch format {
+	case FormatM3U:
+		return parseM3U(r)
+	case FormatPLS:
+		return parsePLS(r)
+	case FormatXSPF:
+		return parseXSPF(r)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+func Write(w io.Writer, p *Playlist, format Format) error {
+	switch format {
+	case FormatM3U:
+		return writeM3U(w, p)
+	case FormatPLS:
+		return writePLS(w, p)
+	case FormatXSPF:
+		return writeXSPF(w, p)
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+// Load parses the file, format is taken from its extension.
+func Load(path string) (*Playlist, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, format)
+}
+
+// Save writes the file through a temporary file, format is taken from its
+// extension.
+func Save(path string, p *Playlist) error {
+	format, err := FormatOf(path)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, p, format); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, buf.Bytes())
+}
+
+// file:// urls become paths, anything else is kept as is.
+func fileLocation(location string) string {
+	if !strings.HasPrefix(strings.ToLower(location), "file:") {
+		return location
+	}
+	u, err := url.Parse(location)
+	if err != nil || u.Path == "" {
+		return location
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func (p *Playlist) add(e Entry) bool {
+	if e.Location == "" || len(p.Entries) >= MAX_ENTRIES {
+		return false
+	}
+	p.Entries = append(p.Entries, e)
+	return true
+}
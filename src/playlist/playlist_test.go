@@ -0,0 +1,156 @@
+package playlist
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseM3U(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Playlist
+	}{
+		{
+			"extended",
+			"\uFEFF#EXTM3U\n#PLAYLIST:Mix\n#EXTINF:215,Artist - Song\nsong.mp3\n\n#EXTINF:-1 tvg-id=\"x\",Radio, live\nhttps://example.com/stream\n",
+			Playlist{Title: "Mix", Entries: []Entry{
+				{Location: "song.mp3", Title: "Artist - Song", Duration: 215 * time.Second},
+				{Location: "https://example.com/stream", Title: "Radio, live"},
+			}},
+		},
+		{
+			"plain",
+			"# comment\r\na.mp3\r\n  sub/b.flac  \r\n",
+			Playlist{Entries: []Entry{{Location: "a.mp3"}, {Location: filepath.FromSlash("sub/b.flac")}}},
+		},
+		{
+			// EXTINF only applies to the next location.
+			"fractional and stale info",
+			"#EXTINF:1.5,First\na.mp3\nb.mp3\n#EXTINF:abc,Bad\nc.mp3\n",
+			Playlist{Entries: []Entry{
+				{Location: "a.mp3", Title: "First", Duration: 1500 * time.Millisecond},
+				{Location: "b.mp3"},
+				{Location: "c.mp3", Title: "Bad"},
+			}},
+		},
+		{
+			"latin-1",
+			"#EXTINF:10,Caf\xe9 Ol\xe9\nd\xe9j\xe0 vu.mp3\n#EXTINF:5,Déjà\nutf8.mp3\n",
+			Playlist{Entries: []Entry{
+				{Location: "déjà vu.mp3", Title: "Café Olé", Duration: 10 * time.Second},
+				{Location: "utf8.mp3", Title: "Déjà", Duration: 5 * time.Second},
+			}},
+		},
+		{
+			"file url",
+			"file:///music/a%20b.mp3\n",
+			Playlist{Entries: []Entry{{Location: filepath.FromSlash("/music/a b.mp3")}}},
+		},
+	}
+	for _, tt := range tests {
+		p, err := Parse(strings.NewReader(tt.input), FormatM3U)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(*p, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *p, tt.want)
+		}
+	}
+}
+
+func TestParsePLS(t *testing.T) {
+	input := `; saved by a player
+[other]
+File1=ignored.mp3
+
+[Playlist]
+File10=ten.mp3
+Title2=Two
+File2=two.mp3
+Length2=62
+file1=one.mp3
+Length1=-1
+Title3=No file
+File0=zero.mp3
+NumberOfEntries=3
+Version=2
+`
+	p, err := Parse(strings.NewReader(input), FormatPLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ordered by number, not by position in the file.
+	want := []Entry{
+		{Location: "one.mp3"},
+		{Location: "two.mp3", Title: "Two", Duration: 62 * time.Second},
+		{Location: "ten.mp3"},
+	}
+	if !reflect.DeepEqual(p.Entries, want) {
+		t.Fatalf("got %+v, want %+v", p.Entries, want)
+	}
+}
+
+func TestParseXSPF(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <title>Evening</title>
+  <trackList>
+    <track><location>songs/caf%C3%A9%20au%20lait.ogg</location><title>Café</title><duration>61500</duration></track>
+    <track><location>../up.mp3</location></track>
+    <track><location>file:///srv/music/x.flac</location></track>
+    <track><location>spotify://track/1</location><location>https://example.com/a.mp3</location></track>
+    <track><location>ftp://example.com/b.mp3</location></track>
+  </trackList>
+</playlist>`
+	p, err := Parse(strings.NewReader(input), FormatXSPF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Playlist{Title: "Evening", Entries: []Entry{
+		{Location: filepath.FromSlash("songs/café au lait.ogg"), Title: "Café", Duration: 61500 * time.Millisecond},
+		{Location: filepath.FromSlash("../up.mp3")},
+		{Location: filepath.FromSlash("/srv/music/x.flac")},
+		// The first supported location is used.
+		{Location: "https://example.com/a.mp3"},
+	}}
+	if !reflect.DeepEqual(*p, want) {
+		t.Fatalf("got %+v, want %+v", *p, want)
+	}
+	if _, err := Parse(strings.NewReader("<playlist"), FormatXSPF); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("truncated: got %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	p := &Playlist{Title: "Trip", Entries: []Entry{
+		{Location: filepath.FromSlash("dir/a b.mp3"), Title: "A\nB", Duration: 3 * time.Second},
+		{Location: "https://example.com/s?x=1&y=2", Title: "Stream"},
+	}}
+	for _, format := range []Format{FormatM3U, FormatPLS, FormatXSPF} {
+		var buf bytes.Buffer
+		if err := Write(&buf, p, format); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Parse(&buf, format)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if len(got.Entries) != 2 || got.Entries[0].Location != p.Entries[0].Location || got.Entries[1].Location != p.Entries[1].Location {
+			t.Errorf("format %d: got %+v", format, got.Entries)
+		}
+		// Line based formats can not keep line breaks.
+		title := "A B"
+		if format == FormatXSPF {
+			title = "A\nB"
+		}
+		if got.Entries[0].Title != title || got.Entries[0].Duration != 3*time.Second {
+			t.Errorf("format %d: first entry %+v", format, got.Entries[0])
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parsePLS reads the ini style PLS format, entries are ordered by number.
+func parsePLS(r io.Reader) (*Playlist, error) {
+	entries := map[int]*Entry{}
+	section := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 64*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\uFEFF"))
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section = strings.EqualFold(line, "[playlist]")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !section || !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		field := strings.TrimRight(key, "0123456789")
+		n, err := strconv.Atoi(key[len(field):])
+		if err != nil || n < 1 || n > MAX_ENTRIES {
+			continue
+		}
+		e, ok := entries[n]
+		if !ok {
+			e = &Entry{}
+			entries[n] = e
+		}
+		switch field {
+		case "file":
+			e.Location = fileLocation(value)
+		case "title":
+			e.Title = value
+		case "length":
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err == nil && seconds > 0 && !math.IsInf(seconds, 0) {
+				e.Duration = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	numbers := make([]int, 0, len(entries))
+	for n := range entries {
+		numbers = append(numbers, n)
+	}
+	slices.Sort(numbers)
+	p := &Playlist{}
+	for _, n := range numbers {
+		p.add(*entries[n])
+	}
+	return p, nil
+}
+
+func writePLS(w io.Writer, p *Playlist) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[playlist]\n")
+	for i, e := range p.Entries {
+		n := i + 1
+		fmt.Fprintf(bw, "File%d=%s\n", n, oneLine(e.Location))
+		if e.Title != "" {
+			fmt.Fprintf(bw, "Title%d=%s\n", n, oneLine(e.Title))
+		}
+		seconds := -1
+		if e.Duration > 0 {
+			seconds = int(math.Round(e.Duration.Seconds()))
+		}
+		fmt.Fprintf(bw, "Length%d=%d\n", n, seconds)
+	}
+	fmt.Fprintf(bw, "NumberOfEntries=%d\n", len(p.Entries))
+	bw.WriteString("Version=2\n")
+	return bw.Flush()
+}
@@ -0,0 +1,300 @@
+package playlist
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hendrywilliam/siren/src/storage"
+)
+
+var (
+	ErrNotFound     = errors.New("playlist not found")
+	ErrExists       = errors.New("playlist already exists")
+	ErrName         = errors.New("invalid playlist name")
+	ErrFull         = errors.New("playlist is full")
+	ErrOutOfRange   = errors.New("position out of range")
+	ErrNotShareable = errors.New("only user playlists can be shared")
+)
+
+// Storage collection of saved playlists.
+const savedCollection = "playlists"
+
+var MAX_NAME_LENGTH = 100
+
+type OwnerKind = string
+
+const (
+	OwnerUser  OwnerKind = "user"
+	OwnerGuild OwnerKind = "guild"
+)
+
+type Owner struct {
+	Kind OwnerKind `json:"kind"`
+	ID   string    `json:"id"`
+}
+
+func UserOwner(userID string) Owner {
+	return Owner{Kind: OwnerUser, ID: userID}
+}
+
+func GuildOwner(guildID string) Owner {
+	return Owner{Kind: OwnerGuild, ID: guildID}
+}
+
+func (o Owner) prefix() string {
+	return o.Kind + ":" + o.ID + ":"
+}
+
+// Saved is a named playlist kept in storage, names are unique per owner
+// ignoring case.
+type Saved struct {
+	Name      string  `json:"name"`
+	Owner     Owner   `json:"owner"`
+	CreatedBy string  `json:"created_by"` // User ID
+	Entries   []Entry `json:"entries"`
+	// Guilds where other members can play a user playlist.
+	SharedWith []string  `json:"shared_with,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (s *Saved) key() string {
+	return savedKey(s.Owner, s.Name)
+}
+
+func savedKey(owner Owner, name string) string {
+	return owner.prefix() + strings.ToLower(name)
+}
+
+// Manager keeps saved playlists in a store. Changes go through a read,
+// modify, write cycle serialized by the manager.
+type Manager struct {
+	store storage.Store
+	mu    sync.Mutex
+}
+
+func NewManager(store storage.Store) *Manager {
+	return &Manager{store: store}
+}
+
+func (m *Manager) Create(owner Owner, name, createdBy string) (*Saved, error) {
+	name = strings.TrimSpace(name)
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.get(owner, name); err == nil {
+		return nil, ErrExists
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	now := time.Now()
+	s := &Saved{
+		Name:      name,
+		Owner:     owner,
+		CreatedBy: createdBy,
+		Entries:   []Entry{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return s, m.store.Put(savedCollection, s.key(), s)
+}
+
+func (m *Manager) Get(owner Owner, name string) (*Saved, error) {
+	return m.get(owner, strings.TrimSpace(name))
+}
+
+func (m *Manager) get(owner Owner, name string) (*Saved, error) {
+	s := &Saved{}
+	err := m.store.Get(savedCollection, savedKey(owner, name), s)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// List returns the playlists of owner sorted by name.
+func (m *Manager) List(owner Owner) ([]*Saved, error) {
+	return m.list(owner.prefix(), func(*Saved) bool { return true })
+}
+
+// Shared returns user playlists shared with the guild.
+func (m *Manager) Shared(guildID string) ([]*Saved, error) {
+	return m.list(OwnerUser+":", func(s *Saved) bool {
+		return slices.Contains(s.SharedWith, guildID)
+	})
+}
+
+func (m *Manager) list(prefix string, keep func(*Saved) bool) ([]*Saved, error) {
+	keys, err := m.store.List(savedCollection, prefix)
+	if err != nil {
+		return nil, err
+	}
+	saved := []*Saved{}
+	for _, key := range keys {
+		s := &Saved{}
+		if err := m.store.Get(savedCollection, key, s); err != nil {
+			// Deleted since listing.
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		if keep(s) {
+			saved = append(saved, s)
+		}
+	}
+	return saved, nil
+}
+
+// Find looks up a playlist playable by the user in the guild: their own
+// first, then the guild's, then the ones shared with the guild.
+func (m *Manager) Find(userID, guildID, name string) (*Saved, error) {
+	name = strings.TrimSpace(name)
+	for _, owner := range []Owner{UserOwner(userID), GuildOwner(guildID)} {
+		s, err := m.get(owner, name)
+		if !errors.Is(err, ErrNotFound) {
+			return s, err
+		}
+	}
+	shared, err := m.Shared(guildID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range shared {
+		if strings.EqualFold(s.Name, name) {
+			return s, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Manager) Delete(owner Owner, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.store.Delete(savedCollection, savedKey(owner, strings.TrimSpace(name)))
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (m *Manager) Rename(owner Owner, name, newName string) (*Saved, error) {
+	name, newName = strings.TrimSpace(name), strings.TrimSpace(newName)
+	if err := validateName(newName); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, err := m.get(owner, name)
+	if err != nil {
+		return nil, err
+	}
+	// Changing only the case keeps the key.
+	if !strings.EqualFold(name, newName) {
+		if _, err := m.get(owner, newName); err == nil {
+			return nil, ErrExists
+		} else if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+	oldKey := s.key()
+	s.Name = newName
+	s.UpdatedAt = time.Now()
+	if err := m.store.Put(savedCollection, s.key(), s); err != nil {
+		return nil, err
+	}
+	if oldKey != s.key() {
+		if err := m.store.Delete(savedCollection, oldKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Add appends entries, all or none.
+func (m *Manager) Add(owner Owner, name string, entries ...Entry) (*Saved, error) {
+	return m.update(owner, name, func(s *Saved) error {
+		if len(s.Entries)+len(entries) > MAX_ENTRIES {
+			return ErrFull
+		}
+		s.Entries = append(s.Entries, entries...)
+		return nil
+	})
+}
+
+// Remove deletes the entry at position, zero based.
+func (m *Manager) Remove(owner Owner, name string, position int) (Entry, error) {
+	var removed Entry
+	_, err := m.update(owner, name, func(s *Saved) error {
+		if position < 0 || position >= len(s.Entries) {
+			return ErrOutOfRange
+		}
+		removed = s.Entries[position]
+		s.Entries = slices.Delete(s.Entries, position, position+1)
+		return nil
+	})
+	return removed, err
+}
+
+// Move puts the entry at from at position to, zero based.
+func (m *Manager) Move(owner Owner, name string, from, to int) (*Saved, error) {
+	return m.update(owner, name, func(s *Saved) error {
+		if from < 0 || from >= len(s.Entries) || to < 0 || to >= len(s.Entries) {
+			return ErrOutOfRange
+		}
+		e := s.Entries[from]
+		s.Entries = slices.Insert(slices.Delete(s.Entries, from, from+1), to, e)
+		return nil
+	})
+}
+
+// Share makes a user playlist playable by members of the guild, or stops
+// sharing it.
+func (m *Manager) Share(owner Owner, name, guildID string, shared bool) (*Saved, error) {
+	if owner.Kind != OwnerUser {
+		return nil, ErrNotShareable
+	}
+	return m.update(owner, name, func(s *Saved) error {
+		s.SharedWith = slices.DeleteFunc(s.SharedWith, func(id string) bool { return id == guildID })
+		if shared {
+			s.SharedWith = append(s.SharedWith, guildID)
+		}
+		return nil
+	})
+}
+
+func (m *Manager) update(owner Owner, name string, change func(*Saved) error) (*Saved, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, err := m.get(owner, strings.TrimSpace(name))
+	if err != nil {
+		return nil, err
+	}
+	if err := change(s); err != nil {
+		return nil, err
+	}
+	s.UpdatedAt = time.Now()
+	return s, m.store.Put(savedCollection, s.key(), s)
+}
+
+func validateName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > MAX_NAME_LENGTH {
+		return ErrName
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return ErrName
+		}
+	}
+	return nil
+}
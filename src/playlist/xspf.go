@@ -0,0 +1,98 @@
+package playlist
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	// The first playable location is used.
+	Locations []string `xml:"location"`
+	Title     string   `xml:"title,omitempty"`
+	// Milliseconds.
+	Duration int64 `xml:"duration,omitempty"`
+}
+
+func parseXSPF(r io.Reader) (*Playlist, error) {
+	doc := xspfPlaylist{}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	p := &Playlist{Title: strings.TrimSpace(doc.Title)}
+	for _, t := range doc.Tracks {
+		e := Entry{
+			Title:    strings.TrimSpace(t.Title),
+			Duration: time.Duration(max(t.Duration, 0)) * time.Millisecond,
+		}
+		for _, location := range t.Locations {
+			if e.Location = xspfLocation(strings.TrimSpace(location)); e.Location != "" {
+				break
+			}
+		}
+		p.add(e)
+	}
+	return p, nil
+}
+
+// Locations are URIs, relative ones are percent encoded paths.
+func xspfLocation(location string) string {
+	lower := strings.ToLower(location)
+	switch {
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"):
+		return location
+	case strings.HasPrefix(lower, "file:"):
+		return fileLocation(location)
+	case strings.Contains(location, "://"):
+		// Unsupported scheme.
+		return ""
+	}
+	path, err := url.PathUnescape(location)
+	if err != nil {
+		return ""
+	}
+	return filepath.FromSlash(path)
+}
+
+func writeXSPF(w io.Writer, p *Playlist) error {
+	doc := xspfPlaylist{Version: "1", Title: p.Title}
+	for _, e := range p.Entries {
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Locations: []string{xspfURI(e)},
+			Title:     e.Title,
+			Duration:  e.Duration.Milliseconds(),
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func xspfURI(e Entry) string {
+	if e.IsURL() {
+		return e.Location
+	}
+	u := url.URL{Path: filepath.ToSlash(e.Location)}
+	if filepath.IsAbs(e.Location) {
+		u.Scheme = "file"
+	}
+	return u.String()
+}
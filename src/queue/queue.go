@@ -0,0 +1,231 @@
+package queue
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/metadata"
+)
+
+var (
+	ErrOutOfRange  = errors.New("position out of range")
+	ErrUnknownLoop = errors.New("unknown loop mode")
+)
+
+type LoopMode = string
+
+const (
+	LoopOff LoopMode = "off"
+	// Repeats the current track until skipped.
+	LoopTrack LoopMode = "track"
+	// Finished tracks go back to the end of the queue.
+	LoopQueue LoopMode = "queue"
+)
+
+// Finished tracks kept in history.
+var MAX_HISTORY = 50
+
+type Track struct {
+	// Library name or url.
+	Name string
+	// Defaults to Name under media directory when nil.
+	Source      audio.AudioSource
+	Title       string
+	RequestedBy string // User ID
+	AddedAt     time.Time
+	// Probed when queued, may be empty.
+	Metadata metadata.Metadata
+	// Picked by autoplay rather than requested.
+	Autoplay bool
+	// Display name of the requester when queued.
+	RequesterName string
+}
+
+// Recommender picks a track to follow history, most recent last.
+type Recommender = func(history []Track) (Track, bool)
+
+// Queue of a single guild. Positions are zero based.
+type Queue struct {
+	mu       sync.Mutex
+	tracks   []Track
+	current  *Track
+	history  []Track
+	shuffler *rand.Rand
+	loop     LoopMode
+	autoplay bool
+}
+
+func NewQueue() *Queue {
+	return &Queue{
+		shuffler: rand.New(rand.NewSource(time.Now().UnixNano())),
+		loop:     LoopOff,
+	}
+}
+
+func (q *Queue) SetLoop(mode LoopMode) error {
+	switch mode {
+	case LoopOff, LoopTrack, LoopQueue:
+	default:
+		return ErrUnknownLoop
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.loop = mode
+	return nil
+}
+
+func (q *Queue) Loop() LoopMode {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.loop
+}
+
+// SetAutoplay enables queueing related tracks once the queue runs out.
+func (q *Queue) SetAutoplay(enabled bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.autoplay = enabled
+}
+
+func (q *Queue) Autoplay() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.autoplay
+}
+
+func (q *Queue) Enqueue(tracks ...Track) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.tracks = append(q.tracks, tracks...)
+}
+
+// InsertNext puts track in front of the queue.
+func (q *Queue) InsertNext(track Track) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.tracks = append([]Track{track}, q.tracks...)
+}
+
+// Dequeue makes the next track current, moving current track into history,
+// and back to the end of the queue when looping the queue. Used on skip, a
+// looped track is left.
+func (q *Queue) Dequeue() (Track, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dequeue()
+}
+
+// Advance moves on once the current track ended, repeating it when looping
+// the track.
+func (q *Queue) Advance() (Track, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.loop == LoopTrack && q.current != nil {
+		return *q.current, true
+	}
+	return q.dequeue()
+}
+
+// Must be called with mu held.
+func (q *Queue) dequeue() (Track, bool) {
+	if q.loop == LoopQueue && q.current != nil {
+		q.tracks = append(q.tracks, *q.current)
+	}
+	q.finishCurrent()
+	if len(q.tracks) == 0 {
+		return Track{}, false
+	}
+	next := q.tracks[0]
+	q.tracks = q.tracks[1:]
+	q.current = &next
+	return next, true
+}
+
+// Must be called with mu held.
+func (q *Queue) finishCurrent() {
+	if q.current == nil {
+		return
+	}
+	q.history = append(q.history, *q.current)
+	if len(q.history) > MAX_HISTORY {
+		q.history = q.history[len(q.history)-MAX_HISTORY:]
+	}
+	q.current = nil
+}
+
+// Stop clears current track without dequeuing.
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.finishCurrent()
+}
+
+func (q *Queue) Remove(position int) (Track, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if position < 0 || position >= len(q.tracks) {
+		return Track{}, ErrOutOfRange
+	}
+	removed := q.tracks[position]
+	q.tracks = append(q.tracks[:position], q.tracks[position+1:]...)
+	return removed, nil
+}
+
+func (q *Queue) Move(from, to int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if from < 0 || from >= len(q.tracks) || to < 0 || to >= len(q.tracks) {
+		return ErrOutOfRange
+	}
+	track := q.tracks[from]
+	q.tracks = append(q.tracks[:from], q.tracks[from+1:]...)
+	q.tracks = append(q.tracks[:to], append([]Track{track}, q.tracks[to:]...)...)
+	return nil
+}
+
+// Clear removes upcoming tracks, current track keeps playing.
+func (q *Queue) Clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.tracks = nil
+}
+
+func (q *Queue) Shuffle() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.shuffler.Shuffle(len(q.tracks), func(i, j int) {
+		q.tracks[i], q.tracks[j] = q.tracks[j], q.tracks[i]
+	})
+}
+
+func (q *Queue) Current() (Track, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current == nil {
+		return Track{}, false
+	}
+	return *q.current, true
+}
+
+// Tracks returns a copy of upcoming tracks.
+func (q *Queue) Tracks() []Track {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]Track(nil), q.tracks...)
+}
+
+// History returns finished tracks, most recent last.
+func (q *Queue) History() []Track {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]Track(nil), q.history...)
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tracks)
+}
@@ -0,0 +1,229 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/audioreceiver"
+	"github.com/hendrywilliam/siren/src/ogg"
+)
+
+const (
+	vendor   = "siren"
+	channels = 2
+)
+
+var (
+	ErrAlreadyRecording = errors.New("already recording")
+	ErrNotRecording     = errors.New("not recording")
+)
+
+type Options struct {
+	// Directory to write recordings into, a subdirectory is created per session.
+	Dir         string
+	MaxDuration time.Duration
+
+	Log *slog.Logger
+}
+
+// Recorder writes every user's audio in a voice channel into
+// its own ogg opus file.
+type Recorder struct {
+	mu         sync.Mutex
+	opts       Options
+	cancelFunc context.CancelFunc
+	done       chan struct{}
+}
+
+func NewRecorder(opts Options) *Recorder {
+	return &Recorder{
+		opts: opts,
+	}
+}
+
+// Start consumes the subscription's streams until Stop is called, ctx is
+// cancelled or max duration is reached, then closes it. Returns the
+// directory recordings are written to.
+func (r *Recorder) Start(ctx context.Context, sub *audioreceiver.Subscription) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancelFunc != nil {
+		sub.Close()
+		return "", ErrAlreadyRecording
+	}
+	startedAt := time.Now()
+	dir := filepath.Join(r.opts.Dir, startedAt.Format("20060102T150405"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		sub.Close()
+		return "", err
+	}
+	var recordCtx context.Context
+	if r.opts.MaxDuration > 0 {
+		recordCtx, r.cancelFunc = context.WithTimeout(ctx, r.opts.MaxDuration)
+	} else {
+		recordCtx, r.cancelFunc = context.WithCancel(ctx)
+	}
+	r.done = make(chan struct{})
+	go r.record(recordCtx, dir, startedAt, sub, r.done)
+	r.opts.Log.Info("recording started.", "dir", dir)
+	return dir, nil
+}
+
+// Stop recording and wait for every file to be finalized.
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	cancelFunc, done := r.cancelFunc, r.done
+	r.cancelFunc, r.done = nil, nil
+	r.mu.Unlock()
+	if cancelFunc == nil {
+		return ErrNotRecording
+	}
+	cancelFunc()
+	<-done
+	return nil
+}
+
+func (r *Recorder) IsRecording() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cancelFunc != nil
+}
+
+func (r *Recorder) record(ctx context.Context, dir string, startedAt time.Time, sub *audioreceiver.Subscription, done chan struct{}) {
+	defer close(done)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// Before waiting, writers see their streams end.
+	defer sub.Close()
+	streams := sub.Streams()
+	for {
+		select {
+		case <-ctx.Done():
+			r.opts.Log.Info("recording stopped.", "dir", dir)
+			// Max duration reached, release the recorder.
+			r.mu.Lock()
+			if r.done == done {
+				r.cancelFunc, r.done = nil, nil
+			}
+			r.mu.Unlock()
+			return
+		case s, ok := <-streams:
+			if !ok {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := r.recordStream(ctx, dir, startedAt, s); err != nil {
+					r.opts.Log.Error(err.Error(), "user_id", s.UserID)
+				}
+			}()
+		}
+	}
+}
+
+func (r *Recorder) recordStream(ctx context.Context, dir string, startedAt time.Time, s *audioreceiver.Stream) error {
+	// The file starts with the first packet received since the recording
+	// started, earlier ones were buffered before.
+	var first *audioreceiver.Packet
+	for first == nil {
+		select {
+		case <-ctx.Done():
+			return nil
+		case p, ok := <-s.Packets:
+			if !ok {
+				return nil
+			}
+			if !p.ReceivedAt.Before(startedAt) {
+				first = p
+			}
+		}
+	}
+	// A user may reconnect during the recording, suffix the file with join offset.
+	offset := first.ReceivedAt.Sub(startedAt)
+	name := fmt.Sprintf("%s_%d.ogg", s.UserID, offset.Milliseconds())
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	w, err := ogg.NewOpusWriter(f, h.Sum32(), ogg.OpusHead{
+		Channels:        channels,
+		InputSampleRate: ogg.OpusSampleRate,
+	}, vendor)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// Align every file to the recording start.
+	maxFrames := int(r.opts.MaxDuration / (20 * time.Millisecond))
+	if err := writeSilence(w, int(offset/(20*time.Millisecond))); err != nil {
+		return err
+	}
+
+	var expected uint32
+	written := false
+	write := func(p *audioreceiver.Packet) error {
+		if written {
+			// Fill the gap left by missing packets or the user not speaking.
+			gap := int32(p.Timestamp - expected)
+			if gap < 0 {
+				// Late or duplicated packet.
+				return nil
+			}
+			frames := int(gap) / ogg.OpusFrameSamples
+			if maxFrames > 0 && frames > maxFrames {
+				frames = maxFrames
+			}
+			if err := writeSilence(w, frames); err != nil {
+				return err
+			}
+		}
+		samples, err := ogg.OpusPacketSamples(p.Opus)
+		if err != nil {
+			return nil
+		}
+		if err := w.WritePacket(p.Opus); err != nil {
+			return err
+		}
+		written = true
+		expected = p.Timestamp + uint32(samples)
+		return nil
+	}
+	if err := write(first); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case p, ok := <-s.Packets:
+			if !ok {
+				return nil
+			}
+			if err := write(p); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeSilence(w *ogg.OpusWriter, frames int) error {
+	for i := 0; i < frames; i++ {
+		if err := w.WritePacket(ogg.OpusSilenceFrame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
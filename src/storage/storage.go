@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/hendrywilliam/siren/src/utils"
+)
+
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrInvalidCollection = errors.New("invalid collection")
+)
+
+// Store keeps JSON documents by collection and key.
+type Store interface {
+	// Get decodes the document into v, ErrNotFound if missing.
+	Get(collection, key string, v any) error
+	Put(collection, key string, v any) error
+	// Delete returns ErrNotFound if missing.
+	Delete(collection, key string) error
+	// Keys of the collection starting with prefix, sorted.
+	List(collection, prefix string) ([]string, error)
+}
+
+// FileStore keeps each collection in one JSON file under dir, loaded on
+// first use and rewritten on every change.
+type FileStore struct {
+	dir string
+
+	mu          sync.Mutex
+	collections map[string]map[string]json.RawMessage
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{
+		dir:         dir,
+		collections: make(map[string]map[string]json.RawMessage),
+	}, nil
+}
+
+func (s *FileStore) Get(collection, key string, v any) error {
+	s.mu.Lock()
+	docs, err := s.collection(collection)
+	var data json.RawMessage
+	if err == nil {
+		data = docs[key]
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *FileStore) Put(collection, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs, err := s.collection(collection)
+	if err != nil {
+		return err
+	}
+	prev, existed := docs[key]
+	docs[key] = data
+	if err := s.save(collection, docs); err != nil {
+		// Memory stays in sync with the file.
+		if existed {
+			docs[key] = prev
+		} else {
+			delete(docs, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Delete(collection, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs, err := s.collection(collection)
+	if err != nil {
+		return err
+	}
+	prev, ok := docs[key]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(docs, key)
+	if err := s.save(collection, docs); err != nil {
+		docs[key] = prev
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) List(collection, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs, err := s.collection(collection)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for key := range docs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// collection returns the documents of the collection, loading its file the
+// first time. Must be called with mu held.
+func (s *FileStore) collection(name string) (map[string]json.RawMessage, error) {
+	if docs, ok := s.collections[name]; ok {
+		return docs, nil
+	}
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	docs := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &docs); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	s.collections[name] = docs
+	return docs, nil
+}
+
+func (s *FileStore) save(name string, docs map[string]json.RawMessage) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(docs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data)
+}
+
+func (s *FileStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCollection, name)
+	}
+	return filepath.Join(s.dir, name+".json"), nil
+}
@@ -3,6 +3,29 @@ package structs
 type Command = string
 
 const (
-	CommandPlay Command = "play"
-	CommandTest Command = "test"
+	CommandPlay   Command = "play"
+	CommandTest   Command = "test"
+	CommandRecord Command = "record"
+	CommandQueue  Command = "queue"
+	CommandSkip   Command = "skip"
+	CommandRemove Command = "remove"
+	CommandClear  Command = "clear"
+
+	CommandPause      Command = "pause"
+	CommandResume     Command = "resume"
+	CommandStop       Command = "stop"
+	CommandSeek       Command = "seek"
+	CommandNowPlaying Command = "nowplaying"
+	CommandVolume     Command = "volume"
+	CommandFilter     Command = "filter"
+	CommandNormalize  Command = "normalize"
+	CommandCrossfade  Command = "crossfade"
+	CommandSFX        Command = "sfx"
+	CommandPlaylist   Command = "playlist"
+	CommandPlaylists  Command = "playlists"
+	CommandLoop       Command = "loop"
+	CommandAutoplay   Command = "autoplay"
+	CommandSay        Command = "say"
+	CommandAnnounce   Command = "announce"
+	CommandStage      Command = "stage"
 )
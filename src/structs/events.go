@@ -9,6 +9,12 @@ type EventName = string
 type EventOpcode = int
 
 const (
+	EventNameChannelCreate     EventName = "CHANNEL_CREATE"
+	EventNameChannelDelete     EventName = "CHANNEL_DELETE"
+	EventNameChannelUpdate     EventName = "CHANNEL_UPDATE"
+	EventNameGuildCreate       EventName = "GUILD_CREATE"
+	EventNameGuildDelete       EventName = "GUILD_DELETE"
+	EventNameGuildUpdate       EventName = "GUILD_UPDATE"
 	EventNameInteractionCreate EventName = "INTERACTION_CREATE"
 	EventNameReady             EventName = "READY"
 	EventNameVoiceServerUpdate EventName = "VOICE_SERVER_UPDATE"
@@ -19,11 +25,13 @@ const (
 // RawEvent has RawMessage field to delay computation.
 // Suitable for any event with "Dispatch" opcode.
 type RawEvent struct {
-	Op     EventOpcode     `json:"op"`
-	D      json.RawMessage `json:"d,omitempty"`
-	S      uint64          `json:"s,omitempty"`
-	T      EventName       `json:"t,omitempty"`
-	Struct any             // Actual D struct.
+	Op EventOpcode     `json:"op"`
+	D  json.RawMessage `json:"d,omitempty"`
+	S  uint64          `json:"s,omitempty"`
+	T  EventName       `json:"t,omitempty"`
+	// Voice gateway sequence, since version 8.
+	Seq    uint64 `json:"seq,omitempty"`
+	Struct any    // Actual D struct.
 }
 
 func (re *RawEvent) LogValue() slog.Value {
@@ -0,0 +1,21 @@
+package structs
+
+// Server boost level.
+type PremiumTier = uint8
+
+const (
+	PremiumTierNone PremiumTier = 0
+	PremiumTier1    PremiumTier = 1
+	PremiumTier2    PremiumTier = 2
+	PremiumTier3    PremiumTier = 3
+)
+
+// Guild as sent by GUILD_CREATE, channels are only set there.
+type Guild struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	OwnerID     string      `json:"owner_id"`
+	PremiumTier PremiumTier `json:"premium_tier"`
+	Channels    []Channel   `json:"channels,omitempty"`
+	Unavailable bool        `json:"unavailable,omitempty"`
+}
@@ -21,13 +21,104 @@ const (
 )
 
 type InteractionApplicationCommandData struct {
-	ID       string      `json:"id"`
-	Name     string      `json:"name"`
-	Type     uint        `json:"type"`
-	Resolved interface{} `json:"resolved,omitempty"`
-	Options  interface{} `json:"options,omitempty"`
-	GuildID  string      `json:"guild_id,omitempty"`
-	TargetID string      `json:"target_id,omitempty"`
+	ID       string                                    `json:"id"`
+	Name     string                                    `json:"name"`
+	Type     uint                                      `json:"type"`
+	Resolved interface{}                               `json:"resolved,omitempty"`
+	Options  []InteractionApplicationCommandDataOption `json:"options,omitempty"`
+	GuildID  string                                    `json:"guild_id,omitempty"`
+	TargetID string                                    `json:"target_id,omitempty"`
+}
+
+type ApplicationCommandOptionType = uint8
+
+const (
+	ApplicationCommandOptionTypeSubCommand      ApplicationCommandOptionType = 1
+	ApplicationCommandOptionTypeSubCommandGroup ApplicationCommandOptionType = 2
+	ApplicationCommandOptionTypeString          ApplicationCommandOptionType = 3
+	ApplicationCommandOptionTypeInteger         ApplicationCommandOptionType = 4
+	ApplicationCommandOptionTypeBoolean         ApplicationCommandOptionType = 5
+	ApplicationCommandOptionTypeUser            ApplicationCommandOptionType = 6
+	ApplicationCommandOptionTypeChannel         ApplicationCommandOptionType = 7
+	ApplicationCommandOptionTypeRole            ApplicationCommandOptionType = 8
+	ApplicationCommandOptionTypeMentionable     ApplicationCommandOptionType = 9
+	ApplicationCommandOptionTypeNumber          ApplicationCommandOptionType = 10
+	ApplicationCommandOptionTypeAttachment      ApplicationCommandOptionType = 11
+)
+
+// Source: https://discord.com/developers/docs/interactions/receiving-and-responding#interaction-object-application-command-interaction-data-option-structure
+type InteractionApplicationCommandDataOption struct {
+	Name    string                                    `json:"name"`
+	Type    ApplicationCommandOptionType              `json:"type"`
+	Value   interface{}                               `json:"value,omitempty"`
+	Options []InteractionApplicationCommandDataOption `json:"options,omitempty"`
+	Focused bool                                      `json:"focused,omitempty"`
+}
+
+// Focused returns the option being autocompleted, sub commands included.
+func (d *InteractionApplicationCommandData) Focused() (*InteractionApplicationCommandDataOption, bool) {
+	return findFocused(d.Options)
+}
+
+// Option looks up an option by name.
+func (d *InteractionApplicationCommandData) Option(name string) (*InteractionApplicationCommandDataOption, bool) {
+	return findOption(d.Options, name)
+}
+
+// SubCommand returns the first sub command option, if any.
+func (d *InteractionApplicationCommandData) SubCommand() (*InteractionApplicationCommandDataOption, bool) {
+	for i := range d.Options {
+		if d.Options[i].Type == ApplicationCommandOptionTypeSubCommand {
+			return &d.Options[i], true
+		}
+	}
+	return nil, false
+}
+
+func (o *InteractionApplicationCommandDataOption) Option(name string) (*InteractionApplicationCommandDataOption, bool) {
+	return findOption(o.Options, name)
+}
+
+func (o *InteractionApplicationCommandDataOption) String() string {
+	s, _ := o.Value.(string)
+	return s
+}
+
+// Integer and number values are decoded as float64.
+func (o *InteractionApplicationCommandDataOption) Int() int64 {
+	f, _ := o.Value.(float64)
+	return int64(f)
+}
+
+func (o *InteractionApplicationCommandDataOption) Float() float64 {
+	f, _ := o.Value.(float64)
+	return f
+}
+
+func (o *InteractionApplicationCommandDataOption) Bool() bool {
+	b, _ := o.Value.(bool)
+	return b
+}
+
+func findOption(options []InteractionApplicationCommandDataOption, name string) (*InteractionApplicationCommandDataOption, bool) {
+	for i := range options {
+		if options[i].Name == name {
+			return &options[i], true
+		}
+	}
+	return nil, false
+}
+
+func findFocused(options []InteractionApplicationCommandDataOption) (*InteractionApplicationCommandDataOption, bool) {
+	for i := range options {
+		if options[i].Focused {
+			return &options[i], true
+		}
+		if o, ok := findFocused(options[i].Options); ok {
+			return o, true
+		}
+	}
+	return nil, false
 }
 
 type ChannelType = uint8
@@ -132,7 +223,21 @@ type InteractionResponseDataMessage struct {
 	Poll            interface{} `json:"poll,omitempty"`
 }
 
+// Source: https://discord.com/developers/docs/interactions/application-commands#application-command-object-application-command-option-choice-structure
+type ApplicationCommandOptionChoice struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// Max choices of an autocomplete result.
+const MaxAutocompleteChoices = 25
+
+type InteractionResponseDataAutocomplete struct {
+	Choices []ApplicationCommandOptionChoice `json:"choices"`
+}
+
 type InteractionResponse struct {
-	Type InteractionResponseType        `json:"type"`
-	Data InteractionResponseDataMessage `json:"data,omitempty"`
+	Type InteractionResponseType `json:"type"`
+	// InteractionResponseDataMessage or InteractionResponseDataAutocomplete.
+	Data interface{} `json:"data,omitempty"`
 }
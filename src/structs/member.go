@@ -27,3 +27,15 @@ type Member struct {
 	Pending                bool            `json:"pending,omitempty"`
 	Permissions            string          `json:"permissions"`
 }
+
+// DisplayName is the guild nickname, else the global display name, else
+// the username.
+func (m *Member) DisplayName() string {
+	if m.Nick != "" {
+		return m.Nick
+	}
+	if m.User.GlobalName != "" {
+		return m.User.GlobalName
+	}
+	return m.User.Username
+}
@@ -0,0 +1,24 @@
+package structs
+
+import "strconv"
+
+type Permission = uint64
+
+const (
+	PermissionAdministrator  Permission = 1 << 3
+	PermissionManageChannels Permission = 1 << 4
+	PermissionManageGuild    Permission = 1 << 5
+	PermissionMuteMembers    Permission = 1 << 22
+	PermissionMoveMembers    Permission = 1 << 24
+	PermissionRequestToSpeak Permission = 1 << 32
+)
+
+// HasPermission reports whether the member has p in the channel of the
+// interaction, administrators have every permission.
+func (m *Member) HasPermission(p Permission) bool {
+	perms, err := strconv.ParseUint(m.Permissions, 10, 64)
+	if err != nil {
+		return false
+	}
+	return perms&PermissionAdministrator != 0 || perms&p == p
+}
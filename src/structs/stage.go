@@ -0,0 +1,20 @@
+package structs
+
+type StagePrivacyLevel = int
+
+const (
+	// Deprecated by Discord.
+	StagePrivacyLevelPublic    StagePrivacyLevel = 1
+	StagePrivacyLevelGuildOnly StagePrivacyLevel = 2
+)
+
+// Stage instance, a live stage of a stage channel.
+type StageInstance struct {
+	ID                    string            `json:"id"`
+	GuildID               string            `json:"guild_id"`
+	ChannelID             string            `json:"channel_id"`
+	Topic                 string            `json:"topic"`
+	PrivacyLevel          StagePrivacyLevel `json:"privacy_level"`
+	DiscoverableDisabled  bool              `json:"discoverable_disabled"`
+	GuildScheduledEventID string            `json:"guild_scheduled_event_id,omitempty"`
+}
@@ -22,10 +22,19 @@ type VoiceState struct {
 }
 
 type VoiceStateUpdate struct {
-	GuildID   string `json:"guild_id"`
-	ChannelID string `json:"channel_id"`
-	SelfMute  bool   `json:"self_mute"`
-	SelfDeaf  bool   `json:"self_deaf"`
+	GuildID   string  `json:"guild_id"`
+	ChannelID *string `json:"channel_id"` // nil to disconnect.
+	SelfMute  bool    `json:"self_mute"`
+	SelfDeaf  bool    `json:"self_deaf"`
+}
+
+// Modify Current User Voice State body, the bot must be in a stage channel.
+type ModifyCurrentUserVoiceState struct {
+	ChannelID string `json:"channel_id,omitempty"`
+	// false to become a speaker, which requires Mute Members.
+	Suppress *bool `json:"suppress,omitempty"`
+	// Raises the hand, requires Request to Speak.
+	RequestToSpeakTimestamp *time.Time `json:"request_to_speak_timestamp,omitempty"`
 }
 
 type VoiceServerUpdate struct {
@@ -45,10 +54,11 @@ type VoiceHeartbeat struct {
 
 // identify payload
 type VoiceIdentify struct {
-	ServerId  string `json:"server_id"`
-	UserID    string `json:"user_id"`
-	SessionID string `json:"session_id"`
-	Token     string `json:"token"`
+	ServerId               string `json:"server_id"`
+	UserID                 string `json:"user_id"`
+	SessionID              string `json:"session_id"`
+	Token                  string `json:"token"`
+	MaxDaveProtocolVersion uint16 `json:"max_dave_protocol_version"`
 }
 
 type VoiceReadyStreams struct {
@@ -63,6 +73,10 @@ type VoiceClientsConnect struct {
 	UserIds []string `json:"user_ids"`
 }
 
+type VoiceClientDisconnect struct {
+	UserID string `json:"user_id"`
+}
+
 type VoiceResume struct {
 	ServerID            string
 	SessionID           string
@@ -116,4 +130,29 @@ type Speaking struct {
 	Speaking int    `json:"speaking"`
 	Delay    uint   `json:"delay"`
 	SSRC     uint32 `json:"ssrc"`
+	UserID   string `json:"user_id,omitempty"` // Only present in incoming event.
+}
+
+// DAVE payloads.
+// Source: https://daveprotocol.com
+type DAVEPrepareTransition struct {
+	ProtocolVersion uint16 `json:"protocol_version"`
+	TransitionID    uint16 `json:"transition_id"`
+}
+
+type DAVEExecuteTransition struct {
+	TransitionID uint16 `json:"transition_id"`
+}
+
+type DAVETransitionReady struct {
+	TransitionID uint16 `json:"transition_id"`
+}
+
+type DAVEPrepareEpoch struct {
+	ProtocolVersion uint16 `json:"protocol_version"`
+	Epoch           uint64 `json:"epoch"`
+}
+
+type DAVEMLSInvalidCommitWelcome struct {
+	TransitionID uint16 `json:"transition_id"`
 }
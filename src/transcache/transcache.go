@@ -0,0 +1,338 @@
+package transcache
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/ffmpeg"
+	"github.com/hendrywilliam/siren/src/utils"
+)
+
+// Plays of a file before it is transcoded into the cache.
+var MIN_PLAYS = 2
+
+const (
+	ext = ".opus"
+	// File hashes by path, in the cache directory.
+	indexName = "index.json"
+)
+
+type Options struct {
+	Dir string
+	// Oldest used files are evicted above this size.
+	MaxBytes int64
+	// Defaults to slog.Default().
+	Log *slog.Logger
+}
+
+type entry struct {
+	size int64
+	used time.Time
+}
+
+// Hash of a file's content, valid while size and modification time match.
+type fileHash struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Sum     string    `json:"sum"`
+}
+
+// Cache keeps Ogg Opus transcodes of local files, addressed by the hash of
+// the file content and the encoding parameters. Transcodes are encoded as
+// they are played, gain included, so they stream through passthrough
+// without ffmpeg.
+type Cache struct {
+	dir      string
+	maxBytes int64
+	log      *slog.Logger
+
+	mu       sync.Mutex
+	entries  map[string]*entry // By key.
+	total    int64
+	hashes   map[string]fileHash // By path.
+	plays    map[string]int      // By path.
+	inflight map[string]struct{} // By path.
+	// One transcode at a time, cache filling must not starve playback.
+	sem chan struct{}
+}
+
+func NewCache(opts Options) (*Cache, error) {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:      opts.Dir,
+		maxBytes: opts.MaxBytes,
+		log:      opts.Log,
+		entries:  make(map[string]*entry),
+		hashes:   make(map[string]fileHash),
+		plays:    make(map[string]int),
+		inflight: make(map[string]struct{}),
+		sem:      make(chan struct{}, 1),
+	}
+	if c.log == nil {
+		c.log = slog.Default()
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	if err := c.loadIndex(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.log.Warn("failed to load transcode cache index.", "error", err.Error())
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Lookup returns the transcode of source with the gain and opus settings of
+// opts applied, it plays without them. Misses count as plays, the file is
+// transcoded in background once played MIN_PLAYS times. Files are hashed in
+// background too, the first play of a changed file always misses.
+func (c *Cache) Lookup(ctx context.Context, source audio.AudioSource, opts audio.EncodeOptions) (audio.AudioSource, bool) {
+	ps, ok := source.(audio.PathSource)
+	if !ok {
+		return nil, false
+	}
+	path, err := filepath.Abs(ps.Path())
+	if err != nil {
+		return nil, false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, known := c.hashes[path]
+	known = known && h.Size == info.Size() && h.ModTime.Equal(info.ModTime())
+	params := encodeParams{gainDB: opts.GainDB, opus: opts.Opus}
+	if known {
+		key := c.key(h.Sum, params)
+		if e, ok := c.entries[key]; ok {
+			e.used = time.Now()
+			// Keeps the order across restarts.
+			os.Chtimes(c.path(key), e.used, e.used)
+			return audio.NewFileSource(c.path(key)), true
+		}
+	}
+	c.plays[path]++
+	transcode := c.plays[path] >= MIN_PLAYS
+	if _, ok := c.inflight[path]; !ok && (!known || transcode) {
+		c.inflight[path] = struct{}{}
+		go c.fill(ctx, path, params, transcode)
+	}
+	return nil, false
+}
+
+// fill hashes the file and transcodes it if asked and not cached yet.
+func (c *Cache) fill(ctx context.Context, path string, params encodeParams, transcode bool) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, path)
+		c.mu.Unlock()
+	}()
+	select {
+	case c.sem <- struct{}{}:
+		defer func() { <-c.sem }()
+	case <-ctx.Done():
+		return
+	}
+	sum, info, native, err := hashFile(path)
+	if err != nil {
+		c.log.Warn("failed to hash file for transcode cache.", "path", path, "error", err.Error())
+		return
+	}
+	key := c.key(sum, params)
+	c.mu.Lock()
+	h := fileHash{Size: info.Size(), ModTime: info.ModTime(), Sum: sum}
+	rehashed := c.hashes[path] != h
+	c.hashes[path] = h
+	_, cached := c.entries[key]
+	if transcode {
+		delete(c.plays, path)
+	}
+	c.mu.Unlock()
+	if rehashed {
+		if err := c.saveIndex(); err != nil {
+			c.log.Warn("failed to save transcode cache index.", "error", err.Error())
+		}
+	}
+	if cached || (native && params.gainDB == 0) || !transcode {
+		// Opus files already stream without ffmpeg.
+		return
+	}
+	size, err := c.transcode(ctx, path, c.path(key), params)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.log.Warn("failed to transcode file into cache.", "path", path, "error", err.Error())
+		}
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &entry{size: size, used: time.Now()}
+	c.total += size
+	c.evict()
+	c.log.Debug("transcoded file into cache.", "path", path, "key", key)
+}
+
+// Written to a temporary file, renamed once ffmpeg succeeded.
+func (c *Cache) transcode(ctx context.Context, src, dst string, params encodeParams) (int64, error) {
+	tmp := dst + ".tmp"
+	args := append([]string{"-nostdin", "-y", "-i", src}, params.args()...)
+	if _, err := ffmpeg.Run(ctx, "ffmpeg", append(args, tmp), nil); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Settings a transcode is encoded with, part of its key.
+type encodeParams struct {
+	gainDB float64
+	opus   audio.OpusOptions
+}
+
+func (p encodeParams) args() []string {
+	args := []string{"-map", "0:a:0"}
+	if p.gainDB != 0 {
+		args = append(args, "-af", fmt.Sprintf("volume=%.2fdB", p.gainDB))
+	}
+	return append(append(args, p.opus.CodecArgs()...), "-f", "opus")
+}
+
+// Removes least recently used files until the cache fits.
+// Must be called with mu held.
+func (c *Cache) evict() {
+	if c.maxBytes <= 0 || c.total <= c.maxBytes {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return c.entries[a].used.Compare(c.entries[b].used)
+	})
+	for _, key := range keys {
+		if c.total <= c.maxBytes {
+			return
+		}
+		if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+			c.log.Warn("failed to evict cached file.", "key", key, "error", err.Error())
+			continue
+		}
+		c.total -= c.entries[key].size
+		delete(c.entries, key)
+	}
+}
+
+// Indexes files left by previous runs, modification time is the last use.
+func (c *Cache) load() error {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Interrupted transcode.
+			os.Remove(filepath.Join(c.dir, name))
+			continue
+		}
+		if name == indexName {
+			continue
+		}
+		key, ok := strings.CutSuffix(name, ext)
+		if !ok || f.IsDir() {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		c.entries[key] = &entry{size: info.Size(), used: info.ModTime()}
+		c.total += info.Size()
+	}
+	return nil
+}
+
+func (c *Cache) loadIndex() error {
+	data, err := os.ReadFile(filepath.Join(c.dir, indexName))
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Unmarshal(data, &c.hashes)
+}
+
+// Saved on every new hash, so restarts hit without hashing again.
+func (c *Cache) saveIndex() error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.hashes, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(c.dir, indexName), data)
+}
+
+func (c *Cache) key(sum string, params encodeParams) string {
+	return sum + "-" + paramsHash(strings.Join(params.args(), " "))
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+ext)
+}
+
+// hashFile returns the content hash and whether the file is opus that
+// passes through as is.
+func hashFile(path string) (string, os.FileInfo, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", nil, false, err
+	}
+	br := bufio.NewReaderSize(f, audio.PROBE_SIZE)
+	native := audio.DetectFormat(br) != audio.FormatAuto
+	h := sha256.New()
+	if _, err := io.Copy(h, br); err != nil {
+		return "", nil, false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), info, native, nil
+}
+
+func paramsHash(params string) string {
+	sum := sha256.Sum256([]byte(params))
+	return hex.EncodeToString(sum[:4])
+}
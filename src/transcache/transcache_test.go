@@ -0,0 +1,159 @@
+package transcache
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/audio"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func writeFile(t *testing.T, path string, size int, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cachedKeys(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, f := range files {
+		keys = append(keys, f.Name())
+	}
+	return keys
+}
+
+func TestEvictOnLoad(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeFile(t, filepath.Join(dir, "a.opus"), 100, now.Add(-3*time.Hour))
+	writeFile(t, filepath.Join(dir, "b.opus"), 100, now.Add(-time.Hour))
+	writeFile(t, filepath.Join(dir, "c.opus"), 100, now.Add(-2*time.Hour))
+	writeFile(t, filepath.Join(dir, "d.opus.tmp"), 100, now)
+	writeFile(t, filepath.Join(dir, "notes.txt"), 100, now)
+
+	c, err := NewCache(Options{Dir: dir, MaxBytes: 150, Log: discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Least recently used first, interrupted transcodes are dropped and
+	// other files left alone.
+	if got := cachedKeys(t, dir); !slices.Equal(got, []string{"b.opus", "notes.txt"}) {
+		t.Fatalf("left %v", got)
+	}
+	if c.total != 100 || len(c.entries) != 1 {
+		t.Fatalf("total %d of %d entries", c.total, len(c.entries))
+	}
+}
+
+func TestLookupKeepsUsedFiles(t *testing.T) {
+	dir, media := t.TempDir(), t.TempDir()
+	track := filepath.Join(media, "track.mp3")
+	writeFile(t, track, 1000, time.Now().Add(-time.Hour))
+	sum, info, _, err := hashFile(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCache(Options{Dir: dir, MaxBytes: 250, Log: discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := audio.EncodeOptions{GainDB: -3}
+	key := c.key(sum, encodeParams{gainDB: opts.GainDB, opus: opts.Opus})
+
+	old := time.Now().Add(-time.Hour)
+	c.mu.Lock()
+	c.hashes[track] = fileHash{Size: info.Size(), ModTime: info.ModTime(), Sum: sum}
+	for _, k := range []string{key, "older"} {
+		writeFile(t, c.path(k), 100, old)
+		c.entries[k] = &entry{size: 100, used: old}
+		c.total += 100
+	}
+	c.mu.Unlock()
+
+	cached, ok := c.Lookup(context.Background(), audio.NewFileSource(track), opts)
+	if !ok || cached.(audio.PathSource).Path() != c.path(key) {
+		t.Fatalf("lookup missed, %v", cached)
+	}
+	// Other settings are another transcode.
+	if _, ok := c.Lookup(context.Background(), audio.NewFileSource(track), audio.EncodeOptions{}); ok {
+		t.Fatal("hit without the gain")
+	}
+
+	c.mu.Lock()
+	writeFile(t, c.path("newest"), 100, time.Now())
+	c.entries["newest"] = &entry{size: 100, used: time.Now()}
+	c.total += 100
+	c.evict()
+	c.mu.Unlock()
+	if got := cachedKeys(t, dir); !slices.Equal(got, []string{filepath.Base(c.path(key)), "newest.opus"}) {
+		t.Fatalf("left %v", got)
+	}
+
+	// The lookup refreshed the file time, a restart keeps the same order.
+	c, err = NewCache(Options{Dir: dir, MaxBytes: 100, Log: discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cachedKeys(t, dir); !slices.Equal(got, []string{"newest.opus"}) {
+		t.Fatalf("left after restart %v", got)
+	}
+}
+
+func TestLookupHashesInBackground(t *testing.T) {
+	prev := MIN_PLAYS
+	MIN_PLAYS = 3
+	t.Cleanup(func() { MIN_PLAYS = prev })
+	dir, media := t.TempDir(), t.TempDir()
+	track := filepath.Join(media, "track.mp3")
+	writeFile(t, track, 1000, time.Now().Add(-time.Hour))
+
+	c, err := NewCache(Options{Dir: dir, Log: discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Lookup(context.Background(), audio.NewFileSource(track), audio.EncodeOptions{}); ok {
+		t.Fatal("first play hit")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		_, hashed := c.hashes[track]
+		_, inflight := c.inflight[track]
+		c.mu.Unlock()
+		if hashed && !inflight {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("file not hashed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.plays[track] != 1 {
+		t.Fatalf("%d plays counted", c.plays[track])
+	}
+
+	// Hashes are kept across restarts.
+	c, err = NewCache(Options{Dir: dir, Log: discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, ok := c.hashes[track]; !ok || h.Size != 1000 {
+		t.Fatalf("hash not loaded, %+v", h)
+	}
+}
@@ -0,0 +1,46 @@
+package tts
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrUnknownMode = errors.New("unknown announcement mode")
+
+type Mode = string
+
+const (
+	ModeOff Mode = "off"
+	// Spoken before the track, which starts once it ends.
+	ModeBefore Mode = "before"
+	// Mixed over the start of the track.
+	ModeOver Mode = "over"
+)
+
+// Announcements setting of a guild, off by default.
+type Announcements struct {
+	mu   sync.Mutex
+	mode Mode
+}
+
+func NewAnnouncements() *Announcements {
+	return &Announcements{mode: ModeOff}
+}
+
+func (a *Announcements) Set(mode Mode) error {
+	switch mode {
+	case ModeOff, ModeBefore, ModeOver:
+	default:
+		return ErrUnknownMode
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.mode = mode
+	return nil
+}
+
+func (a *Announcements) Mode() Mode {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.mode
+}
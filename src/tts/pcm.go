@@ -0,0 +1,74 @@
+package tts
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/hendrywilliam/siren/src/audio"
+)
+
+var ErrInvalidWAV = errors.New("invalid wav output")
+
+// parseWAV returns the samples of 16 bit PCM wav. Streamed wav may carry
+// a bogus data size, the data then runs to the end.
+func parseWAV(b []byte) (samples []byte, rate, channels int, err error) {
+	if len(b) < 12 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return nil, 0, 0, ErrInvalidWAV
+	}
+	for off := 12; off+8 <= len(b); {
+		id := string(b[off : off+4])
+		size := int(binary.LittleEndian.Uint32(b[off+4:]))
+		body := b[off+8:]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, 0, 0, ErrInvalidWAV
+			}
+			format := binary.LittleEndian.Uint16(body[0:])
+			channels = int(binary.LittleEndian.Uint16(body[2:]))
+			rate = int(binary.LittleEndian.Uint32(body[4:]))
+			bits := binary.LittleEndian.Uint16(body[14:])
+			if format != 1 || bits != 16 || channels < 1 || rate <= 0 {
+				return nil, 0, 0, ErrInvalidWAV
+			}
+		case "data":
+			if channels == 0 {
+				return nil, 0, 0, ErrInvalidWAV
+			}
+			if size <= 0 || size > len(body) {
+				size = len(body)
+			}
+			return body[:size], rate, channels, nil
+		}
+		// Chunks are padded to an even size.
+		off += 8 + size + size%2
+	}
+	return nil, 0, 0, ErrInvalidWAV
+}
+
+// resample converts signed 16 bit little endian samples to 48kHz stereo,
+// interpolating linearly. Mono is copied to both channels.
+func resample(samples []byte, rate, channels int) []byte {
+	frames := len(samples) / (2 * channels)
+	if frames == 0 || rate <= 0 {
+		return nil
+	}
+	sample := func(frame, ch int) float64 {
+		i := (frame*channels + min(ch, channels-1)) * 2
+		return float64(int16(binary.LittleEndian.Uint16(samples[i:])))
+	}
+	out := int(int64(frames) * audio.PCMSampleRate / int64(rate))
+	pcm := make([]byte, out*audio.PCMChannels*2)
+	for i := 0; i < out; i++ {
+		pos := float64(i) * float64(rate) / audio.PCMSampleRate
+		j := int(pos)
+		frac := pos - float64(j)
+		k := min(j+1, frames-1)
+		for ch := 0; ch < audio.PCMChannels; ch++ {
+			a, b := sample(j, ch), sample(k, ch)
+			v := int16(a + (b-a)*frac)
+			binary.LittleEndian.PutUint16(pcm[(i*audio.PCMChannels+ch)*2:], uint16(v))
+		}
+	}
+	return pcm
+}
@@ -0,0 +1,201 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hendrywilliam/siren/src/audio"
+)
+
+var (
+	ErrUnknownEngine = errors.New("unknown tts engine")
+	ErrNoModel       = errors.New("piper needs a voice model")
+	ErrEmptyText     = errors.New("nothing to say")
+)
+
+type Engine = string
+
+const (
+	EngineEspeak Engine = "espeak-ng"
+	EnginePiper  Engine = "piper"
+)
+
+// Longer text is cut.
+var MAX_TEXT_LENGTH = 300
+
+// Engines stuck on odd input are killed after this.
+var SYNTHESIS_TIMEOUT = 30 * time.Second
+
+// Sample rate of piper models without a readable config.
+var DEFAULT_PIPER_SAMPLE_RATE = 22050
+
+type Options struct {
+	// Defaults to espeak-ng.
+	Engine Engine
+	// espeak-ng voice name, e.g. "en-us", or piper model path.
+	Voice string
+	// Engine binary, defaults to the engine name looked up in PATH.
+	Path string
+	// Defaults to slog.Default().
+	Log *slog.Logger
+}
+
+// Speaker synthesizes speech with a locally installed engine.
+type Speaker struct {
+	engine Engine
+	voice  string
+	path   string
+	// Of piper output, espeak-ng writes wav.
+	sampleRate int
+	log        *slog.Logger
+}
+
+func NewSpeaker(opts Options) (*Speaker, error) {
+	s := &Speaker{
+		engine: opts.Engine,
+		voice:  opts.Voice,
+		path:   opts.Path,
+		log:    opts.Log,
+	}
+	if s.engine == "" {
+		s.engine = EngineEspeak
+	}
+	if s.log == nil {
+		s.log = slog.Default()
+	}
+	switch s.engine {
+	case EngineEspeak:
+	case EnginePiper:
+		if s.voice == "" {
+			return nil, ErrNoModel
+		}
+		s.sampleRate = piperSampleRate(s.voice)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEngine, s.engine)
+	}
+	if s.path == "" {
+		s.path = s.engine
+	}
+	path, err := exec.LookPath(s.path)
+	if err != nil {
+		return nil, err
+	}
+	s.path = path
+	return s, nil
+}
+
+// Synthesize returns text spoken as 48kHz stereo signed 16 bit PCM.
+func (s *Speaker) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	text = clean(text)
+	if text == "" {
+		return nil, ErrEmptyText
+	}
+	ctx, cancel := context.WithTimeout(ctx, SYNTHESIS_TIMEOUT)
+	defer cancel()
+	var args []string
+	switch s.engine {
+	case EnginePiper:
+		args = []string{"--model", s.voice, "--output_raw"}
+	default:
+		args = []string{"--stdout", "--stdin"}
+		if s.voice != "" {
+			args = append(args, "-v", s.voice)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.path, args...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", s.engine, err, strings.TrimSpace(stderr.String()))
+	}
+	if s.engine == EnginePiper {
+		return resample(stdout.Bytes(), s.sampleRate, 1), nil
+	}
+	samples, rate, channels, err := parseWAV(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.engine, err)
+	}
+	return resample(samples, rate, channels), nil
+}
+
+// Source speaks text when opened, it can be opened again.
+type Source struct {
+	speaker *Speaker
+	text    string
+	// Failures play nothing rather than failing the stream.
+	quiet bool
+}
+
+// Source returns text as an audio source.
+func (s *Speaker) Source(text string) *Source {
+	return &Source{speaker: s, text: text}
+}
+
+// Announcement is a source that plays nothing if synthesis fails, so it
+// never keeps the track it announces from playing.
+func (s *Speaker) Announcement(text string) *Source {
+	return &Source{speaker: s, text: text, quiet: true}
+}
+
+func (s *Source) Open(ctx context.Context) (io.ReadCloser, error) {
+	pcm, err := s.speaker.Synthesize(ctx, s.text)
+	if err != nil {
+		if !s.quiet {
+			return nil, err
+		}
+		if ctx.Err() == nil {
+			s.speaker.log.Warn("announcement failed.", "error", err.Error())
+		}
+		pcm = nil
+	}
+	return io.NopCloser(bytes.NewReader(pcm)), nil
+}
+
+func (s *Source) Format() audio.SourceFormat {
+	return audio.FormatPCM
+}
+
+func (s *Source) String() string {
+	return "tts: " + s.text
+}
+
+// One line, control characters removed, at most MAX_TEXT_LENGTH runes.
+func clean(text string) string {
+	text = strings.Join(strings.FieldsFunc(text, func(r rune) bool {
+		return r < ' ' || r == 0x7f
+	}), " ")
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > MAX_TEXT_LENGTH {
+		text = string([]rune(text)[:MAX_TEXT_LENGTH])
+	}
+	return text
+}
+
+// Sample rate from the model config piper reads next to the model.
+func piperSampleRate(model string) int {
+	data, err := os.ReadFile(model + ".json")
+	if err != nil {
+		return DEFAULT_PIPER_SAMPLE_RATE
+	}
+	config := struct {
+		Audio struct {
+			SampleRate int `json:"sample_rate"`
+		} `json:"audio"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil || config.Audio.SampleRate <= 0 {
+		return DEFAULT_PIPER_SAMPLE_RATE
+	}
+	return config.Audio.SampleRate
+}
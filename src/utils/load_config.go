@@ -4,32 +4,66 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type AppConfig struct {
-	DiscordClientID            string
-	DiscordBotToken            string
-	DiscordPublicKey           string
-	DiscordOauth2Token         string
-	DiscordGatewayVersion      string
-	DiscordVoiceGatewayVersion string
-	DiscordHTTPBaseURL         string
-	DiscordGatewayAddress      string
-	AppEnv                     string
+	DiscordClientID       string
+	DiscordBotToken       string
+	DiscordPublicKey      string
+	DiscordOauth2Token    string
+	DiscordGatewayVersion string
+	DiscordHTTPBaseURL    string
+	DiscordGatewayAddress string
+	AppEnv                string
+
+	// Optional.
+	RecordingDir         string
+	RecordingMaxDuration time.Duration
+	VoiceIdleTimeout     time.Duration
+	LoudnessCache        string
+	LoudnessTarget       float64
+	SFXDir               string
+	MediaDirs            []string
+	LibraryIndex         string
+	// Media dirs are polled, 0 only scans at startup.
+	LibraryScanInterval time.Duration
+	PlaylistDir         string
+	StorageDir          string
+	TranscodeCacheDir   string
+	// Megabytes.
+	TranscodeCacheSize int64
+	FFmpegMaxProcesses int64
+	// Opus encoder overrides, 0 tunes to the channel. A negative packet
+	// loss disables forward error correction.
+	OpusBitrate       int64
+	OpusFrameDuration time.Duration
+	OpusPacketLoss    int64
+	AudioBuffer       time.Duration
+	AudioPreRoll      time.Duration
+	// espeak-ng or piper, TTSVoice is the piper model path.
+	TTSEngine string
+	TTSVoice  string
+	TTSPath   string
+	// Hosts urls may be played from, none by default.
+	AllowedHosts []string
+	// DAVE end-to-end encryption, off until checked against libdave.
+	DAVE bool
 }
 
 func LoadConfiguration() AppConfig {
 	cfg := AppConfig{}
 	requiredEnv := map[string]*string{
-		"DC_APPLICATION_ID":        &cfg.DiscordClientID,
-		"DC_BOT_TOKEN":             &cfg.DiscordBotToken,
-		"DC_PUBLIC_KEY":            &cfg.DiscordPublicKey,
-		"DC_OAUTH2_TOKEN":          &cfg.DiscordOauth2Token,
-		"DC_GATEWAY_VERSION":       &cfg.DiscordGatewayVersion,
-		"DC_VOICE_GATEWAY_VERSION": &cfg.DiscordVoiceGatewayVersion,
-		"DC_HTTP_BASE_URL":         &cfg.DiscordHTTPBaseURL,
-		"DC_GATEWAY_ADDRESS":       &cfg.DiscordGatewayAddress,
-		"APP_ENV":                  &cfg.AppEnv,
+		"DC_APPLICATION_ID":  &cfg.DiscordClientID,
+		"DC_BOT_TOKEN":       &cfg.DiscordBotToken,
+		"DC_PUBLIC_KEY":      &cfg.DiscordPublicKey,
+		"DC_OAUTH2_TOKEN":    &cfg.DiscordOauth2Token,
+		"DC_GATEWAY_VERSION": &cfg.DiscordGatewayVersion,
+		"DC_HTTP_BASE_URL":   &cfg.DiscordHTTPBaseURL,
+		"DC_GATEWAY_ADDRESS": &cfg.DiscordGatewayAddress,
+		"APP_ENV":            &cfg.AppEnv,
 	}
 	for k, v := range requiredEnv {
 		if val, ok := os.LookupEnv(k); !ok {
@@ -39,5 +73,99 @@ func LoadConfiguration() AppConfig {
 			*v = val
 		}
 	}
+	cfg.RecordingDir = lookupEnvDefault("RECORDING_DIR", "./recordings")
+	cfg.RecordingMaxDuration = lookupDurationEnvDefault("RECORDING_MAX_DURATION", 2*time.Hour)
+	cfg.VoiceIdleTimeout = lookupDurationEnvDefault("VOICE_IDLE_TIMEOUT", 5*time.Minute)
+	cfg.LoudnessCache = lookupEnvDefault("LOUDNESS_CACHE", "./loudness.json")
+	cfg.LoudnessTarget = lookupFloatEnvDefault("LOUDNESS_TARGET", -14)
+	cfg.SFXDir = lookupEnvDefault("SFX_DIR", "./sfx")
+	cfg.MediaDirs = lookupListEnvDefault("MEDIA_DIRS", "./media")
+	cfg.LibraryIndex = lookupEnvDefault("LIBRARY_INDEX", "./library.json")
+	cfg.LibraryScanInterval = lookupDurationEnvDefault("LIBRARY_SCAN_INTERVAL", time.Minute)
+	cfg.PlaylistDir = lookupEnvDefault("PLAYLIST_DIR", "./media")
+	cfg.StorageDir = lookupEnvDefault("STORAGE_DIR", "./data")
+	cfg.TranscodeCacheDir = lookupEnvDefault("TRANSCODE_CACHE_DIR", "./cache")
+	cfg.TranscodeCacheSize = lookupIntEnvDefault("TRANSCODE_CACHE_SIZE", 2048)
+	cfg.FFmpegMaxProcesses = lookupIntEnvDefault("FFMPEG_MAX_PROCESSES", 32)
+	cfg.OpusBitrate = lookupIntEnvDefault("OPUS_BITRATE", 0)
+	cfg.OpusFrameDuration = lookupDurationEnvDefault("OPUS_FRAME_DURATION", 0)
+	cfg.OpusPacketLoss = lookupIntEnvDefault("OPUS_PACKET_LOSS", 0)
+	cfg.AudioBuffer = lookupDurationEnvDefault("AUDIO_BUFFER", time.Second)
+	cfg.AudioPreRoll = lookupDurationEnvDefault("AUDIO_PREROLL", 200*time.Millisecond)
+	cfg.TTSEngine = lookupEnvDefault("TTS_ENGINE", "espeak-ng")
+	cfg.TTSVoice = lookupEnvDefault("TTS_VOICE", "")
+	cfg.TTSPath = lookupEnvDefault("TTS_PATH", "")
+	cfg.AllowedHosts = lookupListEnvDefault("ALLOWED_HOSTS", "")
+	cfg.DAVE = lookupBoolEnvDefault("DAVE", false)
 	return cfg
 }
+
+func lookupEnvDefault(key string, def string) string {
+	if val, ok := os.LookupEnv(key); ok && val != "" {
+		return val
+	}
+	return def
+}
+
+// Comma separated, blank items are dropped.
+func lookupListEnvDefault(key string, def string) []string {
+	var list []string
+	for _, item := range strings.Split(lookupEnvDefault(key, def), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func lookupDurationEnvDefault(key string, def time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok || val == "" {
+		return def
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Invalid duration: %s", key))
+		os.Exit(1)
+	}
+	return d
+}
+
+func lookupFloatEnvDefault(key string, def float64) float64 {
+	val, ok := os.LookupEnv(key)
+	if !ok || val == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Invalid number: %s", key))
+		os.Exit(1)
+	}
+	return f
+}
+
+func lookupIntEnvDefault(key string, def int64) int64 {
+	val, ok := os.LookupEnv(key)
+	if !ok || val == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Invalid integer: %s", key))
+		os.Exit(1)
+	}
+	return n
+}
+
+func lookupBoolEnvDefault(key string, def bool) bool {
+	val, ok := os.LookupEnv(key)
+	if !ok || val == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Invalid boolean: %s", key))
+		os.Exit(1)
+	}
+	return b
+}
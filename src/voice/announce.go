@@ -0,0 +1,68 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/queue"
+	"github.com/hendrywilliam/siren/src/tts"
+)
+
+var ErrNoSpeaker = errors.New("text to speech is not available")
+
+// Say speaks text over the music, or on its own if nothing is playing.
+func (v *Voice) Say(text string) error {
+	if v.speaker == nil {
+		return ErrNoSpeaker
+	}
+	return v.PlayOverlay(v.speaker.Source(text))
+}
+
+// announce returns the stream of the track with its announcement: mixed
+// over the start of the track, or spoken first with the track following
+// gapless. source and opts are returned as is without announcement.
+// Must be called with playMu held.
+func (v *Voice) announce(ctx context.Context, track queue.Track, source audio.AudioSource, opts audio.EncodeOptions) (audio.AudioSource, audio.EncodeOptions) {
+	if v.speaker == nil || v.announcements == nil || v.announcement == nil {
+		return source, opts
+	}
+	mode := v.announcements.Mode()
+	if mode == tts.ModeOff {
+		return source, opts
+	}
+	text := v.announcement(track)
+	if text == "" {
+		return source, opts
+	}
+	announcement := v.speaker.Announcement(text)
+	if mode == tts.ModeOver {
+		// Synthesis takes a moment, passthrough switches to mixing once
+		// the overlay starts.
+		go func() {
+			if err := v.overlay.Add(ctx, announcement, v.volume.Gain); err != nil && ctx.Err() == nil {
+				v.log.Warn("announcement failed.", "error", err.Error())
+			}
+		}()
+		return source, opts
+	}
+	// Position stays at the start of the track until it follows.
+	v.trackStart = time.Duration(math.MaxInt64)
+	return announcement, audio.EncodeOptions{
+		Volume:  v.volume,
+		Overlay: v.overlay,
+		Opus:    v.opus,
+		Next: func(position time.Duration) (audio.AudioSource, audio.EncodeOptions, bool) {
+			v.playMu.Lock()
+			defer v.playMu.Unlock()
+			if v.audioCtx != ctx {
+				// Skipped meanwhile.
+				return nil, audio.EncodeOptions{}, false
+			}
+			v.trackStart = position
+			return source, opts, true
+		},
+	}
+}
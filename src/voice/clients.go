@@ -0,0 +1,101 @@
+package voice
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+func (v *Voice) acceptClientsEvent(e *structs.RawEvent) error {
+	switch e.Op {
+	case OpcodeClientsConnect:
+		d := &structs.VoiceClientsConnect{}
+		if err := json.Unmarshal(e.D, d); err != nil {
+			return err
+		}
+		v.log.Info("event", "clients_connect", d)
+		v.clientsMu.Lock()
+		for _, userID := range d.UserIds {
+			if userID != v.UserID {
+				v.clients[userID] = struct{}{}
+			}
+		}
+		v.clientsMu.Unlock()
+	case OpcodeClientDisconnect:
+		d := &structs.VoiceClientDisconnect{}
+		if err := json.Unmarshal(e.D, d); err != nil {
+			return err
+		}
+		v.log.Info("event", "client_disconnect", d)
+		v.clientsMu.Lock()
+		delete(v.clients, d.UserID)
+		v.clientsMu.Unlock()
+		v.audioReceiver.RemoveUser(d.UserID)
+	default:
+		return ErrUnrecognizedEvent
+	}
+	v.updateOccupancy()
+	return nil
+}
+
+// Clients returns the ids of users connected to the voice channel, the bot excluded.
+func (v *Voice) Clients() []string {
+	v.clientsMu.Lock()
+	defer v.clientsMu.Unlock()
+	ids := make([]string, 0, len(v.clients))
+	for id := range v.clients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (v *Voice) IsEmpty() bool {
+	v.clientsMu.Lock()
+	defer v.clientsMu.Unlock()
+	return len(v.clients) == 0
+}
+
+// Pause playback while nobody is listening, leave once idle for too long.
+func (v *Voice) updateOccupancy() {
+	v.clientsMu.Lock()
+	defer v.clientsMu.Unlock()
+	if len(v.clients) == 0 {
+		if !v.audioSender.IsPaused() {
+			v.audioSender.Pause()
+			v.autoPaused = true
+			v.log.Info("channel is empty, playback paused.")
+		}
+		if v.idleTimer == nil && v.idleTimeout > 0 {
+			v.idleTimer = time.AfterFunc(v.idleTimeout, v.onIdleTimeout)
+		}
+		return
+	}
+	if v.idleTimer != nil {
+		v.idleTimer.Stop()
+		v.idleTimer = nil
+	}
+	if v.autoPaused {
+		v.autoPaused = false
+		v.audioSender.Resume()
+		v.log.Info("listener joined, playback resumed.")
+	}
+}
+
+func (v *Voice) onIdleTimeout() {
+	v.clientsMu.Lock()
+	v.idleTimer = nil
+	empty := len(v.clients) == 0
+	v.clientsMu.Unlock()
+	if !empty {
+		return
+	}
+	v.log.Info("idle timeout reached, leaving voice channel.", "idle_timeout", v.idleTimeout.String())
+	if v.onIdle != nil {
+		v.onIdle()
+		return
+	}
+	v.Close()
+}
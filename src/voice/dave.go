@@ -0,0 +1,143 @@
+package voice
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/hendrywilliam/siren/src/dave"
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+func (v *Voice) acceptDAVEEvent(e *structs.RawEvent) error {
+	switch e.Op {
+	case DAVEPrepareTransition:
+		d := &structs.DAVEPrepareTransition{}
+		if err := json.Unmarshal(e.D, d); err != nil {
+			return err
+		}
+		v.log.Info("event", "dave_prepare_transition", d)
+		if v.dave.PrepareTransition(d.TransitionID, d.ProtocolVersion) {
+			return v.sendTransitionReady(d.TransitionID)
+		}
+		return nil
+	case DAVEExecuteTransition:
+		d := &structs.DAVEExecuteTransition{}
+		if err := json.Unmarshal(e.D, d); err != nil {
+			return err
+		}
+		v.log.Info("event", "dave_execute_transition", d)
+		return v.dave.ExecuteTransition(d.TransitionID)
+	case DAVEPrepareEpoch:
+		d := &structs.DAVEPrepareEpoch{}
+		if err := json.Unmarshal(e.D, d); err != nil {
+			return err
+		}
+		v.log.Info("event", "dave_prepare_epoch", d)
+		keyPackage, err := v.dave.PrepareEpoch(d.ProtocolVersion, d.Epoch, v.groupID())
+		if err != nil {
+			return err
+		}
+		if keyPackage == nil {
+			return nil
+		}
+		return v.sendBinaryEvent(DAVEMLSKeyPackage, keyPackage)
+	default:
+		return ErrUnrecognizedEvent
+	}
+}
+
+func (v *Voice) acceptBinaryEvent(rawMessage []byte) error {
+	m, err := dave.DecodeBinaryMessage(rawMessage)
+	if err != nil {
+		return err
+	}
+	v.sequence.Store(uint64(m.Sequence))
+	v.log.Info("event", "dave_binary_opcode", m.Opcode)
+
+	switch VoiceOpcode(m.Opcode) {
+	case DAVEMLSExternalSender:
+		return v.dave.SetExternalSender(m.Payload)
+	case DAVEMLSProposals:
+		commitWelcome, err := v.dave.ProcessProposals(m.Payload)
+		if err != nil {
+			return err
+		}
+		if commitWelcome == nil {
+			return nil
+		}
+		return v.sendBinaryEvent(DAVECommitWelcome, commitWelcome)
+	case DAVEAnnounceCommitTransition:
+		transitionID, commit, err := dave.DecodeTransitionPayload(m.Payload)
+		if err != nil {
+			return err
+		}
+		err = v.dave.ProcessCommit(transitionID, commit)
+		if errors.Is(err, dave.ErrIgnoredCommit) {
+			return nil
+		}
+		if err != nil {
+			v.log.Error(err.Error())
+			return v.recoverInvalidTransition(transitionID)
+		}
+		return v.sendTransitionReady(transitionID)
+	case DAVEMLSWelcome:
+		transitionID, welcome, err := dave.DecodeTransitionPayload(m.Payload)
+		if err != nil {
+			return err
+		}
+		if err := v.dave.ProcessWelcome(transitionID, welcome); err != nil {
+			v.log.Error(err.Error())
+			return v.recoverInvalidTransition(transitionID)
+		}
+		return v.sendTransitionReady(transitionID)
+	default:
+		return ErrUnrecognizedEvent
+	}
+}
+
+// Flag the commit or welcome as invalid and rejoin the group with a new key package.
+func (v *Voice) recoverInvalidTransition(transitionID uint16) error {
+	err := v.sendJSONEvent(DAVEMLSInvalidCommitWelcome, &structs.DAVEMLSInvalidCommitWelcome{
+		TransitionID: transitionID,
+	})
+	if err != nil {
+		return err
+	}
+	keyPackage, err := v.dave.Reset(v.dave.ProtocolVersion(), v.groupID())
+	if err != nil {
+		return err
+	}
+	return v.sendBinaryEvent(DAVEMLSKeyPackage, keyPackage)
+}
+
+func (v *Voice) sendTransitionReady(transitionID uint16) error {
+	if transitionID == dave.InitTransitionID {
+		return nil
+	}
+	return v.sendJSONEvent(DAVETransitionReady, &structs.DAVETransitionReady{
+		TransitionID: transitionID,
+	})
+}
+
+func (v *Voice) sendJSONEvent(op VoiceOpcode, d any) error {
+	data, err := json.Marshal(&structs.Event{
+		Op: op,
+		D:  d,
+	})
+	if err != nil {
+		return err
+	}
+	return v.sendEvent(websocket.TextMessage, data)
+}
+
+func (v *Voice) sendBinaryEvent(op VoiceOpcode, payload []byte) error {
+	return v.sendEvent(websocket.BinaryMessage, dave.EncodeBinaryMessage(uint8(op), payload))
+}
+
+// MLS group id is the voice channel id.
+func (v *Voice) groupID() uint64 {
+	id, _ := strconv.ParseUint(v.ChannelID, 10, 64)
+	return id
+}
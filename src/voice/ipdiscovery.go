@@ -0,0 +1,120 @@
+package voice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+// IP discovery packet.
+// Source: https://discord.com/developers/docs/topics/voice-connections#ip-discovery
+const (
+	IPDiscoveryTypeRequest  uint16 = 0x1
+	IPDiscoveryTypeResponse uint16 = 0x2
+
+	// Length of the packet excluding type and length fields.
+	IPDiscoveryLength     uint16 = 70
+	IPDiscoveryPacketSize        = 74
+
+	IPDiscoveryTimeout     = 2 * time.Second
+	IPDiscoveryMaxAttempts = 3
+)
+
+var (
+	ErrIPDiscoveryMalformed    = errors.New("malformed ip discovery packet")
+	ErrIPDiscoveryUnexpected   = errors.New("unexpected ip discovery packet type")
+	ErrIPDiscoverySSRCMismatch = errors.New("ip discovery ssrc mismatch")
+)
+
+// Encode ip discovery request packet.
+func encodeIPDiscovery(d *structs.VoiceIPDiscovery) []byte {
+	packet := make([]byte, 0, IPDiscoveryPacketSize)
+	packet = binary.BigEndian.AppendUint16(packet, d.Type)
+	packet = binary.BigEndian.AppendUint16(packet, d.Length)
+	packet = binary.BigEndian.AppendUint32(packet, d.SSRC)
+	packet = append(packet, d.Address[:]...)
+	packet = binary.BigEndian.AppendUint16(packet, d.Port)
+	return packet
+}
+
+// Decode ip discovery response packet.
+// It only validates the packet shape, caller must validate the ssrc.
+func decodeIPDiscovery(b []byte) (*structs.VoiceIPDiscovery, error) {
+	if len(b) < IPDiscoveryPacketSize {
+		return nil, fmt.Errorf("%w: got %d bytes", ErrIPDiscoveryMalformed, len(b))
+	}
+	d := &structs.VoiceIPDiscovery{
+		Type:   binary.BigEndian.Uint16(b[0:2]),
+		Length: binary.BigEndian.Uint16(b[2:4]),
+		SSRC:   binary.BigEndian.Uint32(b[4:8]),
+		Port:   binary.BigEndian.Uint16(b[72:74]),
+	}
+	copy(d.Address[:], b[8:72])
+	if d.Type != IPDiscoveryTypeResponse {
+		return nil, fmt.Errorf("%w: 0x%x", ErrIPDiscoveryUnexpected, d.Type)
+	}
+	if d.Length != IPDiscoveryLength {
+		return nil, fmt.Errorf("%w: length %d", ErrIPDiscoveryMalformed, d.Length)
+	}
+	return d, nil
+}
+
+// readIPDiscovery reads packets until the response for ssrc or deadline.
+// Stray and mismatched packets are skipped, the last of their errors is
+// returned if the deadline passes.
+func readIPDiscovery(conn net.Conn, ssrc uint32, deadline time.Time) (string, uint16, error) {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return "", 0, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	var skipped error
+	// Larger than a response to tell oversized packets apart.
+	b := make([]byte, IPDiscoveryPacketSize+1)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			if skipped != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+				return "", 0, fmt.Errorf("%w (%w)", err, skipped)
+			}
+			return "", 0, err
+		}
+		if n != IPDiscoveryPacketSize {
+			skipped = fmt.Errorf("%w: got %d bytes", ErrIPDiscoveryMalformed, n)
+			continue
+		}
+		d, err := decodeIPDiscovery(b[:n])
+		if err != nil {
+			skipped = err
+			continue
+		}
+		if d.SSRC != ssrc {
+			skipped = fmt.Errorf("%w: expected %d, got %d", ErrIPDiscoverySSRCMismatch, ssrc, d.SSRC)
+			continue
+		}
+		ipAddr, err := ipDiscoveryAddress(d)
+		if err != nil {
+			return "", 0, err
+		}
+		return ipAddr, d.Port, nil
+	}
+}
+
+// Address is null-terminated.
+func ipDiscoveryAddress(d *structs.VoiceIPDiscovery) (string, error) {
+	n := bytes.IndexByte(d.Address[:], 0)
+	if n < 0 {
+		n = len(d.Address)
+	}
+	addr := string(d.Address[:n])
+	if net.ParseIP(addr) == nil {
+		return "", fmt.Errorf("%w: invalid address %q", ErrIPDiscoveryMalformed, addr)
+	}
+	return addr, nil
+}
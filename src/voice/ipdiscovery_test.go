@@ -0,0 +1,138 @@
+package voice
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+func response(ssrc uint32, addr string, port uint16) *structs.VoiceIPDiscovery {
+	d := &structs.VoiceIPDiscovery{
+		Type:   IPDiscoveryTypeResponse,
+		Length: IPDiscoveryLength,
+		SSRC:   ssrc,
+		Port:   port,
+	}
+	copy(d.Address[:], addr)
+	return d
+}
+
+func TestIPDiscoveryRoundTrip(t *testing.T) {
+	want := response(0xdeadbeef, "203.0.113.7", 50004)
+	packet := encodeIPDiscovery(want)
+	if len(packet) != IPDiscoveryPacketSize {
+		t.Fatalf("packet is %d bytes, want %d", len(packet), IPDiscoveryPacketSize)
+	}
+	got, err := decodeIPDiscovery(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Fatalf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestIPDiscoveryRequestLayout(t *testing.T) {
+	packet := encodeIPDiscovery(&structs.VoiceIPDiscovery{
+		Type:   IPDiscoveryTypeRequest,
+		Length: IPDiscoveryLength,
+		SSRC:   0x01020304,
+	})
+	head := []byte{0x00, 0x01, 0x00, 0x46, 0x01, 0x02, 0x03, 0x04}
+	for i, b := range head {
+		if packet[i] != b {
+			t.Fatalf("byte %d is 0x%02x, want 0x%02x", i, packet[i], b)
+		}
+	}
+}
+
+func TestIPDiscoveryWrongLength(t *testing.T) {
+	packet := encodeIPDiscovery(response(1, "127.0.0.1", 1))
+	if _, err := decodeIPDiscovery(packet[:IPDiscoveryPacketSize-1]); !errors.Is(err, ErrIPDiscoveryMalformed) {
+		t.Fatalf("short packet: got %v, want %v", err, ErrIPDiscoveryMalformed)
+	}
+	d := response(1, "127.0.0.1", 1)
+	d.Length = 69
+	if _, err := decodeIPDiscovery(encodeIPDiscovery(d)); !errors.Is(err, ErrIPDiscoveryMalformed) {
+		t.Fatalf("length field: got %v, want %v", err, ErrIPDiscoveryMalformed)
+	}
+}
+
+func TestIPDiscoveryWrongType(t *testing.T) {
+	d := response(1, "127.0.0.1", 1)
+	d.Type = IPDiscoveryTypeRequest
+	if _, err := decodeIPDiscovery(encodeIPDiscovery(d)); !errors.Is(err, ErrIPDiscoveryUnexpected) {
+		t.Fatalf("got %v, want %v", err, ErrIPDiscoveryUnexpected)
+	}
+}
+
+func TestIPDiscoveryAddress(t *testing.T) {
+	d := response(1, "192.0.2.1", 1)
+	addr, err := ipDiscoveryAddress(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "192.0.2.1" {
+		t.Fatalf("address %q, want %q", addr, "192.0.2.1")
+	}
+	// Bytes after the terminator are ignored.
+	copy(d.Address[10:], "garbage")
+	if addr, err := ipDiscoveryAddress(d); err != nil || addr != "192.0.2.1" {
+		t.Fatalf("got %q, %v", addr, err)
+	}
+	if _, err := ipDiscoveryAddress(response(1, "not an ip", 1)); !errors.Is(err, ErrIPDiscoveryMalformed) {
+		t.Fatalf("got %v, want %v", err, ErrIPDiscoveryMalformed)
+	}
+}
+
+// udpPair returns a client connected to a local server.
+func udpPair(t *testing.T) (*net.UDPConn, *net.UDPConn) {
+	t.Helper()
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	client, err := net.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, server
+}
+
+func TestReadIPDiscoverySkipsStrayPackets(t *testing.T) {
+	client, server := udpPair(t)
+	to := client.LocalAddr()
+	packets := [][]byte{
+		[]byte("stray"),
+		encodeIPDiscovery(response(2, "198.51.100.2", 2)),
+		encodeIPDiscovery(response(1, "198.51.100.1", 50000)),
+	}
+	for _, p := range packets {
+		if _, err := server.WriteTo(p, to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr, port, err := readIPDiscovery(client, 1, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "198.51.100.1" || port != 50000 {
+		t.Fatalf("got %s:%d", addr, port)
+	}
+}
+
+func TestReadIPDiscoverySSRCMismatch(t *testing.T) {
+	client, server := udpPair(t)
+	if _, err := server.WriteTo(encodeIPDiscovery(response(2, "198.51.100.2", 2)), client.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := readIPDiscovery(client, 1, time.Now().Add(100*time.Millisecond))
+	if !errors.Is(err, ErrIPDiscoverySSRCMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrIPDiscoverySSRCMismatch)
+	}
+}
@@ -0,0 +1,47 @@
+package voice
+
+import (
+	"context"
+
+	"github.com/hendrywilliam/siren/src/audio"
+)
+
+// PlayOverlay mixes source over the music, ducking it. The overlay is
+// streamed on its own if nothing is playing.
+func (v *Voice) PlayOverlay(source audio.AudioSource) error {
+	if !v.canPlay() {
+		return ErrVoiceNotReady
+	}
+	if err := v.overlay.Add(v.ctx, source, v.volume.Gain); err != nil {
+		return err
+	}
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	if v.audioCancelFunc != nil {
+		return nil
+	}
+	v.startOverlay()
+	return nil
+}
+
+// Must be called with playMu held.
+func (v *Voice) startOverlay() {
+	if !v.audioSender.IsPaused() {
+		if err := v.setSpeaking(SpeakingModeMicrophone); err != nil {
+			v.log.Error(err.Error())
+		}
+	}
+	v.overlayOnly = true
+	v.trackOffset, v.trackStart, v.trackSpeed = 0, 0, 1
+	v.audioCtx, v.audioCancelFunc = context.WithCancel(v.ctx)
+	v.audioDataChan = make(chan []byte)
+	v.audioIsEncoded = make(chan bool)
+	v.audioIsFinished = make(chan bool)
+	go func(ctx context.Context, opus audio.OpusOptions, data chan []byte, done chan bool) {
+		if err := v.audio.EncodeOverlay(ctx, v.overlay, opus, data, done); err != nil {
+			v.log.Error(err.Error())
+		}
+	}(v.audioCtx, v.opus, v.audioDataChan, v.audioIsEncoded)
+	go v.send(v.audioCtx, v.audioDataChan, v.audioIsEncoded, v.audioIsFinished)
+	go v.waitTrack(v.audioCtx, v.audioIsFinished)
+}
@@ -0,0 +1,408 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/audiosender"
+	"github.com/hendrywilliam/siren/src/ffmpeg"
+	"github.com/hendrywilliam/siren/src/queue"
+	"github.com/hendrywilliam/siren/src/structs"
+)
+
+var (
+	ErrNotPlaying = errors.New("nothing is playing")
+)
+
+// Play starts the next queued track if nothing is playing.
+func (v *Voice) Play() {
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	if !v.canPlay() || (v.audioCancelFunc != nil && !v.overlayOnly) {
+		// Playback starts on session description.
+		return
+	}
+	// Overlays carry on in the track stream.
+	v.stopTrack()
+	v.playNext(false)
+}
+
+// Connected and keyed for sending audio.
+func (v *Voice) canPlay() bool {
+	return v.IsReady() && v.secretKeys != [32]byte{}
+}
+
+// Skip current track and play the next one.
+func (v *Voice) Skip() {
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	v.stopTrack()
+	v.playNext(false)
+}
+
+// Pause keeps the current position, playback continues on Resume.
+func (v *Voice) Pause() error {
+	if !v.IsPlaying() {
+		return ErrNotPlaying
+	}
+	v.clientsMu.Lock()
+	v.autoPaused = false
+	v.clientsMu.Unlock()
+	v.audioSender.Pause()
+	return v.setSpeaking(0)
+}
+
+func (v *Voice) Resume() error {
+	if !v.IsPlaying() {
+		return ErrNotPlaying
+	}
+	v.clientsMu.Lock()
+	v.autoPaused = false
+	v.clientsMu.Unlock()
+	if err := v.setSpeaking(SpeakingModeMicrophone); err != nil {
+		return err
+	}
+	v.audioSender.Resume()
+	return nil
+}
+
+func (v *Voice) IsPaused() bool {
+	return v.audioSender.IsPaused()
+}
+
+// Stop current track without advancing the queue.
+func (v *Voice) Stop() error {
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	if v.audioCancelFunc == nil {
+		return ErrNotPlaying
+	}
+	v.stopTrack()
+	v.queue.Stop()
+	// The next track must not start paused.
+	v.clientsMu.Lock()
+	v.autoPaused = false
+	v.clientsMu.Unlock()
+	v.audioSender.Resume()
+	return v.setSpeaking(0)
+}
+
+// Seek restarts the current track at the given position.
+func (v *Voice) Seek(position time.Duration) error {
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	track, ok := v.queue.Current()
+	if !ok || v.audioCancelFunc == nil {
+		return ErrNotPlaying
+	}
+	if position < 0 {
+		position = 0
+	}
+	v.stopTrack()
+	v.startTrack(track, position, false)
+	return nil
+}
+
+// Position returns the playback position within the current track.
+func (v *Voice) Position() time.Duration {
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	if v.audioCancelFunc == nil {
+		return 0
+	}
+	return v.position()
+}
+
+// Must be called with playMu held.
+func (v *Voice) position() time.Duration {
+	// Previous track may still be fading out.
+	played := max(v.audioSender.Played()-v.trackStart, 0)
+	return v.trackOffset + time.Duration(float64(played)*v.trackSpeed)
+}
+
+// SetFilters replaces the filter chain, the current track restarts at its
+// position with the new filters.
+func (v *Voice) SetFilters(filters audio.Chain) {
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	v.filters = filters
+	v.restartTrack()
+}
+
+func (v *Voice) Filters() audio.Chain {
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	return v.filters
+}
+
+// SetBitrate re-tunes the encoder to the channel bitrate, the current track
+// restarts at its position if the settings changed.
+func (v *Voice) SetBitrate(bitrate int) {
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	opus := audio.TuneOpus(bitrate, v.opusOverrides)
+	if opus == v.opus {
+		return
+	}
+	v.log.Info("encoder tuned.", "bitrate", opus.Bitrate, "frame_duration", opus.FrameDuration.String(), "packet_loss", opus.PacketLoss)
+	v.opus = opus
+	if !v.overlayOnly {
+		v.restartTrack()
+	}
+}
+
+// Restarts the current track at its position, if any.
+// Must be called with playMu held.
+func (v *Voice) restartTrack() {
+	track, ok := v.queue.Current()
+	if !ok || v.audioCancelFunc == nil {
+		return
+	}
+	position := v.position()
+	v.stopTrack()
+	v.startTrack(track, position, false)
+}
+
+// NowPlaying returns the current track.
+func (v *Voice) NowPlaying() (queue.Track, bool) {
+	return v.queue.Current()
+}
+
+// IsPlaying reports whether a track is playing, overlays alone do not count.
+func (v *Voice) IsPlaying() bool {
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	return v.audioCancelFunc != nil && !v.overlayOnly
+}
+
+// Must be called with playMu held.
+func (v *Voice) playNext(ended bool) {
+	track, ok := v.next(ended)
+	if !ok {
+		v.log.Info("queue is empty.")
+		if err := v.setSpeaking(0); err != nil {
+			v.log.Error(err.Error())
+		}
+		return
+	}
+	v.startTrack(track, 0, true)
+}
+
+// next makes the following track current. The loop mode applies once the
+// current track ended, autoplay once the queue is empty.
+// Must be called with playMu held.
+func (v *Voice) next(ended bool) (queue.Track, bool) {
+	var track queue.Track
+	var ok bool
+	if ended {
+		track, ok = v.queue.Advance()
+	} else {
+		track, ok = v.queue.Dequeue()
+	}
+	if ok || v.recommend == nil || !v.queue.Autoplay() {
+		return track, ok
+	}
+	track, ok = v.recommend(v.queue.History())
+	if !ok {
+		return queue.Track{}, false
+	}
+	track.Autoplay = true
+	track.AddedAt = time.Now()
+	v.queue.Enqueue(track)
+	return v.queue.Dequeue()
+}
+
+// startTrack streams track from offset. With announce the track is announced
+// first, if the guild enabled announcements, it is set for tracks starting
+// from the queue while seeks and restarts are not announced again.
+// Must be called with playMu held.
+func (v *Voice) startTrack(track queue.Track, offset time.Duration, announce bool) {
+	v.log.Info("playing track.", "name", track.Name, "offset", offset.String())
+	if !v.audioSender.IsPaused() {
+		if err := v.setSpeaking(SpeakingModeMicrophone); err != nil {
+			v.log.Error(err.Error())
+		}
+	}
+	v.overlayOnly = false
+	v.trackOffset = offset
+	v.trackSpeed = v.filters.Speed()
+	v.trackStart = 0
+	v.audioCtx, v.audioCancelFunc = context.WithCancel(v.ctx)
+	v.audioDataChan = make(chan []byte)
+	v.audioIsEncoded = make(chan bool)
+	v.audioIsFinished = make(chan bool)
+	source, opts := v.encodeOptions(v.audioCtx, track, offset)
+	if announce {
+		source, opts = v.announce(v.audioCtx, track, source, opts)
+	}
+	go v.encode(v.audioCtx, source, opts, v.audioDataChan, v.audioIsEncoded)
+	go v.send(v.audioCtx, v.audioDataChan, v.audioIsEncoded, v.audioIsFinished)
+	go v.waitTrack(v.audioCtx, v.audioIsFinished)
+}
+
+func (v *Voice) encode(ctx context.Context, source audio.AudioSource, opts audio.EncodeOptions, data chan []byte, done chan bool) {
+	err := v.audio.Encode(ctx, source, opts, data, done)
+	switch {
+	case err == nil, ctx.Err() != nil, errors.Is(err, ffmpeg.ErrKilled):
+		// Skipped or stopped.
+	case errors.Is(err, ffmpeg.ErrFileNotFound), errors.Is(err, ffmpeg.ErrUnsupportedCodec):
+		v.log.Warn("track can not be played, skipping.", "source", source.String(), "error", err.Error())
+	case errors.Is(err, ffmpeg.ErrBusy):
+		v.log.Warn("no ffmpeg available, skipping.", "source", source.String())
+	default:
+		v.log.Error(err.Error(), "source", source.String())
+	}
+}
+
+// Must be called with playMu held.
+func (v *Voice) encodeOptions(ctx context.Context, track queue.Track, offset time.Duration) (audio.AudioSource, audio.EncodeOptions) {
+	opts := audio.EncodeOptions{
+		Offset:  offset,
+		Volume:  v.volume,
+		Filters: v.filters,
+		Overlay: v.overlay,
+		Opus:    v.opus,
+	}
+	source := track.Source
+	if source == nil {
+		source = audio.NewMediaSource(track.Name)
+	}
+	opts.GainDB = v.loudnessGain(source)
+	if v.cache != nil {
+		// After loudness, analysis is keyed by the original file. The
+		// transcode has the gain applied.
+		if cached, ok := v.cache.Lookup(v.ctx, source, opts); ok {
+			source = cached
+			opts.GainDB = 0
+		}
+	}
+	if d, ok := v.crossfade.Get(); ok {
+		opts.Crossfade = d
+		opts.Next = func(position time.Duration) (audio.AudioSource, audio.EncodeOptions, bool) {
+			return v.nextTrack(ctx, position)
+		}
+	}
+	return source, opts
+}
+
+// nextTrack continues the stream with the next queued track, which becomes
+// current as soon as it starts fading in.
+func (v *Voice) nextTrack(ctx context.Context, position time.Duration) (audio.AudioSource, audio.EncodeOptions, bool) {
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	if v.audioCtx != ctx {
+		// Skipped meanwhile.
+		return nil, audio.EncodeOptions{}, false
+	}
+	if _, ok := v.crossfade.Get(); !ok {
+		// Disabled meanwhile, the next track starts on its own.
+		return nil, audio.EncodeOptions{}, false
+	}
+	track, ok := v.next(true)
+	if !ok {
+		return nil, audio.EncodeOptions{}, false
+	}
+	v.log.Info("playing track.", "name", track.Name, "transition", position.String())
+	v.trackOffset = 0
+	v.trackSpeed = v.filters.Speed()
+	v.trackStart = position
+	source, opts := v.encodeOptions(ctx, track, 0)
+	source, opts = v.announce(ctx, track, source, opts)
+	return source, opts, true
+}
+
+// Gain normalizing the source to the guild target. Files not analyzed yet
+// are measured in background and normalized from their next play.
+func (v *Voice) loudnessGain(source audio.AudioSource) float64 {
+	if v.loudness == nil || v.loudnessTarget == nil {
+		return 0
+	}
+	target, ok := v.loudnessTarget.Get()
+	if !ok {
+		return 0
+	}
+	ps, ok := source.(audio.PathSource)
+	if !ok {
+		return 0
+	}
+	stats, ok := v.loudness.Lookup(ps.Path())
+	if !ok {
+		go func() {
+			if _, err := v.loudness.Analyze(v.ctx, ps.Path()); err != nil && v.ctx.Err() == nil {
+				v.log.Warn("loudness analysis failed.", "path", ps.Path(), "error", err.Error())
+			}
+		}()
+		return 0
+	}
+	return stats.Gain(target)
+}
+
+// Must be called with playMu held.
+func (v *Voice) stopTrack() {
+	if v.audioCancelFunc != nil {
+		v.audioCancelFunc()
+		v.audioCancelFunc = nil
+	}
+}
+
+// send streams the encoded frames, finished is signaled once all were sent.
+func (v *Voice) send(ctx context.Context, data <-chan []byte, encoded <-chan bool, finished chan<- bool) {
+	if err := v.audioSender.Send(ctx, v.udpConn, v.secretKeys, data, encoded, finished); err != nil {
+		v.log.Error(err.Error())
+	}
+	stats := v.audioSender.Stats()
+	if stats.Underruns > 0 {
+		v.log.Warn("audio buffer ran dry.", "underruns", stats.Underruns, "silence_frames", stats.SilenceFrames)
+	}
+	v.log.Debug("audio buffer.", "buffered", stats.Buffered, "capacity", stats.Capacity, "low_water", stats.LowWater)
+}
+
+// BufferStats returns the state of the buffer between encoder and sender.
+func (v *Voice) BufferStats() audiosender.Stats {
+	return v.audioSender.Stats()
+}
+
+// Advance the queue once the track has been fully sent.
+func (v *Voice) waitTrack(ctx context.Context, finished <-chan bool) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-finished:
+	}
+	v.playMu.Lock()
+	defer v.playMu.Unlock()
+	if v.audioCtx != ctx {
+		// Skipped meanwhile.
+		return
+	}
+	v.stopTrack()
+	if v.overlayOnly {
+		// Queue was not playing before the overlay.
+		if err := v.setSpeaking(0); err != nil {
+			v.log.Error(err.Error())
+		}
+		return
+	}
+	v.playNext(true)
+}
+
+// Speaking must be sent before audio, 0 once audio stops.
+func (v *Voice) setSpeaking(mode int) error {
+	speakingEvent := &structs.Event{
+		Op: OpcodeSpeaking,
+		D: &structs.Speaking{
+			Speaking: mode,
+			Delay:    0,
+			SSRC:     v.ssrc,
+		},
+	}
+	data, err := json.Marshal(speakingEvent)
+	if err != nil {
+		return err
+	}
+	return v.sendEvent(websocket.TextMessage, data)
+}
@@ -3,22 +3,27 @@ package voice
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/url"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/audioreceiver"
 	"github.com/hendrywilliam/siren/src/audiosender"
+	"github.com/hendrywilliam/siren/src/dave"
+	"github.com/hendrywilliam/siren/src/loudness"
+	"github.com/hendrywilliam/siren/src/queue"
+	"github.com/hendrywilliam/siren/src/recorder"
 	"github.com/hendrywilliam/siren/src/structs"
+	"github.com/hendrywilliam/siren/src/transcache"
+	"github.com/hendrywilliam/siren/src/tts"
 )
 
 type VoiceGatewayStatus = string
@@ -82,8 +87,12 @@ const (
 	UnknownEncryption    VoiceCloseCode = 4016
 )
 
+// Voice gateway version, 8 is the first supporting DAVE.
+var GATEWAY_VERSION = 8
+
 var (
 	ErrUnrecognizedEvent = errors.New("unrecognized event")
+	ErrVoiceNotReady     = errors.New("voice is not ready")
 )
 
 type Voice struct {
@@ -94,9 +103,8 @@ type Voice struct {
 	ctx        context.Context
 	cancelFunc context.CancelFunc
 
-	status     VoiceGatewayStatus
-	botVersion uint
-	sequence   atomic.Uint64
+	status   VoiceGatewayStatus
+	sequence atomic.Uint64
 
 	heartbeatTicker *time.Ticker
 
@@ -109,44 +117,153 @@ type Voice struct {
 	// Voice identifier
 	SessionID       string
 	ServerID        string // Guild ID
+	ChannelID       string
 	UserID          string
 	VoiceGatewayURL string
 	Token           string
 
 	// Audio state, APIs, data.
 	secretKeys  [32]byte
+	dave        *dave.Session
 	audio       *audio.Audio
 	audioSender *audiosender.AudioSender
 
+	audioReceiver *audioreceiver.AudioReceiver
+	recorder      *recorder.Recorder
+
+	queue     *queue.Queue
+	recommend queue.Recommender
+	volume    *audio.Volume
+	crossfade *audio.Crossfade
+	overlay   *audio.Overlay
+
+	loudness       *loudness.Analyzer
+	loudnessTarget *loudness.Target
+	cache          *transcache.Cache
+
+	speaker       *tts.Speaker
+	announcements *tts.Announcements
+	announcement  func(queue.Track) string
+
+	// Current track, guarded by playMu.
+	playMu      sync.Mutex
+	trackOffset time.Duration
+	filters     audio.Chain
+	// Encoder tuned to the channel bitrate.
+	opus          audio.OpusOptions
+	opusOverrides audio.OpusOverrides
+	trackSpeed    float64
+	// Output position the track started at, see nextTrack.
+	trackStart time.Duration
+	// Streaming overlays alone, no track is playing.
+	overlayOnly     bool
 	audioCtx        context.Context
 	audioCancelFunc context.CancelFunc
 
 	audioDataChan   chan []byte
+	audioIsEncoded  chan bool
 	audioIsFinished chan bool
+
+	// Users connected to the voice channel.
+	clientsMu   sync.Mutex
+	clients     map[string]struct{}
+	autoPaused  bool
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	onIdle      func()
 }
 
 type NewVoiceArguments struct {
-	SessionID  string
-	BotVersion uint
-	ServerID   string
-	UserID     string
+	SessionID string
+	ServerID  string
+	ChannelID string
+	UserID    string
+
+	// Guild queue, tracks are played in order.
+	Queue *queue.Queue
+	// Picks tracks once the queue runs out with autoplay on, optional.
+	Recommend queue.Recommender
+	// Guild volume, unity if nil.
+	Volume *audio.Volume
+
+	// Transitions between tracks, disabled if nil.
+	Crossfade *audio.Crossfade
+
+	// Optional, tracks are normalized to the target once analyzed.
+	Loudness       *loudness.Analyzer
+	LoudnessTarget *loudness.Target
+
+	// Optional, local files are streamed from their cached transcode.
+	Cache *transcache.Cache
+
+	// Optional, tracks are announced with the text returned by
+	// Announcement as the guild's Announcements setting says.
+	Speaker       *tts.Speaker
+	Announcements *tts.Announcements
+	Announcement  func(queue.Track) string
+
+	// Channel bitrate in bits per second the encoder is tuned to, unknown
+	// if 0. Opus settings fixed by configuration win.
+	Bitrate int
+	Opus    audio.OpusOverrides
+
+	// Audio buffered ahead of the sender, audiosender.DEFAULT_BUFFER if
+	// the size is 0.
+	Buffer audiosender.Buffer
+
+	// Optional, enables DAVE end-to-end encryption.
+	MLS dave.MLS
+
+	// Leave after the channel has been empty for IdleTimeout, 0 to stay.
+	// OnIdle is called instead of closing the connection, if set.
+	IdleTimeout time.Duration
+	OnIdle      func()
 
 	Log *slog.Logger
 }
 
 func NewVoice(args NewVoiceArguments) *Voice {
+	daveSession := dave.NewSession(dave.Options{
+		UserID: args.UserID,
+		MLS:    args.MLS,
+	})
+	volume := args.Volume
+	if volume == nil {
+		volume = audio.NewVolume()
+	}
+	crossfade := args.Crossfade
+	if crossfade == nil {
+		crossfade = audio.NewCrossfade()
+	}
 	return &Voice{
-		wsDialer:        websocket.DefaultDialer,
-		status:          StatusDisconnected,
-		log:             args.Log.With("voice_id", fmt.Sprintf("voice_%s", args.SessionID)),
-		botVersion:      args.BotVersion,
-		SessionID:       args.SessionID,
-		UserID:          args.UserID,
-		ServerID:        args.ServerID,
-		audio:           &audio.Audio{},
-		audioSender:     &audiosender.AudioSender{},
-		audioDataChan:   make(chan []byte),
-		audioIsFinished: make(chan bool),
+		wsDialer:      websocket.DefaultDialer,
+		status:        StatusDisconnected,
+		log:           args.Log.With("voice_id", fmt.Sprintf("voice_%s", args.SessionID)),
+		SessionID:     args.SessionID,
+		UserID:        args.UserID,
+		ServerID:      args.ServerID,
+		ChannelID:     args.ChannelID,
+		dave:          daveSession,
+		audio:         &audio.Audio{},
+		audioSender:   &audiosender.AudioSender{FrameEncryptor: daveSession, Buffer: args.Buffer},
+		audioReceiver: audioreceiver.NewAudioReceiver(daveSession),
+		queue:         args.Queue,
+		recommend:     args.Recommend,
+		volume:        volume,
+		crossfade:     crossfade,
+		overlay:       audio.NewOverlay(),
+		opus:          audio.TuneOpus(args.Bitrate, args.Opus),
+		opusOverrides: args.Opus,
+
+		loudness:       args.Loudness,
+		loudnessTarget: args.LoudnessTarget,
+		cache:          args.Cache,
+		speaker:        args.Speaker,
+		announcements:  args.Announcements,
+		announcement:   args.Announcement,
+		clients:        make(map[string]struct{}),
+		idleTimeout:    args.IdleTimeout,
+		onIdle:         args.OnIdle,
 	}
 }
 
@@ -160,7 +277,7 @@ func (v *Voice) open(ctx context.Context) error {
 	url := url.URL{
 		Scheme:   "wss",
 		Host:     v.VoiceGatewayURL,
-		RawQuery: fmt.Sprintf("v=%d", v.botVersion),
+		RawQuery: fmt.Sprintf("v=%d", GATEWAY_VERSION),
 	}
 	v.wsConn, _, err = v.wsDialer.DialContext(v.ctx, url.String(), nil)
 	if err != nil {
@@ -170,10 +287,11 @@ func (v *Voice) open(ctx context.Context) error {
 	identifyEvent := &structs.Event{
 		Op: OpcodeIdentify,
 		D: structs.VoiceIdentify{
-			ServerId:  v.ServerID,
-			UserID:    v.UserID,
-			SessionID: v.SessionID,
-			Token:     v.Token,
+			ServerId:               v.ServerID,
+			UserID:                 v.UserID,
+			SessionID:              v.SessionID,
+			Token:                  v.Token,
+			MaxDaveProtocolVersion: v.dave.MaxProtocolVersion(),
 		},
 	}
 	data, err := json.Marshal(identifyEvent)
@@ -224,6 +342,7 @@ func (v *Voice) open(ctx context.Context) error {
 		v.port = readyEvent.Port
 		v.encryptionMode = "aead_xchacha20_poly1305_rtpsize"
 		v.ssrc = readyEvent.SSRC
+		v.audioSender.SetSSRC(readyEvent.SSRC)
 
 		go v.listen(v.wsConn)
 
@@ -253,6 +372,10 @@ func (v *Voice) listen(conn *websocket.Conn) {
 			messageType, message, err := conn.ReadMessage()
 			v.log.Info("event", "incoming_event", message)
 			if err != nil {
+				if v.ctx.Err() != nil {
+					// Connection closed.
+					return
+				}
 				v.log.Error(err.Error())
 				// @todo
 				panic(err)
@@ -267,6 +390,9 @@ func (v *Voice) listen(conn *websocket.Conn) {
 
 func (v *Voice) acceptEvent(messageType int, rawMessage []byte) (*structs.RawEvent, error) {
 	var err error
+	if messageType == websocket.BinaryMessage {
+		return nil, v.acceptBinaryEvent(rawMessage)
+	}
 	reader := bytes.NewBuffer(rawMessage)
 
 	e := &structs.RawEvent{}
@@ -274,6 +400,10 @@ func (v *Voice) acceptEvent(messageType int, rawMessage []byte) (*structs.RawEve
 	if err = decoder.Decode(&e); err != nil {
 		return e, err
 	}
+	if e.Seq > 0 {
+		// Acknowledged by heartbeats.
+		v.sequence.Store(e.Seq)
+	}
 
 	switch e.Op {
 	case OpcodeHeartbeatAck:
@@ -291,50 +421,113 @@ func (v *Voice) acceptEvent(messageType int, rawMessage []byte) (*structs.RawEve
 		// Get secret_keys to encrypt data and encryption mode.
 		v.secretKeys = sessionDescriptionEvent.SecretKey
 		v.encryptionMode = sessionDescriptionEvent.Mode
+		v.dave.SetProtocolVersion(uint16(sessionDescriptionEvent.DaveProtocolVersion))
 
 		// We need to send speaking event first.
 		// Then we can start sending encrypted audio data.
-		speakingEvent := &structs.Event{
-			Op: OpcodeSpeaking,
-			D: &structs.Speaking{
-				Speaking: SpeakingModeMicrophone,
-				Delay:    0,
-				SSRC:     v.ssrc,
-			},
+		if err := v.setSpeaking(SpeakingModeMicrophone); err != nil {
+			return nil, err
 		}
 
-		v.log.Info("event", "speaking_event", speakingEvent)
+		v.Play()
+		go v.receive()
 
-		var err error
-		data, err := json.Marshal(speakingEvent)
-		if err != nil {
-			return nil, err
-		}
-		err = v.sendEvent(websocket.BinaryMessage, data)
+		// Nobody may have joined yet, wait for clients connect.
+		v.updateOccupancy()
 
-		if err != nil {
+		return e, nil
+
+	case OpcodeSpeaking:
+		speakingEvent := &structs.Speaking{}
+		if err := json.Unmarshal(e.D, speakingEvent); err != nil {
 			return nil, err
 		}
+		if speakingEvent.UserID != "" {
+			v.audioReceiver.SetUser(speakingEvent.SSRC, speakingEvent.UserID)
+		}
+		return e, nil
 
-		v.audioCtx, v.audioCancelFunc = context.WithCancel(v.ctx)
-		go v.audio.Encode(v.audioCtx, "sirens.mp3", v.audioDataChan, v.audioIsFinished)
-		go v.audioSender.Send(v.audioCtx, v.udpConn, v.secretKeys, v.audioDataChan, v.audioIsFinished)
+	case OpcodeClientsConnect, OpcodeClientDisconnect:
+		return e, v.acceptClientsEvent(e)
 
-		return e, nil
+	case DAVEPrepareTransition, DAVEExecuteTransition, DAVEPrepareEpoch:
+		return e, v.acceptDAVEEvent(e)
 	default:
 		v.log.Info("event", "any", e)
 		return e, nil
 	}
 }
 
+// Receive subscribes to a stream of opus packets for every user speaking in
+// the channel, the subscription must be closed once done.
+func (v *Voice) Receive() *audioreceiver.Subscription {
+	return v.audioReceiver.Subscribe()
+}
+
+// StartRecording writes every speaking user's audio into its own file.
+// Returns the directory recordings are written to.
+func (v *Voice) StartRecording(opts recorder.Options) (string, error) {
+	if !v.IsReady() {
+		return "", ErrVoiceNotReady
+	}
+	v.rwlock.Lock()
+	if v.recorder == nil || !v.recorder.IsRecording() {
+		v.recorder = recorder.NewRecorder(opts)
+	}
+	r := v.recorder
+	v.rwlock.Unlock()
+	return r.Start(v.ctx, v.Receive())
+}
+
+func (v *Voice) StopRecording() error {
+	v.rwlock.RLock()
+	r := v.recorder
+	v.rwlock.RUnlock()
+	if r == nil {
+		return recorder.ErrNotRecording
+	}
+	return r.Stop()
+}
+
+func (v *Voice) IsReady() bool {
+	return v.status == StatusReady
+}
+
+func (v *Voice) receive() {
+	err := v.audioReceiver.Listen(v.ctx, v.udpConn, v.secretKeys, v.encryptionMode)
+	if err != nil {
+		v.log.Error(err.Error())
+	}
+	v.log.Info("audio receiver stopped.")
+}
+
+// Close the voice connection.
+func (v *Voice) Close() {
+	v.close()
+}
+
 func (v *Voice) close() {
+	v.clientsMu.Lock()
+	if v.idleTimer != nil {
+		v.idleTimer.Stop()
+		v.idleTimer = nil
+	}
+	v.clientsMu.Unlock()
 	if v.heartbeatTicker != nil {
 		v.heartbeatTicker.Stop()
 		v.heartbeatTicker = nil
 	}
 	v.status = StatusDisconnected
-	v.cancelFunc()
-	v.wsConn.Close()
+	if v.cancelFunc != nil {
+		v.cancelFunc()
+	}
+	v.overlay.Close()
+	if v.wsConn != nil {
+		v.wsConn.Close()
+	}
+	if v.udpConn != nil {
+		v.udpConn.Close()
+	}
 	v.log.Info("connection closed.")
 	return
 }
@@ -360,7 +553,7 @@ func (v *Voice) heartbeating(dur time.Duration) error {
 			if err != nil {
 				return err
 			}
-			err = v.sendEvent(websocket.BinaryMessage, data)
+			err = v.sendEvent(websocket.TextMessage, data)
 			if err != nil {
 				v.log.Error(err.Error())
 				return err
@@ -381,38 +574,31 @@ func (v *Voice) sendEvent(messageType int, data []byte) error {
 }
 
 func (v *Voice) sendIPDiscovery() error {
-	var packet []byte
-	packet = binary.BigEndian.AppendUint16(packet, uint16(0x1))
-	packet = binary.BigEndian.AppendUint16(packet, uint16(70))
-	packet = binary.BigEndian.AppendUint32(packet, v.ssrc)
-	var address [64]byte
-	copy(address[:], v.ip)
-	packet = append(packet, address[:]...)
-	packet = binary.BigEndian.AppendUint16(packet, v.port)
-	_, err := v.udpConn.Write(packet)
-	if err != nil {
-		return err
+	var err error
+	for attempt := 1; attempt <= IPDiscoveryMaxAttempts; attempt++ {
+		var ipAddr string
+		var port uint16
+		ipAddr, port, err = v.discoverIP()
+		if err == nil {
+			v.log.Info("ip discovered.", "address", ipAddr, "port", port)
+			return v.sendSelectProtocol(ipAddr, port)
+		}
+		v.log.Warn("ip discovery failed.", "attempt", attempt, "error", err.Error())
 	}
+	return fmt.Errorf("ip discovery failed after %d attempts: %w", IPDiscoveryMaxAttempts, err)
+}
 
-	b := make([]byte, 100)
-	_, err = v.udpConn.Read(b[:])
+func (v *Voice) discoverIP() (string, uint16, error) {
+	packet := encodeIPDiscovery(&structs.VoiceIPDiscovery{
+		Type:   IPDiscoveryTypeRequest,
+		Length: IPDiscoveryLength,
+		SSRC:   v.ssrc,
+	})
+	_, err := v.udpConn.Write(packet)
 	if err != nil {
-		return err
-	}
-
-	var ipAddrBuilder strings.Builder
-
-	// Should find a better way to get the ip address?
-	for i := 15; i < 30; i++ {
-		if b[i] == 0 {
-			break
-		}
-		ipAddrBuilder.Write(b[i : i+1])
+		return "", 0, err
 	}
-	ipAddr := ipAddrBuilder.String()
-	port := binary.BigEndian.Uint16(b[72:74])
-	fmt.Println(ipAddr, port)
-	return v.sendSelectProtocol(ipAddr, port)
+	return readIPDiscovery(v.udpConn, v.ssrc, time.Now().Add(IPDiscoveryTimeout))
 }
 
 func (v *Voice) sendSelectProtocol(ipAddr string, port uint16) error {
@@ -432,7 +618,7 @@ func (v *Voice) sendSelectProtocol(ipAddr string, port uint16) error {
 		v.log.Error(err.Error())
 		return err
 	}
-	err = v.sendEvent(websocket.BinaryMessage, data)
+	err = v.sendEvent(websocket.TextMessage, data)
 	if err != nil {
 		v.log.Error(err.Error())
 		return err
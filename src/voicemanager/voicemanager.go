@@ -3,19 +3,43 @@ package voicemanager
 import (
 	"sync"
 
+	"github.com/hendrywilliam/siren/src/audio"
+	"github.com/hendrywilliam/siren/src/loudness"
+	"github.com/hendrywilliam/siren/src/queue"
+	"github.com/hendrywilliam/siren/src/tts"
 	"github.com/hendrywilliam/siren/src/voice"
 )
 
 type GuildID = string
 
+// Guild state that outlives a voice session.
+type Guild struct {
+	Queue     *queue.Queue
+	Volume    *audio.Volume
+	Crossfade *audio.Crossfade
+	Loudness  *loudness.Target
+	// Spoken track announcements.
+	Announcements *tts.Announcements
+}
+
 type VoiceManager struct {
+	loudnessTarget float64
+
 	mu           sync.Mutex
 	activeVoices map[GuildID]*voice.Voice
+	guilds       map[GuildID]*Guild
+}
+
+type NewVoiceManagerArguments struct {
+	// Default target of new guilds in LUFS.
+	LoudnessTarget float64
 }
 
-func NewVoiceManager() VoiceManager {
+func NewVoiceManager(args NewVoiceManagerArguments) VoiceManager {
 	return VoiceManager{
-		activeVoices: make(map[string]*voice.Voice),
+		loudnessTarget: args.LoudnessTarget,
+		activeVoices:   make(map[string]*voice.Voice),
+		guilds:         make(map[string]*Guild),
 	}
 }
 
@@ -29,13 +53,15 @@ func (vm *VoiceManager) Add(guildId GuildID, voice *voice.Voice) {
 	return
 }
 
+// Delete voice session and its queue, guild settings are kept.
 func (vm *VoiceManager) Delete(guildID GuildID) {
-	if voice := vm.Get(guildID); voice == nil {
-		return
-	}
 	vm.mu.Lock()
 	defer vm.mu.Unlock()
 	delete(vm.activeVoices, guildID)
+	if g, ok := vm.guilds[guildID]; ok {
+		g.Queue.Clear()
+		g.Queue.Stop()
+	}
 	return
 }
 
@@ -44,3 +70,27 @@ func (vm *VoiceManager) Get(guildID GuildID) *voice.Voice {
 	defer vm.mu.Unlock()
 	return vm.activeVoices[guildID]
 }
+
+// Guild returns guild state, created if it does not exist.
+func (vm *VoiceManager) Guild(guildID GuildID) *Guild {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	g, ok := vm.guilds[guildID]
+	if !ok {
+		g = &Guild{
+			Queue:     queue.NewQueue(),
+			Volume:    audio.NewVolume(),
+			Crossfade: audio.NewCrossfade(),
+			Loudness:  loudness.NewTarget(vm.loudnessTarget),
+
+			Announcements: tts.NewAnnouncements(),
+		}
+		vm.guilds[guildID] = g
+	}
+	return g
+}
+
+// Queue returns guild queue, created if it does not exist.
+func (vm *VoiceManager) Queue(guildID GuildID) *queue.Queue {
+	return vm.Guild(guildID).Queue
+}
@@ -0,0 +1,392 @@
+package webm
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+)
+
+// Minimal streaming matroska/webm demuxer for a single opus audio track.
+// Source: https://www.matroska.org/technical/elements.html
+const (
+	idEBML        = 0x1A45DFA3
+	idSegment     = 0x18538067
+	idTracks      = 0x1654AE6B
+	idTrackEntry  = 0xAE
+	idTrackNumber = 0xD7
+	idTrackType   = 0x83
+	idCodecID     = 0x86
+	idCodecPriv   = 0x63A2
+	idAudio       = 0xE1
+	idChannels    = 0x9F
+	idCluster     = 0x1F43B675
+	idSimpleBlock = 0xA3
+	idBlockGroup  = 0xA0
+	idBlock       = 0xA1
+
+	trackTypeAudio = 2
+	codecOpus      = "A_OPUS"
+
+	// Elements larger than this are skipped instead of read.
+	maxElementSize = 16 << 20
+)
+
+var (
+	ErrInvalidElement = errors.New("invalid ebml element")
+	ErrNotOpus        = errors.New("no opus track in webm stream")
+	ErrInvalidBlock   = errors.New("invalid webm block")
+)
+
+// Unknown size, used by live streams for segment and cluster.
+const unknownSize = math.MaxUint64
+
+type Track struct {
+	Number       uint64
+	CodecID      string
+	CodecPrivate []byte
+	Channels     uint64
+}
+
+// OpusReader returns opus packets of the first opus track.
+type OpusReader struct {
+	r       *bufio.Reader
+	Track   Track
+	pending [][]byte
+}
+
+// NewOpusReader reads up to the tracks element.
+func NewOpusReader(r io.Reader) (*OpusReader, error) {
+	wr := &OpusReader{r: bufio.NewReader(r)}
+	id, size, err := wr.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if id != idEBML {
+		return nil, ErrInvalidElement
+	}
+	if err := wr.skip(size); err != nil {
+		return nil, err
+	}
+	for {
+		id, size, err := wr.readHeader()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, ErrNotOpus
+			}
+			return nil, err
+		}
+		switch id {
+		case idSegment:
+			// Descend.
+		case idTracks:
+			body, err := wr.readBody(size)
+			if err != nil {
+				return nil, err
+			}
+			track, ok := findOpusTrack(body)
+			if !ok {
+				return nil, ErrNotOpus
+			}
+			wr.Track = track
+			return wr, nil
+		case idCluster:
+			// Tracks must come before any cluster.
+			return nil, ErrNotOpus
+		default:
+			if err := wr.skip(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (wr *OpusReader) ReadPacket() ([]byte, error) {
+	for len(wr.pending) == 0 {
+		id, size, err := wr.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case idSegment, idCluster, idBlockGroup:
+			// Descend.
+		case idSimpleBlock, idBlock:
+			body, err := wr.readBody(size)
+			if err != nil {
+				return nil, err
+			}
+			frames, track, err := parseBlock(body)
+			if err != nil {
+				return nil, err
+			}
+			if track == wr.Track.Number {
+				wr.pending = frames
+			}
+		default:
+			if err := wr.skip(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	packet := wr.pending[0]
+	wr.pending = wr.pending[1:]
+	return packet, nil
+}
+
+func (wr *OpusReader) readHeader() (uint64, uint64, error) {
+	id, err := readVint(wr.r, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := readVint(wr.r, true)
+	if err != nil {
+		return 0, 0, unexpectedEOF(err)
+	}
+	return id, size, nil
+}
+
+func (wr *OpusReader) readBody(size uint64) ([]byte, error) {
+	if size == unknownSize || size > maxElementSize {
+		return nil, ErrInvalidElement
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(wr.r, body); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return body, nil
+}
+
+func (wr *OpusReader) skip(size uint64) error {
+	if size == unknownSize {
+		return ErrInvalidElement
+	}
+	_, err := wr.r.Discard(int(size))
+	return unexpectedEOF(err)
+}
+
+// readVint reads an ebml variable length integer. Ids keep their length
+// marker, sizes do not, an all ones size means unknown.
+func readVint(r io.ByteReader, isSize bool) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	length := 1
+	for mask := byte(0x80); mask != 0 && first&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, ErrInvalidElement
+	}
+	v := uint64(first)
+	if isSize {
+		v &= uint64(0xFF >> length)
+	}
+	allOnes := v == uint64(0xFF>>length)
+	for i := 1; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		if b != 0xFF {
+			allOnes = false
+		}
+		v = v<<8 | uint64(b)
+	}
+	if isSize && allOnes {
+		return unknownSize, nil
+	}
+	return v, nil
+}
+
+// Walk children of a master element body.
+func eachElement(b []byte, fn func(id uint64, body []byte) error) error {
+	for len(b) > 0 {
+		br := &byteReader{b: b}
+		id, err := readVint(br, false)
+		if err != nil {
+			return ErrInvalidElement
+		}
+		size, err := readVint(br, true)
+		if err != nil || size > uint64(len(b)-br.n) {
+			return ErrInvalidElement
+		}
+		body := b[br.n : br.n+int(size)]
+		if err := fn(id, body); err != nil {
+			return err
+		}
+		b = b[br.n+int(size):]
+	}
+	return nil
+}
+
+func findOpusTrack(tracks []byte) (Track, bool) {
+	var found Track
+	ok := false
+	eachElement(tracks, func(id uint64, body []byte) error {
+		if id != idTrackEntry || ok {
+			return nil
+		}
+		t := Track{}
+		var trackType uint64
+		eachElement(body, func(id uint64, body []byte) error {
+			switch id {
+			case idTrackNumber:
+				t.Number = readUint(body)
+			case idTrackType:
+				trackType = readUint(body)
+			case idCodecID:
+				t.CodecID = string(body)
+			case idCodecPriv:
+				t.CodecPrivate = body
+			case idAudio:
+				eachElement(body, func(id uint64, body []byte) error {
+					if id == idChannels {
+						t.Channels = readUint(body)
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if trackType == trackTypeAudio && t.CodecID == codecOpus {
+			found, ok = t, true
+		}
+		return nil
+	})
+	return found, ok
+}
+
+// Block: | track (vint) | timecode (2) | flags (1) | lacing | frames |
+func parseBlock(b []byte) ([][]byte, uint64, error) {
+	br := &byteReader{b: b}
+	track, err := readVint(br, true)
+	if err != nil || len(b) < br.n+3 {
+		return nil, 0, ErrInvalidBlock
+	}
+	flags := b[br.n+2]
+	data := b[br.n+3:]
+	switch (flags >> 1) & 0x3 {
+	case 0: // No lacing.
+		return [][]byte{data}, track, nil
+	case 1: // Xiph lacing.
+		return xiphLacing(data, track)
+	case 3: // EBML lacing.
+		return ebmlLacing(data, track)
+	default: // Fixed size lacing.
+		if len(data) < 1 {
+			return nil, 0, ErrInvalidBlock
+		}
+		count := int(data[0]) + 1
+		data = data[1:]
+		if len(data)%count != 0 {
+			return nil, 0, ErrInvalidBlock
+		}
+		size := len(data) / count
+		frames := make([][]byte, count)
+		for i := range frames {
+			frames[i] = data[i*size : (i+1)*size]
+		}
+		return frames, track, nil
+	}
+}
+
+func xiphLacing(data []byte, track uint64) ([][]byte, uint64, error) {
+	if len(data) < 1 {
+		return nil, 0, ErrInvalidBlock
+	}
+	count := int(data[0]) + 1
+	offset := 1
+	sizes := make([]int, count-1)
+	for i := range sizes {
+		for {
+			if offset >= len(data) {
+				return nil, 0, ErrInvalidBlock
+			}
+			sizes[i] += int(data[offset])
+			offset++
+			if data[offset-1] != 0xFF {
+				break
+			}
+		}
+	}
+	return splitFrames(data[offset:], sizes, track)
+}
+
+func ebmlLacing(data []byte, track uint64) ([][]byte, uint64, error) {
+	if len(data) < 1 {
+		return nil, 0, ErrInvalidBlock
+	}
+	count := int(data[0]) + 1
+	br := &byteReader{b: data, n: 1}
+	sizes := make([]int, count-1)
+	if count > 1 {
+		first, err := readVint(br, true)
+		if err != nil {
+			return nil, 0, ErrInvalidBlock
+		}
+		sizes[0] = int(first)
+		for i := 1; i < len(sizes); i++ {
+			start := br.n
+			raw, err := readVint(br, true)
+			if err != nil {
+				return nil, 0, ErrInvalidBlock
+			}
+			// Signed difference, bias is half the range of the vint length.
+			length := br.n - start
+			bias := int64(1)<<(7*length-1) - 1
+			sizes[i] = sizes[i-1] + int(int64(raw)-bias)
+		}
+	}
+	return splitFrames(data[br.n:], sizes, track)
+}
+
+// Last frame takes the remaining bytes.
+func splitFrames(data []byte, sizes []int, track uint64) ([][]byte, uint64, error) {
+	frames := make([][]byte, 0, len(sizes)+1)
+	for _, size := range sizes {
+		if size < 0 || size > len(data) {
+			return nil, 0, ErrInvalidBlock
+		}
+		frames = append(frames, data[:size])
+		data = data[size:]
+	}
+	return append(frames, data), track, nil
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// OpusChannels returns the channel count from codec private (OpusHead).
+func (t Track) OpusChannels() uint64 {
+	if len(t.CodecPrivate) >= 10 && string(t.CodecPrivate[:8]) == "OpusHead" {
+		return uint64(t.CodecPrivate[9])
+	}
+	return t.Channels
+}
+
+type byteReader struct {
+	b []byte
+	n int
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	if br.n >= len(br.b) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	c := br.b[br.n]
+	br.n++
+	return c, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
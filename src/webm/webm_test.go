@@ -0,0 +1,100 @@
+package webm
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// block builds a simple block of track 1 with lacing flags and a lacing
+// header followed by frames of the given sizes, frame i filled with byte i.
+func block(flags byte, header []byte, sizes ...int) []byte {
+	b := append([]byte{0x81, 0x00, 0x00, flags}, header...)
+	for i, size := range sizes {
+		b = append(b, bytes.Repeat([]byte{byte(i)}, size)...)
+	}
+	return b
+}
+
+func frameSizes(frames [][]byte) []int {
+	sizes := []int{}
+	for i, f := range frames {
+		for _, c := range f {
+			if c != byte(i) {
+				return nil
+			}
+		}
+		sizes = append(sizes, len(f))
+	}
+	return sizes
+}
+
+func TestParseBlockLacing(t *testing.T) {
+	tests := []struct {
+		name  string
+		block []byte
+		sizes []int
+		err   error
+	}{
+		{"none", block(0x80, nil, 20), []int{20}, nil},
+		{"xiph", block(0x82, []byte{2, 0xFF, 0x2D, 10}, 300, 10, 7), []int{300, 10, 7}, nil},
+		{"xiph 255", block(0x82, []byte{1, 0xFF, 0x00}, 255, 4), []int{255, 4}, nil},
+		{"fixed", block(0x84, []byte{2}, 6, 6, 6), []int{6, 6, 6}, nil},
+		// Example of the matroska specification: 800, 500 (-300), 500 (0)
+		// and the rest.
+		{"ebml", block(0x86, []byte{3, 0x43, 0x20, 0x5E, 0xD3, 0x5F, 0xFF}, 800, 500, 500, 1000), []int{800, 500, 500, 1000}, nil},
+		// One byte differences are biased by 63.
+		{"ebml one byte", block(0x86, []byte{2, 0x83, 0x80 | (63 + 2)}, 3, 5, 1), []int{3, 5, 1}, nil},
+		{"ebml single", block(0x86, []byte{0}, 9), []int{9}, nil},
+		{"ebml past data", block(0x86, []byte{1, 0x90}, 4), nil, ErrInvalidBlock},
+		{"ebml negative", block(0x86, []byte{2, 0x82, 0x80 | (63 - 5)}, 2, 2), nil, ErrInvalidBlock},
+		{"ebml truncated", block(0x86, []byte{1}), nil, ErrInvalidBlock},
+		{"fixed uneven", block(0x84, []byte{1}, 3, 2), nil, ErrInvalidBlock},
+		{"xiph truncated", block(0x82, []byte{1, 0xFF}), nil, ErrInvalidBlock},
+		{"short", []byte{0x81, 0x00}, nil, ErrInvalidBlock},
+	}
+	for _, tt := range tests {
+		frames, track, err := parseBlock(tt.block)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if track != 1 {
+			t.Errorf("%s: track %d", tt.name, track)
+		}
+		if got := frameSizes(frames); !slices.Equal(got, tt.sizes) {
+			t.Errorf("%s: frame sizes %v, want %v", tt.name, got, tt.sizes)
+		}
+	}
+}
+
+func TestReadVint(t *testing.T) {
+	tests := []struct {
+		b      []byte
+		isSize bool
+		v      uint64
+	}{
+		{[]byte{0x81}, true, 1},
+		{[]byte{0x40, 0x02}, true, 2},
+		{[]byte{0x1A, 0x45, 0xDF, 0xA3}, false, idEBML},
+		{[]byte{0xFF}, true, unknownSize},
+		{[]byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, true, unknownSize},
+		{[]byte{0x7F, 0xFF}, false, 0x7FFF},
+	}
+	for _, tt := range tests {
+		v, err := readVint(&byteReader{b: tt.b}, tt.isSize)
+		if err != nil || v != tt.v {
+			t.Errorf("%x: got %x, %v, want %x", tt.b, v, err, tt.v)
+		}
+	}
+	if _, err := readVint(&byteReader{b: []byte{0x00}}, true); !errors.Is(err, ErrInvalidElement) {
+		t.Errorf("no length marker: got %v", err)
+	}
+	if _, err := readVint(&byteReader{b: []byte{0x40}}, true); err == nil {
+		t.Error("truncated vint read")
+	}
+}